@@ -18,27 +18,51 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 
 	buildv1alpha1 "github.com/knative/build/pkg/apis/build/v1alpha1"
+	istiov1alpha3 "github.com/knative/pkg/apis/istio/v1alpha3"
 	servingv1alpha1 "github.com/knative/serving/pkg/apis/serving/v1alpha1"
 	"github.com/kyma-incubator/runtime/pkg/apis"
+	"github.com/kyma-incubator/runtime/pkg/buildlogs"
 	"github.com/kyma-incubator/runtime/pkg/controller"
+	"github.com/kyma-incubator/runtime/pkg/health"
+	"github.com/kyma-incubator/runtime/pkg/leader"
+	"github.com/kyma-incubator/runtime/pkg/utils"
 	"github.com/kyma-incubator/runtime/pkg/webhook"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/controller-runtime/pkg/leaderelection"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 	"sigs.k8s.io/controller-runtime/pkg/runtime/signals"
 )
 
 func main() {
-	var metricsAddr string
+	var metricsAddr, buildLogsAddr, healthAddr string
+	var enableLeaderElection bool
+	var leaderElectionNamespace, leaderElectionID string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&buildLogsAddr, "build-logs-addr", ":8090", "The address the build logs endpoint binds to.")
+	flag.StringVar(&healthAddr, "health-addr", ":8081", "The address the liveness and readiness endpoints bind to.")
+	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
+		"Enable leader election, only the leader of the replicas reconciles while all of them serve the webhooks.")
+	flag.StringVar(&leaderElectionNamespace, "leader-election-namespace", "",
+		"The namespace of the leader election lock, defaults to the namespace the manager runs in.")
+	flag.StringVar(&leaderElectionID, "leader-election-id", "runtime-controller-leader", "The name of the leader election lock.")
 	flag.Parse()
 	logf.SetLogger(logf.ZapLogger(false))
 	log := logf.Log.WithName("entrypoint")
 
+	// BUILD_TIMEOUT is given in minutes e.g. 20 or as a duration e.g. 1h30m
+	buildTimeout, err := utils.DefaultBuildTimeout()
+	if err != nil {
+		log.Error(err, "unable to parse BUILD_TIMEOUT")
+		os.Exit(1)
+	}
+	log.Info("resolved build timeout", "timeout", buildTimeout.String())
+
 	// Get a config to talk to the apiserver
 	log.Info("setting up client for manager")
 	cfg, err := config.GetConfig()
@@ -47,36 +71,39 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Create a new Cmd to provide shared dependencies and start components
+	// Create a new Cmd to provide shared dependencies and start components. Every replica runs it to serve the
+	// webhooks and the build logs from its cache.
 	log.Info("setting up manager")
-	mgr, err := manager.New(cfg, manager.Options{})
+	mgr, err := manager.New(cfg, manager.Options{MetricsBindAddress: metricsAddr})
 	if err != nil {
 		log.Error(err, "unable to set up overall controller manager")
 		os.Exit(1)
 	}
 
-	log.Info("Registering Components.")
-
-	// Setup Scheme for all resources
-	log.Info("setting up scheme")
-	if err := apis.AddToScheme(mgr.GetScheme()); err != nil {
-		log.Error(err, "unable add APIs to scheme")
+	// The controllers only run on the leader
+	log.Info("setting up leader election", "leaderElection", enableLeaderElection)
+	leaderMgr, err := leader.New(mgr, leaderelection.Options{
+		LeaderElection:          enableLeaderElection,
+		LeaderElectionNamespace: leaderElectionNamespace,
+		LeaderElectionID:        leaderElectionID,
+	})
+	if err != nil {
+		log.Error(err, "unable to set up leader election")
 		os.Exit(1)
 	}
 
-	if err := servingv1alpha1.AddToScheme(mgr.GetScheme()); err != nil {
-		log.Error(err, "unable add Serving APIs to scheme")
-		os.Exit(1)
-	}
+	log.Info("Registering Components.")
 
-	if err := buildv1alpha1.AddToScheme(mgr.GetScheme()); err != nil {
-		log.Error(err, "unable add Build APIs to scheme")
+	// Setup Scheme for all resources
+	log.Info("setting up scheme")
+	if err := addToScheme(mgr); err != nil {
+		log.Error(err, "unable to set up scheme")
 		os.Exit(1)
 	}
 
 	// Setup all Controllers
 	log.Info("Setting up controller")
-	if err := controller.AddToManager(mgr); err != nil {
+	if err := controller.AddToManager(leaderMgr); err != nil {
 		log.Error(err, "unable to register controllers to the manager")
 		os.Exit(1)
 	}
@@ -87,10 +114,55 @@ func main() {
 		os.Exit(1)
 	}
 
+	log.Info("setting up build logs server")
+	// the build logs are only served over TLS, with the certificate of a Secret valid for the build logs Service
+	buildLogsServer, err := buildlogs.New(mgr, buildLogsAddr, utils.BuildLogsSecretKey(), utils.BuildLogsDNSNames())
+	if err != nil {
+		log.Error(err, "unable to set up the build logs server")
+		os.Exit(1)
+	}
+	if err := mgr.Add(buildLogsServer); err != nil {
+		log.Error(err, "unable to register the build logs server to the manager")
+		os.Exit(1)
+	}
+
+	// The replicas are ready once the manager synced its cache, whether they lead or not
+	log.Info("setting up health server")
+	healthServer := health.New(healthAddr)
+	if err := mgr.Add(healthServer.ReadinessCheck()); err != nil {
+		log.Error(err, "unable to register the readiness check to the manager")
+		os.Exit(1)
+	}
+
 	// Start the Cmd
 	log.Info("Starting the Cmd.")
-	if err := mgr.Start(signals.SetupSignalHandler()); err != nil {
+	stop := signals.SetupSignalHandler()
+	go func() {
+		if err := healthServer.Start(stop); err != nil {
+			log.Error(err, "unable to serve the health probes")
+			os.Exit(1)
+		}
+	}()
+
+	if err := mgr.Start(stop); err != nil {
 		log.Error(err, "unable to run the manager")
 		os.Exit(1)
 	}
 }
+
+// addToScheme adds the APIs of the runtime and of Knative and Istio to the scheme of a manager
+func addToScheme(m manager.Manager) error {
+	if err := apis.AddToScheme(m.GetScheme()); err != nil {
+		return fmt.Errorf("unable add APIs to scheme: %v", err)
+	}
+	if err := servingv1alpha1.AddToScheme(m.GetScheme()); err != nil {
+		return fmt.Errorf("unable add Serving APIs to scheme: %v", err)
+	}
+	if err := buildv1alpha1.AddToScheme(m.GetScheme()); err != nil {
+		return fmt.Errorf("unable add Build APIs to scheme: %v", err)
+	}
+	if err := istiov1alpha3.AddToScheme(m.GetScheme()); err != nil {
+		return fmt.Errorf("unable add Istio APIs to scheme: %v", err)
+	}
+	return nil
+}
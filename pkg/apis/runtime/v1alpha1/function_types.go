@@ -41,8 +41,58 @@ type FunctionSpec struct {
 	// deps defines the dependencies for a function
 	Deps string `json:"deps,omitempty"`
 
+	// depsLock defines the lockfile of the dependencies e.g. the package-lock.json of deps, the dependencies are
+	// installed exactly as locked if it is given
+	DepsLock string `json:"depsLock,omitempty"`
+
 	// envs defines an array of key value pairs need to be used as env variable for a function
 	Env []v1.EnvVar `json:"env,omitempty"`
+
+	// volumes defines additional volumes of a function. Only configMap and secret volumes are allowed
+	Volumes []v1.Volume `json:"volumes,omitempty"`
+
+	// volumeMounts defines where the volumes are mounted into the container of a function, they must be readOnly
+	VolumeMounts []v1.VolumeMount `json:"volumeMounts,omitempty"`
+
+	// livenessProbe overrides the default liveness probe of the runtime of a function
+	LivenessProbe *v1.Probe `json:"livenessProbe,omitempty"`
+
+	// readinessProbe overrides the default readiness probe of the runtime of a function
+	ReadinessProbe *v1.Probe `json:"readinessProbe,omitempty"`
+
+	// serviceAccountName is the name of the service account a function runs with, defaults to the runtime service account
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// imagePullSecrets defines secrets of the namespace used to pull the image of a function. It can't be combined with serviceAccountName
+	ImagePullSecrets []v1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	// routes defines custom hosts and paths the function is exposed on e.g. api.example.com/orders
+	Routes []FunctionRoute `json:"routes,omitempty"`
+
+	// build defines how the image of a function is built
+	Build *FunctionBuildSpec `json:"build,omitempty"`
+}
+
+// FunctionBuildSpec defines how the image of a function is built
+type FunctionBuildSpec struct {
+	// disableCache disables the layer cache of the builds of a function
+	DisableCache bool `json:"disableCache,omitempty"`
+
+	// timeout defines the maximum duration of a build e.g. 45m, defaults to the build timeout of the controller
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// npmrcSecretRef references a Secret in the namespace of the function holding the .npmrc used to install the
+	// dependencies under the key .npmrc, defaults to the npmrc Secret of the controller's configuration
+	NpmrcSecretRef *v1.LocalObjectReference `json:"npmrcSecretRef,omitempty"`
+}
+
+// FunctionRoute exposes a function on a custom host and path
+type FunctionRoute struct {
+	// host is the fully qualified domain name the function is reachable on e.g. api.example.com
+	Host string `json:"host"`
+
+	// path is the URI prefix the function is reachable on e.g. /orders, defaults to /
+	Path string `json:"path,omitempty"`
 }
 
 // TemplateKind defines the type of BuildTemplate used by the build.
@@ -61,11 +111,125 @@ const (
 	FunctionConditionDeploying FunctionCondition = "Deploying"
 	// Indicates that there is a new image and function is being updated.
 	FunctionConditionUpdating FunctionCondition = "Updating"
+	// Indicates that the build of the function waits until the number of concurrent builds drops below the limit.
+	FunctionConditionBuildQueued FunctionCondition = "BuildQueued"
+)
+
+// FunctionPhase is the phase of the reconcile a function is in. The phases run in order, each of them once the previous
+// one is done.
+type FunctionPhase string
+
+const (
+	// Indicates that the ConfigMap holding the sources of the function is created or updated.
+	FunctionPhaseConfigMap FunctionPhase = "ConfigMap"
+	// Indicates that the function waits for the ClusterBuildTemplate of its runtime.
+	FunctionPhaseBuildTemplate FunctionPhase = "BuildTemplate"
+	// Indicates that the Build of the image of the function is queued, retried or started.
+	FunctionPhaseBuild FunctionPhase = "Build"
+	// Indicates that the Knative Service, the service accounts and the routes of the function are created or updated.
+	FunctionPhaseDeploy FunctionPhase = "Deploy"
+	// Indicates that the function waits for its revision to become ready, it stays in this phase once it is Running.
+	FunctionPhaseReady FunctionPhase = "Ready"
 )
 
 // FunctionStatus defines the observed state of Function
 type FunctionStatus struct {
 	Condition FunctionCondition `json:"condition,omitempty"`
+
+	// phase is the phase of the reconcile the function is in or failed at
+	Phase FunctionPhase `json:"phase,omitempty"`
+
+	// routes defines the observed state of the function's custom routes
+	Routes []FunctionRouteStatus `json:"routes,omitempty"`
+
+	// build defines the observed state of the latest build of the function
+	Build *FunctionBuildStatus `json:"build,omitempty"`
+
+	// config is the effective configuration the function is built and deployed with
+	Config *FunctionConfigStatus `json:"config,omitempty"`
+
+	// images are the references of the images built for the function, they are deleted from their registries once the
+	// function is deleted
+	Images []string `json:"images,omitempty"`
+}
+
+// FunctionConfigStatus defines the configuration of a function merged from the configuration of the controller, the
+// fn-config ConfigMap of its namespace and its own fields
+type FunctionConfigStatus struct {
+	// registry is the docker registry the image of the function is pushed to
+	Registry string `json:"registry,omitempty"`
+
+	// buildServiceAccount is the service account the builds of the function run with
+	BuildServiceAccount string `json:"buildServiceAccount,omitempty"`
+
+	// runtimeServiceAccount is the service account the function runs with
+	RuntimeServiceAccount string `json:"runtimeServiceAccount,omitempty"`
+
+	// registryPullSecret is the Secret holding the credentials to pull the image of the function as <namespace>/<name>
+	RegistryPullSecret string `json:"registryPullSecret,omitempty"`
+
+	// buildCacheRepository is the repository the cached layers of the builds are pushed to
+	BuildCacheRepository string `json:"buildCacheRepository,omitempty"`
+
+	// npmrcSecret is the Secret holding the .npmrc of the builds
+	NpmrcSecret string `json:"npmrcSecret,omitempty"`
+
+	// buildTimeout is the maximum duration of a build
+	BuildTimeout string `json:"buildTimeout,omitempty"`
+
+	// retainImages tells whether the images of the function are kept in the registry once it is deleted
+	RetainImages bool `json:"retainImages,omitempty"`
+
+	// sources are the ConfigMaps the configuration is merged from as <namespace>/<name>, later ones override earlier ones
+	Sources []string `json:"sources,omitempty"`
+}
+
+// FunctionBuildCache reports whether a build reused cached layers
+type FunctionBuildCache string
+
+const (
+	// Indicates that the dependencies layer was taken from the cache.
+	FunctionBuildCacheHit FunctionBuildCache = "Hit"
+	// Indicates that the dependencies layer was built from scratch.
+	FunctionBuildCacheMiss FunctionBuildCache = "Miss"
+	// Indicates that the build didn't use the cache.
+	FunctionBuildCacheDisabled FunctionBuildCache = "Disabled"
+)
+
+// FunctionBuildStatus defines the observed state of the latest build of a function
+type FunctionBuildStatus struct {
+	// name of the Knative Build
+	Name string `json:"name,omitempty"`
+
+	// cache is Hit or Miss once the build succeeded, Disabled if the build didn't use the cache
+	Cache FunctionBuildCache `json:"cache,omitempty"`
+
+	// queuePosition is the position of the function in the build queue, starting at 1, while the build is queued
+	QueuePosition int32 `json:"queuePosition,omitempty"`
+
+	// queuedTime is the time the build got queued, the builds queued first start first
+	QueuedTime *metav1.Time `json:"queuedTime,omitempty"`
+
+	// retries is the number of times the build was retried after failing with a transient error
+	Retries int32 `json:"retries,omitempty"`
+
+	// failedStep is the container of the build step which failed
+	FailedStep string `json:"failedStep,omitempty"`
+
+	// message is the termination message of the failed build step
+	Message string `json:"message,omitempty"`
+
+	// logs is the tail of the logs of the failed build step
+	Logs string `json:"logs,omitempty"`
+}
+
+// FunctionRouteStatus defines the observed state of a FunctionRoute
+type FunctionRouteStatus struct {
+	Host string `json:"host"`
+	Path string `json:"path,omitempty"`
+
+	// ready is true once the route is configured and the Knative route of the function is ready
+	Ready bool `json:"ready"`
 }
 
 // +genclient
@@ -78,6 +242,8 @@ type FunctionStatus struct {
 // +kubebuilder:printcolumn:name="Runtime",type="string",JSONPath=".spec.runtime",description="Runtime is the programming language used for a function e.g. nodejs8"
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 // +kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.condition",description="Check if the function is ready"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase",description="Phase of the reconcile the function is in",priority=1
+// +kubebuilder:printcolumn:name="Registry",type="string",JSONPath=".status.config.registry",description="Registry the image of the function is pushed to",priority=1
 type Function struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -0,0 +1,132 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildlogs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+// keys of the Secret holding the serving certificate, clients verify the server with the CA certificate
+const (
+	certKey   = "tls.crt"
+	keyKey    = "tls.key"
+	caCertKey = "ca.crt"
+)
+
+// certificates expiring within this duration are replaced when a replica starts
+const certRenewBefore = 30 * 24 * time.Hour
+
+// attempts of replicas racing to fill the Secret with a certificate
+const certAttempts = 3
+
+// servingCertificate returns the certificate of the build logs server stored in a Secret, so all replicas serve the
+// same one. Unless the Secret holds a valid certificate for the DNS names of the build logs Service, it is filled
+// with a new self-signed one.
+func servingCertificate(secrets corev1client.SecretInterface, name string, dnsNames []string) (*tls.Certificate, error) {
+	for attempt := 0; attempt < certAttempts; attempt++ {
+		secret, err := secrets.Get(name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			secret = &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		} else if err != nil {
+			return nil, err
+		}
+
+		if cert, err := parseCertificate(secret.Data, dnsNames); err == nil {
+			return cert, nil
+		}
+
+		data, err := generateCertificate(dnsNames)
+		if err != nil {
+			return nil, err
+		}
+		secret.Data = data
+
+		if secret.ResourceVersion == "" {
+			log.Info("Creating the serving certificate of the build logs", "name", name)
+			_, err = secrets.Create(secret)
+		} else {
+			log.Info("Updating the serving certificate of the build logs", "name", name)
+			_, err = secrets.Update(secret)
+		}
+		if errors.IsAlreadyExists(err) || errors.IsConflict(err) {
+			// another replica filled the Secret first
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return parseCertificate(data, dnsNames)
+	}
+
+	return nil, fmt.Errorf("unable to store the serving certificate of the build logs in the Secret %s", name)
+}
+
+// parseCertificate returns the certificate of the Secret data if it is valid for the DNS names and doesn't expire soon
+func parseCertificate(data map[string][]byte, dnsNames []string) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(data[certKey], data[keyKey])
+	if err != nil {
+		return nil, err
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().Add(certRenewBefore).After(leaf.NotAfter) {
+		return nil, fmt.Errorf("certificate expires at %s", leaf.NotAfter)
+	}
+	for _, dnsName := range dnsNames {
+		if err := leaf.VerifyHostname(dnsName); err != nil {
+			return nil, err
+		}
+	}
+
+	return &cert, nil
+}
+
+// generateCertificate returns the Secret data of a new self-signed certificate for the DNS names
+func generateCertificate(dnsNames []string) (map[string][]byte, error) {
+	certs, key, err := certutil.GenerateSelfSignedCertKey(dnsNames[0], nil, dnsNames[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	// the serving certificate is followed by the certificate of the CA which signed it
+	var caCert []byte
+	for rest := certs; len(rest) > 0; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		caCert = pem.EncodeToMemory(block)
+	}
+
+	return map[string][]byte{
+		certKey:   certs,
+		keyKey:    key,
+		caCertKey: caCert,
+	}, nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildlogs
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// fakeSecrets stores a single Secret
+type fakeSecrets struct {
+	corev1client.SecretInterface
+	secret *corev1.Secret
+}
+
+func (f *fakeSecrets) Get(name string, options metav1.GetOptions) (*corev1.Secret, error) {
+	if f.secret == nil || f.secret.Name != name {
+		return nil, errors.NewNotFound(schema.GroupResource{Resource: "secrets"}, name)
+	}
+	return f.secret.DeepCopy(), nil
+}
+
+func (f *fakeSecrets) Create(secret *corev1.Secret) (*corev1.Secret, error) {
+	if f.secret != nil {
+		return nil, errors.NewAlreadyExists(schema.GroupResource{Resource: "secrets"}, secret.Name)
+	}
+	f.secret = secret.DeepCopy()
+	f.secret.ResourceVersion = "1"
+	return f.secret.DeepCopy(), nil
+}
+
+func (f *fakeSecrets) Update(secret *corev1.Secret) (*corev1.Secret, error) {
+	if f.secret == nil || f.secret.ResourceVersion != secret.ResourceVersion {
+		return nil, errors.NewConflict(schema.GroupResource{Resource: "secrets"}, secret.Name, nil)
+	}
+	f.secret = secret.DeepCopy()
+	f.secret.ResourceVersion += "1"
+	return f.secret.DeepCopy(), nil
+}
+
+func TestServingCertificate(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	dnsNames := []string{"build-logs-service.runtime-system.svc", "build-logs-service.runtime-system.svc.cluster.local"}
+
+	// the empty Secret of the deployment is filled with a certificate signed by its CA certificate
+	secrets := &fakeSecrets{secret: &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "build-logs-server-secret", ResourceVersion: "1"},
+	}}
+	cert, err := servingCertificate(secrets, "build-logs-server-secret", dnsNames)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(secrets.secret.Data).To(gomega.HaveKey("tls.crt"))
+	g.Expect(secrets.secret.Data).To(gomega.HaveKey("tls.key"))
+	g.Expect(secrets.secret.Data).To(gomega.HaveKey("ca.crt"))
+
+	roots := x509.NewCertPool()
+	g.Expect(roots.AppendCertsFromPEM(secrets.secret.Data["ca.crt"])).To(gomega.BeTrue())
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	for _, dnsName := range dnsNames {
+		_, err := leaf.Verify(x509.VerifyOptions{DNSName: dnsName, Roots: roots})
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+	}
+
+	// replicas serve the certificate of the Secret
+	data := secrets.secret.Data
+	sameCert, err := servingCertificate(secrets, "build-logs-server-secret", dnsNames)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(sameCert.Certificate).To(gomega.Equal(cert.Certificate))
+	g.Expect(secrets.secret.Data).To(gomega.Equal(data))
+
+	// a certificate for other DNS names is replaced
+	otherDNSNames := []string{"runtime-build-logs-service.runtime-system.svc"}
+	otherCert, err := servingCertificate(secrets, "build-logs-server-secret", otherDNSNames)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(otherCert.Certificate).NotTo(gomega.Equal(cert.Certificate))
+	leaf, err = x509.ParseCertificate(otherCert.Certificate[0])
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(leaf.VerifyHostname(otherDNSNames[0])).To(gomega.Succeed())
+
+	// a missing Secret is created
+	secrets = &fakeSecrets{}
+	_, err = servingCertificate(secrets, "build-logs-server-secret", dnsNames)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(secrets.secret.Name).To(gomega.Equal("build-logs-server-secret"))
+	g.Expect(secrets.secret.Data).To(gomega.HaveKey("tls.crt"))
+}
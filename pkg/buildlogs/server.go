@@ -0,0 +1,268 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildlogs
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	buildv1alpha1 "github.com/knative/build/pkg/apis/build/v1alpha1"
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	runtimeUtil "github.com/kyma-incubator/runtime/pkg/utils"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+var log = logf.Log.WithName("buildlogs")
+
+// duration given to open log streams when the server shuts down
+const shutdownTimeout = 5 * time.Second
+
+// authenticateFunc returns the user a bearer token belongs to, nil if the token isn't valid
+type authenticateFunc func(token string) (*authenticationv1.UserInfo, error)
+
+// authorizeFunc checks whether a user may read the logs of the pods of a namespace
+type authorizeFunc func(user authenticationv1.UserInfo, namespace string) (bool, error)
+
+// streamLogsFunc opens the logs of a container of a pod
+type streamLogsFunc func(namespace, pod, container string, follow bool) (io.ReadCloser, error)
+
+// certificateFunc returns the certificate the server is served with
+type certificateFunc func() (*tls.Certificate, error)
+
+// Server streams the logs of the latest build of a function on
+// GET /namespaces/<namespace>/functions/<name>/buildlogs[?step=<container>&follow=true].
+// Callers authenticate with a bearer token and need to be allowed to get pods/log in the namespace of the function.
+// The server is only served over TLS, so the tokens never travel in cleartext.
+type Server struct {
+	client.Client
+	addr         string
+	certificate  certificateFunc
+	authenticate authenticateFunc
+	authorize    authorizeFunc
+	streamLogs   streamLogsFunc
+}
+
+var _ manager.Runnable = &Server{}
+
+// New returns a Server listening on addr, which reviews tokens and access through the API server. It is served with the
+// certificate of the Secret certSecret, which is filled with a self-signed certificate for dnsNames unless it holds a
+// valid one. Clients verify the server with the ca.crt of the Secret.
+// +kubebuilder:rbac:groups="authentication.k8s.io",resources=tokenreviews,verbs=create
+// +kubebuilder:rbac:groups="authorization.k8s.io",resources=subjectaccessreviews,verbs=create
+func New(mgr manager.Manager, addr string, certSecret types.NamespacedName, dnsNames []string) (*Server, error) {
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		Client: mgr.GetClient(),
+		addr:   addr,
+		certificate: func() (*tls.Certificate, error) {
+			return servingCertificate(clientset.CoreV1().Secrets(certSecret.Namespace), certSecret.Name, dnsNames)
+		},
+		authenticate: func(token string) (*authenticationv1.UserInfo, error) {
+			review, err := clientset.AuthenticationV1().TokenReviews().Create(&authenticationv1.TokenReview{
+				Spec: authenticationv1.TokenReviewSpec{Token: token},
+			})
+			if err != nil || !review.Status.Authenticated {
+				return nil, err
+			}
+			return &review.Status.User, nil
+		},
+		authorize: func(user authenticationv1.UserInfo, namespace string) (bool, error) {
+			extra := map[string]authorizationv1.ExtraValue{}
+			for key, value := range user.Extra {
+				extra[key] = authorizationv1.ExtraValue(value)
+			}
+			review, err := clientset.AuthorizationV1().SubjectAccessReviews().Create(&authorizationv1.SubjectAccessReview{
+				Spec: authorizationv1.SubjectAccessReviewSpec{
+					User:   user.Username,
+					UID:    user.UID,
+					Groups: user.Groups,
+					Extra:  extra,
+					ResourceAttributes: &authorizationv1.ResourceAttributes{
+						Namespace:   namespace,
+						Verb:        "get",
+						Resource:    "pods",
+						Subresource: "log",
+					},
+				},
+			})
+			if err != nil {
+				return false, err
+			}
+			return review.Status.Allowed, nil
+		},
+		streamLogs: func(namespace, pod, container string, follow bool) (io.ReadCloser, error) {
+			return clientset.CoreV1().Pods(namespace).GetLogs(pod, &corev1.PodLogOptions{Container: container, Follow: follow}).Stream()
+		},
+	}, nil
+}
+
+// Start serves the build logs over TLS until stop is closed
+func (s *Server) Start(stop <-chan struct{}) error {
+	cert, err := s.certificate()
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Addr:    s.addr,
+		Handler: s,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{*cert},
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Info("Serving build logs", "addr", s.addr)
+		if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+			errs <- err
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-stop:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
+
+// ServeHTTP streams the logs of the requested build step
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) != 5 || parts[0] != "namespaces" || parts[2] != "functions" || parts[4] != "buildlogs" {
+		http.NotFound(w, req)
+		return
+	}
+	key := types.NamespacedName{Namespace: parts[1], Name: parts[3]}
+
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == req.Header.Get("Authorization") {
+		http.Error(w, "bearer token required", http.StatusUnauthorized)
+		return
+	}
+	user, err := s.authenticate(token)
+	if err != nil {
+		log.Error(err, "Error while trying to review a token")
+		http.Error(w, "unable to review the token", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "invalid bearer token", http.StatusUnauthorized)
+		return
+	}
+
+	allowed, err := s.authorize(*user, key.Namespace)
+	if err != nil {
+		log.Error(err, "Error while trying to review the access to build logs", "user", user.Username, "namespace", key.Namespace)
+		http.Error(w, "unable to review the access", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, fmt.Sprintf("user %q can't get pods/log in namespace %q", user.Username, key.Namespace), http.StatusForbidden)
+		return
+	}
+
+	pod, step, err := s.buildPod(key)
+	if errors.IsNotFound(err) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Error(err, "Error while trying to find the build pod of the function", "namespace", key.Namespace, "name", key.Name)
+		http.Error(w, "unable to find the build of the function", http.StatusInternalServerError)
+		return
+	}
+	if requested := req.URL.Query().Get("step"); requested != "" {
+		step = requested
+	}
+
+	logs, err := s.streamLogs(key.Namespace, pod, step, req.URL.Query().Get("follow") == "true")
+	if err != nil {
+		log.Error(err, "Error while trying to read the build logs", "namespace", key.Namespace, "pod", pod, "container", step)
+		http.Error(w, "unable to read the build logs", http.StatusBadGateway)
+		return
+	}
+	defer logs.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(flushWriter{w}, logs)
+}
+
+// buildPod returns the pod of the latest build of a function and the container of the step which failed, the build
+// and push step if none did
+func (s *Server) buildPod(key types.NamespacedName) (string, string, error) {
+	fn := &runtimev1alpha1.Function{}
+	if err := s.Get(context.TODO(), key, fn); err != nil {
+		return "", "", err
+	}
+	if fn.Status.Build == nil || fn.Status.Build.Name == "" {
+		return "", "", errors.NewNotFound(buildv1alpha1.Resource("builds"), key.Name)
+	}
+
+	build := &buildv1alpha1.Build{}
+	if err := s.Get(context.TODO(), types.NamespacedName{Namespace: key.Namespace, Name: fn.Status.Build.Name}, build); err != nil {
+		return "", "", err
+	}
+	if build.Status.Cluster == nil || build.Status.Cluster.PodName == "" {
+		return "", "", errors.NewNotFound(corev1.Resource("pods"), build.Name)
+	}
+
+	step := runtimeUtil.BuildAndPushStep
+	if fn.Status.Build.FailedStep != "" {
+		step = fn.Status.Build.FailedStep
+	}
+	return build.Status.Cluster.PodName, step, nil
+}
+
+// flushWriter sends every write to the client right away, so followed logs show up as they are written
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if flusher, ok := fw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
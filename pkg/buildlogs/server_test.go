@@ -0,0 +1,128 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildlogs
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	buildv1alpha1 "github.com/knative/build/pkg/apis/build/v1alpha1"
+	"github.com/kyma-incubator/runtime/pkg/apis"
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	"github.com/onsi/gomega"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func init() {
+	apis.AddToScheme(scheme.Scheme)
+	buildv1alpha1.AddToScheme(scheme.Scheme)
+}
+
+func TestServeHTTP(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	function := &runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "team-a"},
+		Status: runtimev1alpha1.FunctionStatus{
+			Build: &runtimev1alpha1.FunctionBuildStatus{Name: "hello-0123456789"},
+		},
+	}
+	failedFunction := &runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "failed", Namespace: "team-a"},
+		Status: runtimev1alpha1.FunctionStatus{
+			Build: &runtimev1alpha1.FunctionBuildStatus{Name: "failed-0123456789", FailedStep: "build-step-credential-initializer"},
+		},
+	}
+	unbuiltFunction := &runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "unbuilt", Namespace: "team-a"},
+	}
+	build := func(name string) *buildv1alpha1.Build {
+		return &buildv1alpha1.Build{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-a"},
+			Status: buildv1alpha1.BuildStatus{
+				Cluster: &buildv1alpha1.ClusterSpec{Namespace: "team-a", PodName: name + "-pod"},
+			},
+		}
+	}
+
+	server := &Server{
+		Client: fake.NewFakeClient(function, failedFunction, unbuiltFunction, build("hello-0123456789"), build("failed-0123456789")),
+		authenticate: func(token string) (*authenticationv1.UserInfo, error) {
+			if token == "invalid" {
+				return nil, nil
+			}
+			return &authenticationv1.UserInfo{Username: token}, nil
+		},
+		authorize: func(user authenticationv1.UserInfo, namespace string) (bool, error) {
+			return user.Username == "developer" && namespace == "team-a", nil
+		},
+		streamLogs: func(namespace, pod, container string, follow bool) (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader(fmt.Sprintf("%s/%s/%s follow=%t", namespace, pod, container, follow))), nil
+		},
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+		body   string
+	}{
+		{name: "logs of the build and push step", path: "/namespaces/team-a/functions/hello/buildlogs", token: "developer",
+			status: http.StatusOK, body: "team-a/hello-0123456789-pod/build-step-build-and-push follow=false"},
+		{name: "logs of the failed step", path: "/namespaces/team-a/functions/failed/buildlogs", token: "developer",
+			status: http.StatusOK, body: "team-a/failed-0123456789-pod/build-step-credential-initializer follow=false"},
+		{name: "logs of a requested step", path: "/namespaces/team-a/functions/hello/buildlogs?step=build-step-git-source&follow=true", token: "developer",
+			status: http.StatusOK, body: "team-a/hello-0123456789-pod/build-step-git-source follow=true"},
+		{name: "no token", path: "/namespaces/team-a/functions/hello/buildlogs", status: http.StatusUnauthorized},
+		{name: "invalid token", path: "/namespaces/team-a/functions/hello/buildlogs", token: "invalid", status: http.StatusUnauthorized},
+		{name: "not allowed", path: "/namespaces/team-a/functions/hello/buildlogs", token: "guest", status: http.StatusForbidden},
+		{name: "not allowed in namespace", path: "/namespaces/team-b/functions/hello/buildlogs", token: "developer", status: http.StatusForbidden},
+		{name: "unknown function", path: "/namespaces/team-a/functions/unknown/buildlogs", token: "developer", status: http.StatusNotFound},
+		{name: "function without build", path: "/namespaces/team-a/functions/unbuilt/buildlogs", token: "developer", status: http.StatusNotFound},
+		{name: "unknown path", path: "/namespaces/team-a/functions/hello", token: "developer", status: http.StatusNotFound},
+		{name: "wrong method", method: http.MethodPost, path: "/namespaces/team-a/functions/hello/buildlogs", token: "developer", status: http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		method := test.method
+		if method == "" {
+			method = http.MethodGet
+		}
+		req := httptest.NewRequest(method, test.path, nil)
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		rec := httptest.NewRecorder()
+
+		server.ServeHTTP(rec, req)
+
+		g.Expect(rec.Code).To(gomega.Equal(test.status), test.name)
+		if test.body != "" {
+			g.Expect(rec.Body.String()).To(gomega.Equal(test.body), test.name)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// lines of the logs of a failed build step kept in the function status
+	buildLogTailLines = 20
+
+	// maximum size of the logs and messages of a failed build kept in the function status
+	buildLogTailBytes = 2048
+)
+
+// podLogsFunc returns the logs of a container of a pod
+type podLogsFunc func(namespace, name, container string) ([]byte, error)
+
+// newPodLogs returns a podLogsFunc reading the logs from the API server, the controller-runtime client can't read logs
+func newPodLogs(config *rest.Config) podLogsFunc {
+	clientset := kubernetes.NewForConfigOrDie(config)
+	return func(namespace, name, container string) ([]byte, error) {
+		return clientset.CoreV1().Pods(namespace).GetLogs(name, &corev1.PodLogOptions{Container: container}).Do().Raw()
+	}
+}
+
+// tailLogs returns the last lines of logs, limited in size so they fit into the function status
+func tailLogs(logs string) string {
+	lines := strings.Split(strings.TrimRight(logs, "\n"), "\n")
+	if len(lines) > buildLogTailLines {
+		lines = lines[len(lines)-buildLogTailLines:]
+	}
+	tail := strings.Join(lines, "\n")
+	if len(tail) > buildLogTailBytes {
+		tail = tail[len(tail)-buildLogTailBytes:]
+	}
+	return tail
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// interval queued functions check whether their build can start
+	buildQueuePollInterval = 10 * time.Second
+
+	// duration a created build counts as running until it shows up in the cache
+	buildStartGracePeriod = time.Minute
+
+	// duration after which functions which stopped polling the queue are forgotten
+	buildQueueExpiry = 6 * buildQueuePollInterval
+)
+
+// queuedBuild is a function waiting for its build
+type queuedBuild struct {
+	queuedTime time.Time
+	lastSeen   time.Time
+}
+
+// buildQueue decides which builds may start while capping the number of concurrent builds globally and per namespace.
+// Running builds are always counted from the Build objects, only the order of the waiting functions is kept in memory.
+// Functions persist the time they got queued in their status, so the order survives restarts of the controller.
+type buildQueue struct {
+	mu sync.Mutex
+
+	// functions waiting for their build
+	queued map[types.NamespacedName]queuedBuild
+
+	// builds created by the queue which might not be in the cache of the client yet
+	started map[types.NamespacedName]time.Time
+
+	now func() time.Time
+}
+
+func newBuildQueue() *buildQueue {
+	return &buildQueue{
+		queued:  map[types.NamespacedName]queuedBuild{},
+		started: map[types.NamespacedName]time.Time{},
+		now:     time.Now,
+	}
+}
+
+// admit decides whether the build of a function can start. running are the builds which didn't finish yet. A function
+// which can't start its build stays queued and gets its position in the queue, starting at 1.
+func (q *buildQueue) admit(fn types.NamespacedName, queuedTime time.Time, build types.NamespacedName, running []types.NamespacedName, maxBuilds, maxBuildsPerNamespace int) (bool, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if existing, ok := q.queued[fn]; ok && existing.queuedTime.Before(queuedTime) {
+		queuedTime = existing.queuedTime
+	}
+	q.queued[fn] = queuedBuild{queuedTime: queuedTime, lastSeen: q.now()}
+	for key, entry := range q.queued {
+		if q.now().Sub(entry.lastSeen) > buildQueueExpiry {
+			delete(q.queued, key)
+		}
+	}
+
+	// running builds and the ones started recently
+	builds := map[types.NamespacedName]bool{}
+	for _, key := range running {
+		builds[key] = true
+		delete(q.started, key)
+	}
+	for key, started := range q.started {
+		if q.now().Sub(started) > buildStartGracePeriod {
+			delete(q.started, key)
+			continue
+		}
+		builds[key] = true
+	}
+
+	total := len(builds)
+	perNamespace := map[string]int{}
+	for key := range builds {
+		perNamespace[key.Namespace]++
+	}
+
+	fits := func(namespace string) bool {
+		return (maxBuilds <= 0 || total < maxBuilds) && (maxBuildsPerNamespace <= 0 || perNamespace[namespace] < maxBuildsPerNamespace)
+	}
+
+	// functions queued earlier start first, they reserve the capacity they need
+	position := 0
+	for _, key := range q.order() {
+		if !fits(key.Namespace) {
+			position++
+			if key == fn {
+				return false, position
+			}
+			continue
+		}
+
+		if key == fn {
+			delete(q.queued, fn)
+			q.started[build] = q.now()
+			return true, 0
+		}
+		total++
+		perNamespace[key.Namespace]++
+	}
+
+	return false, position
+}
+
+// remove forgets a function which doesn't wait for a build anymore
+func (q *buildQueue) remove(fn types.NamespacedName) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.queued, fn)
+}
+
+// order returns the waiting functions, the ones queued first come first
+func (q *buildQueue) order() []types.NamespacedName {
+	keys := make([]types.NamespacedName, 0, len(q.queued))
+	for key := range q.queued {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ti, tj := q.queued[keys[i]].queuedTime, q.queued[keys[j]].queuedTime
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestBuildQueue(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	now := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	q := newBuildQueue()
+	q.now = func() time.Time { return now }
+
+	key := func(namespace, name string) types.NamespacedName {
+		return types.NamespacedName{Namespace: namespace, Name: name}
+	}
+
+	// unlimited
+	admitted, _ := q.admit(key("a", "fn1"), now, key("a", "fn1-build"), nil, 0, 0)
+	g.Expect(admitted).To(gomega.BeTrue())
+
+	// global limit reached by the build started above, it isn't in the cache yet
+	admitted, position := q.admit(key("b", "fn2"), now, key("b", "fn2-build"), nil, 1, 0)
+	g.Expect(admitted).To(gomega.BeFalse())
+	g.Expect(position).To(gomega.Equal(1))
+
+	admitted, position = q.admit(key("c", "fn3"), now.Add(time.Second), key("c", "fn3-build"), []types.NamespacedName{key("a", "fn1-build")}, 1, 0)
+	g.Expect(admitted).To(gomega.BeFalse())
+	g.Expect(position).To(gomega.Equal(2))
+
+	// the first build finished, the function queued first starts first
+	admitted, position = q.admit(key("c", "fn3"), now.Add(time.Second), key("c", "fn3-build"), nil, 1, 0)
+	g.Expect(admitted).To(gomega.BeFalse())
+	g.Expect(position).To(gomega.Equal(1))
+	admitted, _ = q.admit(key("b", "fn2"), now, key("b", "fn2-build"), nil, 1, 0)
+	g.Expect(admitted).To(gomega.BeTrue())
+	admitted, position = q.admit(key("c", "fn3"), now.Add(time.Second), key("c", "fn3-build"), []types.NamespacedName{key("b", "fn2-build")}, 1, 0)
+	g.Expect(admitted).To(gomega.BeFalse())
+	g.Expect(position).To(gomega.Equal(1))
+	q.remove(key("c", "fn3"))
+}
+
+func TestBuildQueuePerNamespace(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	now := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	q := newBuildQueue()
+	q.now = func() time.Time { return now }
+
+	running := []types.NamespacedName{{Namespace: "a", Name: "fn1-build"}}
+
+	// the namespace is full
+	admitted, position := q.admit(types.NamespacedName{Namespace: "a", Name: "fn2"}, now, types.NamespacedName{Namespace: "a", Name: "fn2-build"}, running, 10, 1)
+	g.Expect(admitted).To(gomega.BeFalse())
+	g.Expect(position).To(gomega.Equal(1))
+
+	// other namespaces aren't blocked by it
+	admitted, _ = q.admit(types.NamespacedName{Namespace: "b", Name: "fn3"}, now.Add(time.Second), types.NamespacedName{Namespace: "b", Name: "fn3-build"}, running, 10, 1)
+	g.Expect(admitted).To(gomega.BeTrue())
+
+	// the order survives a restart as functions keep the time they got queued
+	q = newBuildQueue()
+	q.now = func() time.Time { return now.Add(time.Minute) }
+	admitted, position = q.admit(types.NamespacedName{Namespace: "a", Name: "fn4"}, now.Add(time.Minute), types.NamespacedName{Namespace: "a", Name: "fn4-build"}, nil, 10, 1)
+	g.Expect(admitted).To(gomega.BeTrue())
+	q.remove(types.NamespacedName{Namespace: "a", Name: "fn4"})
+
+	q = newBuildQueue()
+	q.now = func() time.Time { return now.Add(time.Minute) }
+	admitted, position = q.admit(types.NamespacedName{Namespace: "a", Name: "fn4"}, now.Add(time.Minute), types.NamespacedName{Namespace: "a", Name: "fn4-build"}, running, 10, 1)
+	g.Expect(admitted).To(gomega.BeFalse())
+	g.Expect(position).To(gomega.Equal(1))
+	admitted, position = q.admit(types.NamespacedName{Namespace: "a", Name: "fn2"}, now, types.NamespacedName{Namespace: "a", Name: "fn2-build"}, running, 10, 1)
+	g.Expect(admitted).To(gomega.BeFalse())
+	g.Expect(position).To(gomega.Equal(1))
+	admitted, position = q.admit(types.NamespacedName{Namespace: "a", Name: "fn4"}, now.Add(time.Minute), types.NamespacedName{Namespace: "a", Name: "fn4-build"}, running, 10, 1)
+	g.Expect(admitted).To(gomega.BeFalse())
+	g.Expect(position).To(gomega.Equal(2))
+
+	// functions which stopped polling are forgotten
+	q.now = func() time.Time { return now.Add(time.Minute + 2*buildQueueExpiry) }
+	admitted, position = q.admit(types.NamespacedName{Namespace: "a", Name: "fn4"}, now.Add(time.Minute), types.NamespacedName{Namespace: "a", Name: "fn4-build"}, nil, 10, 1)
+	g.Expect(admitted).To(gomega.BeTrue())
+	g.Expect(position).To(gomega.Equal(0))
+}
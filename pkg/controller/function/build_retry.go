@@ -0,0 +1,181 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	buildv1alpha1 "github.com/knative/build/pkg/apis/build/v1alpha1"
+	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	runtimeUtil "github.com/kyma-incubator/runtime/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// backoff before the first retry of a build, it doubles with every retry
+	buildRetryBackoff = 30 * time.Second
+
+	// maximum backoff between the retries of a build
+	maxBuildRetryBackoff = 10 * time.Minute
+
+	// annotation of a Build holding the number of retries it was created for
+	buildRetryAnnotation = "runtime.kyma-project.io/build-retry"
+)
+
+var (
+	// exit codes of build steps killed from outside e.g. when the pod got evicted
+	transientBuildExitCodes = map[int32]bool{137: true, 143: true}
+
+	// messages of failures which are worth retrying, compared case-insensitive
+	transientBuildFailureMessages = []string{
+		"evicted",
+		"connection refused",
+		"connection reset",
+		"i/o timeout",
+		"tls handshake timeout",
+		"no such host",
+		"unexpected eof",
+		"too many requests",
+		"internal server error",
+		"bad gateway",
+		"service unavailable",
+		"gateway timeout",
+	}
+)
+
+// buildSucceeded checks whether a Build completed successfully
+func buildSucceeded(build *buildv1alpha1.Build) bool {
+	for _, condition := range build.Status.Conditions {
+		if condition.Type == duckv1alpha1.ConditionSucceeded && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// buildFailure checks whether a Build failed and whether the failure is transient. Failures are transient when a step
+// got killed without running out of memory or the messages point to an unavailable registry or node. Everything else,
+// e.g. a syntax error in the dependencies or wrong credentials, fails again when retried.
+func buildFailure(build *buildv1alpha1.Build) (failed bool, transient bool) {
+	messages := []string{}
+	for _, condition := range build.Status.Conditions {
+		if condition.Type == duckv1alpha1.ConditionSucceeded && condition.Status == corev1.ConditionFalse {
+			failed = true
+			messages = append(messages, condition.Reason, condition.Message)
+		}
+	}
+	if !failed {
+		return false, false
+	}
+
+	for _, state := range build.Status.StepStates {
+		if state.Terminated == nil || state.Terminated.ExitCode == 0 {
+			continue
+		}
+		if state.Terminated.Reason == "OOMKilled" {
+			return true, false
+		}
+		if transientBuildExitCodes[state.Terminated.ExitCode] {
+			return true, true
+		}
+		messages = append(messages, state.Terminated.Reason, state.Terminated.Message)
+	}
+
+	for _, message := range messages {
+		message = strings.ToLower(message)
+		for _, transientMessage := range transientBuildFailureMessages {
+			if strings.Contains(message, transientMessage) {
+				return true, true
+			}
+		}
+	}
+
+	return true, false
+}
+
+// buildRetryDelay returns the backoff before a build is retried after the given number of retries
+func buildRetryDelay(retries int32) time.Duration {
+	delay := buildRetryBackoff
+	for i := int32(0); i < retries && delay < maxBuildRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBuildRetryBackoff {
+		return maxBuildRetryBackoff
+	}
+	return delay
+}
+
+// buildRetries returns the number of retries of the latest build of a function
+func buildRetries(fn *runtimev1alpha1.Function, buildName string) int32 {
+	if fn.Status.Build == nil || fn.Status.Build.Name != buildName {
+		return 0
+	}
+	return fn.Status.Build.Retries
+}
+
+// retryBuild deletes a Build which failed with a transient error once its backoff passed, so it is created again.
+// It returns the duration to wait for the next retry. Permanent failures and builds out of retries are left to the
+// function condition.
+func (r *ReconcileFunction) retryBuild(rnInfo *runtimeUtil.RuntimeInfo, fn *runtimev1alpha1.Function, buildName string) (time.Duration, error) {
+
+	foundBuild := &buildv1alpha1.Build{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Name: buildName, Namespace: fn.Namespace}, foundBuild); err != nil {
+		return 0, ignoreNotFound(err)
+	}
+
+	failed, transient := buildFailure(foundBuild)
+	if !failed {
+		return 0, nil
+	}
+
+	retries := buildRetries(fn, buildName)
+	if retry, _ := strconv.Atoi(foundBuild.Annotations[buildRetryAnnotation]); int32(retry) < retries {
+		// the build was deleted for the latest retry, the client doesn't know yet
+		return time.Second, nil
+	}
+
+	if !transient || retries >= int32(rnInfo.MaxBuildRetries) {
+		log.Info("Knative Build failed", "namespace", fn.Namespace, "name", buildName, "transient", transient, "retries", retries)
+		return 0, nil
+	}
+
+	finished := foundBuild.CreationTimestamp.Time
+	if foundBuild.Status.CompletionTime != nil {
+		finished = foundBuild.Status.CompletionTime.Time
+	}
+	if wait := buildRetryDelay(retries) - time.Since(finished); wait > 0 {
+		log.Info("Waiting to retry the failed Knative Build", "namespace", fn.Namespace, "name", buildName, "retries", retries, "wait", wait.String())
+		fn.Status.Build = &runtimev1alpha1.FunctionBuildStatus{Name: buildName, Retries: retries}
+		fn.Status.Condition = runtimev1alpha1.FunctionConditionBuilding
+		return wait, nil
+	}
+
+	log.Info("Retrying the failed Knative Build", "namespace", fn.Namespace, "name", buildName, "retries", retries+1)
+	if err := r.Delete(context.TODO(), foundBuild); ignoreNotFound(err) != nil {
+		log.Error(err, "Error while trying to delete the failed Knative Build", "namespace", fn.Namespace, "name", buildName)
+		return 0, err
+	}
+	fn.Status.Build = &runtimev1alpha1.FunctionBuildStatus{Name: buildName, Retries: retries + 1}
+	fn.Status.Condition = runtimev1alpha1.FunctionConditionBuilding
+
+	return time.Second, nil
+}
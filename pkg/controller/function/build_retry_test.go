@@ -0,0 +1,215 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	buildv1alpha1 "github.com/knative/build/pkg/apis/build/v1alpha1"
+	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	runtimeUtil "github.com/kyma-incubator/runtime/pkg/utils"
+	"github.com/onsi/gomega"
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// failedBuildStatus returns the status of a Build whose build and push step failed
+func failedBuildStatus(message string, exitCode int32, reason string) buildv1alpha1.BuildStatus {
+	return buildv1alpha1.BuildStatus{
+		Status: duckv1alpha1.Status{
+			Conditions: []duckv1alpha1.Condition{
+				{
+					Type:    duckv1alpha1.ConditionSucceeded,
+					Status:  corev1.ConditionFalse,
+					Message: message,
+				},
+			},
+		},
+		StepStates: []corev1.ContainerState{
+			{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}},
+			{Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode, Reason: reason}},
+		},
+	}
+}
+
+func TestBuildFailure(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	tests := []struct {
+		name      string
+		status    buildv1alpha1.BuildStatus
+		failed    bool
+		transient bool
+	}{
+		{
+			name:   "running",
+			status: buildv1alpha1.BuildStatus{},
+		},
+		{
+			name: "succeeded",
+			status: buildv1alpha1.BuildStatus{Status: duckv1alpha1.Status{Conditions: []duckv1alpha1.Condition{
+				{Type: duckv1alpha1.ConditionSucceeded, Status: corev1.ConditionTrue},
+			}}},
+		},
+		{
+			name:   "wrong credentials",
+			status: failedBuildStatus(`error pushing image: UNAUTHORIZED: authentication required`, 1, "Error"),
+			failed: true,
+		},
+		{
+			name:      "registry unavailable",
+			status:    failedBuildStatus(`error pushing image: 503 Service Unavailable`, 1, "Error"),
+			failed:    true,
+			transient: true,
+		},
+		{
+			name:      "registry unreachable",
+			status:    failedBuildStatus(`dial tcp 10.0.0.1:5000: i/o timeout`, 1, "Error"),
+			failed:    true,
+			transient: true,
+		},
+		{
+			name:      "killed",
+			status:    failedBuildStatus(`build step "build-step-build-and-push" exited with code 137`, 137, "Error"),
+			failed:    true,
+			transient: true,
+		},
+		{
+			name:   "out of memory",
+			status: failedBuildStatus(`build step "build-step-build-and-push" exited with code 137`, 137, "OOMKilled"),
+			failed: true,
+		},
+		{
+			name:      "evicted",
+			status:    failedBuildStatus(`The node was low on resource: ephemeral-storage. Pod was Evicted.`, 0, ""),
+			failed:    true,
+			transient: true,
+		},
+	}
+
+	for _, test := range tests {
+		failed, transient := buildFailure(&buildv1alpha1.Build{Status: test.status})
+		g.Expect(failed).To(gomega.Equal(test.failed), test.name)
+		g.Expect(transient).To(gomega.Equal(test.transient), test.name)
+	}
+}
+
+func TestBuildRetryDelay(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	g.Expect(buildRetryDelay(0)).To(gomega.Equal(30 * time.Second))
+	g.Expect(buildRetryDelay(1)).To(gomega.Equal(time.Minute))
+	g.Expect(buildRetryDelay(3)).To(gomega.Equal(4 * time.Minute))
+	g.Expect(buildRetryDelay(5)).To(gomega.Equal(10 * time.Minute))
+	g.Expect(buildRetryDelay(100)).To(gomega.Equal(10 * time.Minute))
+}
+
+func TestRetryBuild(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	mgr, err := manager.New(cfg, manager.Options{})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	c := mgr.GetClient()
+	reconcileFunction := &ReconcileFunction{Client: c, scheme: scheme.Scheme}
+	rnInfo := &runtimeUtil.RuntimeInfo{MaxBuildRetries: 2}
+
+	stopMgr, mgrStopped := StartTestManager(mgr, g)
+	defer func() {
+		close(stopMgr)
+		mgrStopped.Wait()
+	}()
+
+	tests := []struct {
+		name      string
+		message   string
+		retries   int32
+		completed time.Duration
+		wait      bool
+		retried   bool
+	}{
+		{name: "test-retry-permanent", message: "UNAUTHORIZED: authentication required", completed: time.Hour},
+		{name: "test-retry-transient", message: "503 Service Unavailable", completed: time.Hour, retried: true},
+		{name: "test-retry-backoff", message: "503 Service Unavailable", retries: 1, completed: 30 * time.Second, wait: true},
+		{name: "test-retry-exhausted", message: "503 Service Unavailable", retries: 2, completed: time.Hour},
+	}
+
+	for _, test := range tests {
+		function := &runtimev1alpha1.Function{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      test.name,
+				Namespace: "default",
+			},
+		}
+		g.Expect(c.Create(context.TODO(), function)).Should(gomega.Succeed())
+		defer c.Delete(context.TODO(), function)
+		function.Status.Build = &runtimev1alpha1.FunctionBuildStatus{Name: test.name, Retries: test.retries}
+
+		build := &buildv1alpha1.Build{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      test.name,
+				Namespace: "default",
+				Annotations: map[string]string{
+					buildRetryAnnotation: fmt.Sprint(test.retries),
+				},
+			},
+		}
+		g.Expect(c.Create(context.TODO(), build)).Should(gomega.Succeed())
+		defer c.Delete(context.TODO(), build)
+
+		g.Eventually(func() error {
+			return c.Get(context.TODO(), types.NamespacedName{Name: test.name, Namespace: "default"}, build)
+		}).Should(gomega.Succeed())
+		build.Status = failedBuildStatus(test.message, 1, "Error")
+		build.Status.CompletionTime = &metav1.Time{Time: time.Now().Add(-test.completed)}
+		g.Expect(c.Status().Update(context.TODO(), build)).Should(gomega.Succeed())
+		g.Eventually(func() bool {
+			c.Get(context.TODO(), types.NamespacedName{Name: test.name, Namespace: "default"}, build)
+			failed, _ := buildFailure(build)
+			return failed
+		}).Should(gomega.BeTrue())
+
+		wait, err := reconcileFunction.retryBuild(rnInfo, function, test.name)
+		g.Expect(err).NotTo(gomega.HaveOccurred(), test.name)
+
+		if test.wait {
+			g.Expect(wait).To(gomega.BeNumerically("~", 30*time.Second, 5*time.Second), test.name)
+			g.Expect(function.Status.Condition).To(gomega.Equal(runtimev1alpha1.FunctionConditionBuilding), test.name)
+		} else if test.retried {
+			g.Expect(wait).To(gomega.BeNumerically(">", 0), test.name)
+		} else {
+			g.Expect(wait).To(gomega.BeZero(), test.name)
+		}
+
+		if test.retried {
+			g.Expect(function.Status.Build.Retries).To(gomega.Equal(test.retries+1), test.name)
+			g.Eventually(func() bool {
+				return errors.IsNotFound(c.Get(context.TODO(), types.NamespacedName{Name: test.name, Namespace: "default"}, &buildv1alpha1.Build{}))
+			}).Should(gomega.BeTrue(), test.name)
+		} else {
+			g.Expect(function.Status.Build.Retries).To(gomega.Equal(test.retries), test.name)
+			g.Expect(c.Get(context.TODO(), types.NamespacedName{Name: test.name, Namespace: "default"}, &buildv1alpha1.Build{})).To(gomega.Succeed(), test.name)
+		}
+	}
+}
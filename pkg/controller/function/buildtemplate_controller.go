@@ -0,0 +1,258 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"context"
+
+	buildv1alpha1 "github.com/knative/build/pkg/apis/build/v1alpha1"
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	runtimeUtil "github.com/kyma-incubator/runtime/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var (
+	_ reconcile.Reconciler = &ReconcileBuildTemplate{}
+
+	// labels of the managed build templates
+	buildTemplateLabels = map[string]string{"app.kubernetes.io/managed-by": "function-controller"}
+)
+
+// newBuildTemplateReconciler returns a new reconcile.Reconciler for the shared build template
+func newBuildTemplateReconciler(mgr manager.Manager) reconcile.Reconciler {
+	return &ReconcileBuildTemplate{Client: mgr.GetClient()}
+}
+
+// addBuildTemplate adds a new Controller managing the shared build template to mgr with r as the reconcile.Reconciler
+func addBuildTemplate(mgr manager.Manager, r reconcile.Reconciler) error {
+	c, err := controller.New("buildtemplate-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to the Function controller's configuration
+	err = c.Watch(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+			if obj.Meta.GetName() != fnConfigName || obj.Meta.GetNamespace() != fnConfigNamespace {
+				return nil
+			}
+			return buildTemplateRequests()
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to the managed ClusterBuildTemplates
+	err = c.Watch(&source.Kind{Type: &buildv1alpha1.ClusterBuildTemplate{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+			if obj.Meta.GetName() != buildTemplateName && !isManagedBuildTemplate(obj.Meta) {
+				return nil
+			}
+			return buildTemplateRequests()
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for BuildTemplates created per namespace by previous versions of the controller
+	err = c.Watch(&source.Kind{Type: &buildv1alpha1.BuildTemplate{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+			if obj.Meta.GetName() != buildTemplateName {
+				return nil
+			}
+			return buildTemplateRequests()
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// buildTemplateRequests returns the only request handled by the build template controller
+func buildTemplateRequests() []reconcile.Request {
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: buildTemplateName}}}
+}
+
+// ReconcileBuildTemplate reconciles the ClusterBuildTemplate shared by the Builds of all Functions
+type ReconcileBuildTemplate struct {
+	client.Client
+}
+
+// Reconcile keeps the shared ClusterBuildTemplate in sync with the runtimes of the Function controller's
+// configuration and removes the BuildTemplates previously created per namespace.
+func (r *ReconcileBuildTemplate) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+
+	fnConfig := &corev1.ConfigMap{}
+	err := r.Get(context.TODO(), types.NamespacedName{Name: fnConfigName, Namespace: fnConfigNamespace}, fnConfig)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("Function controller's configuration not found, skipping the build template", "namespace", fnConfigNamespace, "name", fnConfigName)
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	rnInfo, err := runtimeUtil.New(fnConfig)
+	if err != nil {
+		log.Error(err, "Error while trying to get a new RuntimeInfo instance", "namespace", fnConfig.Namespace, "name", fnConfig.Name)
+		return reconcile.Result{}, err
+	}
+
+	if err := r.clusterBuildTemplates(rnInfo); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if err := r.migrateBuildTemplates(); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{}, nil
+}
+
+// clusterBuildTemplates creates or updates the shared ClusterBuildTemplate and the ones of runtimes overriding the
+// build resources. ClusterBuildTemplates of runtimes which no longer override them are deleted.
+func (r *ReconcileBuildTemplate) clusterBuildTemplates(rnInfo *runtimeUtil.RuntimeInfo) error {
+
+	templateRuntimes := rnInfo.BuildTemplateRuntimes()
+	for name, runtime := range templateRuntimes {
+		if err := r.clusterBuildTemplate(name, runtimeUtil.GetBuildTemplateSpec(rnInfo, runtime)); err != nil {
+			return err
+		}
+	}
+
+	buildTemplates := &buildv1alpha1.ClusterBuildTemplateList{}
+	if err := r.List(context.TODO(), client.MatchingLabels(buildTemplateLabels), buildTemplates); err != nil {
+		log.Error(err, "Error while trying to list Knative ClusterBuildTemplates")
+		return err
+	}
+
+	for i := range buildTemplates.Items {
+		buildTemplate := &buildTemplates.Items[i]
+		if _, ok := templateRuntimes[buildTemplate.Name]; ok {
+			continue
+		}
+
+		log.Info("Deleting unused Knative ClusterBuildTemplate", "name", buildTemplate.Name)
+		if err := r.Delete(context.TODO(), buildTemplate); err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Error while trying to delete Knative ClusterBuildTemplate", "name", buildTemplate.Name)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// clusterBuildTemplate creates or updates a ClusterBuildTemplate
+func (r *ReconcileBuildTemplate) clusterBuildTemplate(name string, spec buildv1alpha1.BuildTemplateSpec) error {
+
+	deployBuildTemplate := &buildv1alpha1.ClusterBuildTemplate{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "build.knative.dev/v1alpha1",
+			Kind:       "ClusterBuildTemplate",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: buildTemplateLabels,
+		},
+		Spec: spec,
+	}
+
+	foundBuildTemplate := &buildv1alpha1.ClusterBuildTemplate{}
+	err := r.Get(context.TODO(), types.NamespacedName{Name: deployBuildTemplate.Name}, foundBuildTemplate)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating Knative ClusterBuildTemplate", "name", deployBuildTemplate.Name)
+		if err := r.Create(context.TODO(), deployBuildTemplate); err != nil {
+			log.Error(err, "Error while trying to Create Knative ClusterBuildTemplate", "name", deployBuildTemplate.Name)
+			return err
+		}
+		return nil
+
+	} else if err != nil {
+		log.Error(err, "Error while trying to get Knative ClusterBuildTemplate", "name", deployBuildTemplate.Name)
+		return err
+	}
+
+	// resource quantities are compared semantically, they are normalized by the API server
+	if !equality.Semantic.DeepEqual(deployBuildTemplate.Spec, foundBuildTemplate.Spec) {
+		foundBuildTemplate.Spec = deployBuildTemplate.Spec
+		log.Info("Updating Knative ClusterBuildTemplate", "name", deployBuildTemplate.Name)
+		if err := r.Update(context.TODO(), foundBuildTemplate); err != nil {
+			log.Error(err, "Error while trying to Update Knative ClusterBuildTemplate", "name", deployBuildTemplate.Name)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateBuildTemplates deletes the BuildTemplates which were created in the namespaces of Functions and owned by
+// one of them. BuildTemplates not owned by a Function are left untouched.
+func (r *ReconcileBuildTemplate) migrateBuildTemplates() error {
+
+	buildTemplates := &buildv1alpha1.BuildTemplateList{}
+	if err := r.List(context.TODO(), &client.ListOptions{}, buildTemplates); err != nil {
+		log.Error(err, "Error while trying to list Knative BuildTemplates")
+		return err
+	}
+
+	for i := range buildTemplates.Items {
+		buildTemplate := &buildTemplates.Items[i]
+		if buildTemplate.Name != buildTemplateName || !isOwnedByFunction(buildTemplate) {
+			continue
+		}
+
+		log.Info("Deleting the Knative BuildTemplate replaced by the ClusterBuildTemplate", "namespace", buildTemplate.Namespace, "name", buildTemplate.Name)
+		if err := r.Delete(context.TODO(), buildTemplate); err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Error while trying to delete Knative BuildTemplate", "namespace", buildTemplate.Namespace, "name", buildTemplate.Name)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isManagedBuildTemplate checks whether a ClusterBuildTemplate is managed by the build template controller
+func isManagedBuildTemplate(obj metav1.Object) bool {
+	for key, value := range buildTemplateLabels {
+		if obj.GetLabels()[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// isOwnedByFunction checks whether the controller of obj is a Function
+func isOwnedByFunction(obj metav1.Object) bool {
+	owner := metav1.GetControllerOf(obj)
+	return owner != nil &&
+		owner.Kind == "Function" &&
+		owner.APIVersion == runtimev1alpha1.SchemeGroupVersion.String()
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"testing"
+
+	buildv1alpha1 "github.com/knative/build/pkg/apis/build/v1alpha1"
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	runtimeUtil "github.com/kyma-incubator/runtime/pkg/utils"
+	"github.com/onsi/gomega"
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+func TestReconcileBuildTemplate(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	templateKey := types.NamespacedName{Name: "function-kaniko"}
+
+	fnConfig := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "fn-config",
+			Namespace: "default",
+		},
+		Data: map[string]string{
+			"dockerRegistry":     "test",
+			"serviceAccountName": "build-bot",
+			"runtimes": `[
+				{
+					"ID": "nodejs8",
+					"DockerFileName": "dockerfile-nodejs-8",
+				}
+			]`,
+		},
+	}
+	fn := &runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-build-template-owner",
+			Namespace: "default",
+		},
+		Spec: runtimev1alpha1.FunctionSpec{
+			Function:            "main() {}",
+			FunctionContentType: "plaintext",
+			Size:                "S",
+			Runtime:             "nodejs8",
+		},
+	}
+
+	// start manager with the build template controller only
+	mgr, err := manager.New(cfg, manager.Options{})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	c = mgr.GetClient()
+	g.Expect(addBuildTemplate(mgr, newBuildTemplateReconciler(mgr))).NotTo(gomega.HaveOccurred())
+	stopMgr, mgrStopped := StartTestManager(mgr, g)
+	defer func() {
+		close(stopMgr)
+		mgrStopped.Wait()
+	}()
+
+	// BuildTemplates created by previous versions of the controller are owned by a Function
+	g.Expect(c.Create(context.TODO(), fn)).NotTo(gomega.HaveOccurred())
+	defer c.Delete(context.TODO(), fn)
+	ownedBuildTemplate := &buildv1alpha1.BuildTemplate{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "function-kaniko",
+			Namespace: "default",
+		},
+		Spec: runtimeUtil.GetBuildTemplateSpec(&runtimeUtil.RuntimeInfo{}, ""),
+	}
+	g.Expect(controllerutil.SetControllerReference(fn, ownedBuildTemplate, scheme.Scheme)).NotTo(gomega.HaveOccurred())
+	g.Expect(c.Create(context.TODO(), ownedBuildTemplate)).NotTo(gomega.HaveOccurred())
+
+	// BuildTemplates of users are kept
+	userBuildTemplate := &buildv1alpha1.BuildTemplate{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "function-kaniko",
+			Namespace: "kube-public",
+		},
+		Spec: runtimeUtil.GetBuildTemplateSpec(&runtimeUtil.RuntimeInfo{}, ""),
+	}
+	g.Expect(c.Create(context.TODO(), userBuildTemplate)).NotTo(gomega.HaveOccurred())
+	defer c.Delete(context.TODO(), userBuildTemplate)
+
+	g.Expect(c.Create(context.TODO(), fnConfig)).NotTo(gomega.HaveOccurred())
+	defer c.Delete(context.TODO(), fnConfig)
+
+	// the shared build template is created from the available runtimes
+	clusterBuildTemplate := &buildv1alpha1.ClusterBuildTemplate{}
+	defer c.Delete(context.TODO(), clusterBuildTemplate)
+	volumeNames := func() []string {
+		names := []string{}
+		if err := c.Get(context.TODO(), templateKey, clusterBuildTemplate); err != nil {
+			return names
+		}
+		for _, volume := range clusterBuildTemplate.Spec.Volumes {
+			names = append(names, volume.ConfigMap.LocalObjectReference.Name)
+		}
+		return names
+	}
+	g.Eventually(volumeNames, timeout).Should(gomega.Equal([]string{"dockerfile-nodejs-8"}))
+	g.Expect(clusterBuildTemplate.Labels).To(gomega.HaveKeyWithValue("app.kubernetes.io/managed-by", "function-controller"))
+	g.Expect(clusterBuildTemplate.OwnerReferences).To(gomega.BeEmpty())
+
+	// the BuildTemplate owned by a Function is migrated
+	g.Eventually(func() bool {
+		err := c.Get(context.TODO(), types.NamespacedName{Name: "function-kaniko", Namespace: "default"}, &buildv1alpha1.BuildTemplate{})
+		return errors.IsNotFound(err)
+	}, timeout).Should(gomega.BeTrue())
+	g.Consistently(func() error {
+		return c.Get(context.TODO(), types.NamespacedName{Name: "function-kaniko", Namespace: "kube-public"}, &buildv1alpha1.BuildTemplate{})
+	}).Should(gomega.Succeed())
+
+	// the shared build template follows the runtimes of the configuration
+	g.Expect(c.Get(context.TODO(), types.NamespacedName{Name: fnConfig.Name, Namespace: fnConfig.Namespace}, fnConfig)).NotTo(gomega.HaveOccurred())
+	fnConfig.Data["runtimes"] = `[
+		{
+			"ID": "nodejs8",
+			"DockerFileName": "dockerfile-nodejs-8",
+		},
+		{
+			"ID": "nodejs10",
+			"DockerFileName": "dockerfile-nodejs-10",
+		}
+	]`
+	g.Expect(c.Update(context.TODO(), fnConfig)).NotTo(gomega.HaveOccurred())
+	g.Eventually(volumeNames, timeout).Should(gomega.Equal([]string{"dockerfile-nodejs-8", "dockerfile-nodejs-10"}))
+
+	// runtimes overriding the build resources get a build template of their own
+	runtimeTemplateKey := types.NamespacedName{Name: "function-kaniko-nodejs10"}
+	g.Expect(c.Get(context.TODO(), types.NamespacedName{Name: fnConfig.Name, Namespace: fnConfig.Namespace}, fnConfig)).NotTo(gomega.HaveOccurred())
+	fnConfig.Data["runtimes"] = `[
+		{
+			"ID": "nodejs8",
+			"DockerFileName": "dockerfile-nodejs-8",
+		},
+		{
+			"ID": "nodejs10",
+			"DockerFileName": "dockerfile-nodejs-10",
+			"build": {"resources": {"limits": {"memory": "4Gi"}}},
+		}
+	]`
+	g.Expect(c.Update(context.TODO(), fnConfig)).NotTo(gomega.HaveOccurred())
+	runtimeBuildTemplate := &buildv1alpha1.ClusterBuildTemplate{}
+	g.Eventually(func() string {
+		if err := c.Get(context.TODO(), runtimeTemplateKey, runtimeBuildTemplate); err != nil {
+			return ""
+		}
+		return runtimeBuildTemplate.Spec.Steps[0].Resources.Limits.Memory().String()
+	}, timeout).Should(gomega.Equal("4Gi"))
+	g.Expect(clusterBuildTemplate.Spec.Steps[0].Resources.Limits).To(gomega.BeEmpty())
+
+	// and lose it once they don't override them anymore
+	g.Expect(c.Get(context.TODO(), types.NamespacedName{Name: fnConfig.Name, Namespace: fnConfig.Namespace}, fnConfig)).NotTo(gomega.HaveOccurred())
+	fnConfig.Data["runtimes"] = `[
+		{
+			"ID": "nodejs8",
+			"DockerFileName": "dockerfile-nodejs-8",
+		},
+		{
+			"ID": "nodejs10",
+			"DockerFileName": "dockerfile-nodejs-10",
+		}
+	]`
+	g.Expect(c.Update(context.TODO(), fnConfig)).NotTo(gomega.HaveOccurred())
+	g.Eventually(func() bool {
+		return errors.IsNotFound(c.Get(context.TODO(), runtimeTemplateKey, &buildv1alpha1.ClusterBuildTemplate{}))
+	}, timeout).Should(gomega.BeTrue())
+
+	// deleting a Function doesn't affect the shared build template
+	g.Expect(c.Delete(context.TODO(), fn)).NotTo(gomega.HaveOccurred())
+	g.Consistently(func() error {
+		return c.Get(context.TODO(), templateKey, &buildv1alpha1.ClusterBuildTemplate{})
+	}).Should(gomega.Succeed())
+
+	// the shared build template is restored once deleted
+	deletedUID := clusterBuildTemplate.UID
+	g.Expect(c.Delete(context.TODO(), clusterBuildTemplate)).NotTo(gomega.HaveOccurred())
+	g.Eventually(func() types.UID {
+		restored := &buildv1alpha1.ClusterBuildTemplate{}
+		c.Get(context.TODO(), templateKey, restored)
+		return restored.UID
+	}, timeout).ShouldNot(gomega.Or(gomega.BeEmpty(), gomega.Equal(deletedUID)))
+	g.Expect(volumeNames()).To(gomega.Equal([]string{"dockerfile-nodejs-8", "dockerfile-nodejs-10"}))
+}
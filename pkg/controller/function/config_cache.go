@@ -0,0 +1,242 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	runtimeUtil "github.com/kyma-incubator/runtime/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// fnConfigs caches the parsed configuration of the Function controller
+var fnConfigs = newConfigCache()
+
+// configCache holds the last valid configuration of the Function controller. Each version of the ConfigMap is parsed
+// once, the last valid configuration stays in use while the ConfigMap is invalid.
+type configCache struct {
+	mu sync.Mutex
+
+	// version of the ConfigMap parsed last, valid or not
+	resourceVersion string
+
+	// last valid configuration and the ConfigMap it was parsed from
+	rnInfo *runtimeUtil.RuntimeInfo
+	config *corev1.ConfigMap
+
+	// error of the version parsed last if it is invalid
+	err error
+
+	// version and configuration the handler enqueued the Functions for last and the invalid version it reported last.
+	// Reconciles parse new versions as well, the handler keeps its own state so they don't hide changes from it.
+	enqueuedVersion string
+	enqueuedRnInfo  *runtimeUtil.RuntimeInfo
+	reportedVersion string
+}
+
+func newConfigCache() *configCache {
+	return &configCache{}
+}
+
+// load parses a version of the configuration unless it was parsed before. It returns the configuration in use, the
+// last valid one if the version is invalid, and the error of the version.
+func (cc *configCache) load(fnConfig *corev1.ConfigMap) (*runtimeUtil.RuntimeInfo, error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if fnConfig.ResourceVersion != "" && fnConfig.ResourceVersion == cc.resourceVersion {
+		return cc.rnInfo, cc.err
+	}
+	cc.resourceVersion = fnConfig.ResourceVersion
+
+	rnInfo, err := runtimeUtil.New(fnConfig)
+	cc.err = err
+	if err != nil {
+		return cc.rnInfo, err
+	}
+
+	cc.rnInfo, cc.config = rnInfo, fnConfig.DeepCopy()
+	return rnInfo, nil
+}
+
+// change returns the configuration in use for a version and the one the Functions were enqueued for last, changed is
+// false unless the version replaced the configuration the Functions were enqueued for
+func (cc *configCache) change(fnConfig *corev1.ConfigMap) (current *runtimeUtil.RuntimeInfo, replaced *runtimeUtil.RuntimeInfo, changed bool) {
+	rnInfo, _ := cc.load(fnConfig)
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if fnConfig.ResourceVersion != "" && fnConfig.ResourceVersion == cc.enqueuedVersion {
+		return rnInfo, nil, false
+	}
+	cc.enqueuedVersion = fnConfig.ResourceVersion
+	if rnInfo == nil || rnInfo == cc.enqueuedRnInfo {
+		return rnInfo, nil, false
+	}
+
+	replaced, cc.enqueuedRnInfo = cc.enqueuedRnInfo, rnInfo
+	return rnInfo, replaced, true
+}
+
+// rejection returns the error of an invalid version of the configuration once, nil if it is valid
+func (cc *configCache) rejection(fnConfig *corev1.ConfigMap) error {
+	_, err := cc.load(fnConfig)
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if err == nil || cc.reportedVersion == fnConfig.ResourceVersion {
+		return nil
+	}
+	cc.reportedVersion = fnConfig.ResourceVersion
+	return err
+}
+
+// runtimeInfo returns the configuration to reconcile the Functions of a namespace with, the last valid one if fnConfig
+// is invalid. It is overridden by the configuration of the namespace if there is one.
+func (cc *configCache) runtimeInfo(fnConfig, nsConfig *corev1.ConfigMap) (*runtimeUtil.RuntimeInfo, error) {
+	rnInfo, err := cc.load(fnConfig)
+
+	cc.mu.Lock()
+	config := cc.config
+	cc.mu.Unlock()
+
+	if rnInfo == nil {
+		return nil, err
+	}
+	if nsConfig == nil {
+		return rnInfo, nil
+	}
+	return runtimeUtil.New(config, nsConfig)
+}
+
+// fnConfigHandler enqueues the Functions affected by changes of the controller's configuration and of the
+// configurations of namespaces. Rejected versions of the controller's configuration are reported as Event of the
+// ConfigMap. Deleting the controller's configuration keeps the last valid one.
+func fnConfigHandler(c client.Client, recorder record.EventRecorder, cache *configCache) handler.EventHandler {
+	enqueue := func(obj runtime.Object, q workqueue.RateLimitingInterface) {
+		for _, request := range fnConfigRequests(c, recorder, cache, obj) {
+			q.Add(request)
+		}
+	}
+	return &handler.Funcs{
+		CreateFunc: func(evt event.CreateEvent, q workqueue.RateLimitingInterface) {
+			enqueue(evt.Object, q)
+		},
+		UpdateFunc: func(evt event.UpdateEvent, q workqueue.RateLimitingInterface) {
+			enqueue(evt.ObjectNew, q)
+		},
+		DeleteFunc: func(evt event.DeleteEvent, q workqueue.RateLimitingInterface) {
+			if evt.Meta.GetNamespace() != fnConfigNamespace {
+				enqueue(evt.Object, q)
+			}
+		},
+		GenericFunc: func(evt event.GenericEvent, q workqueue.RateLimitingInterface) {
+			enqueue(evt.Object, q)
+		},
+	}
+}
+
+// fnConfigRequests returns the requests of the Functions affected by a version of the controller's configuration
+func fnConfigRequests(c client.Client, recorder record.EventRecorder, cache *configCache, obj runtime.Object) []reconcile.Request {
+	fnConfig, ok := obj.(*corev1.ConfigMap)
+	if !ok || fnConfig.Name != fnConfigName {
+		return nil
+	}
+	if fnConfig.Namespace != fnConfigNamespace {
+		return nsConfigRequests(c, fnConfig.Namespace)
+	}
+
+	if err := cache.rejection(fnConfig); err != nil {
+		log.Error(err, "Rejected Function controller's configuration", "namespace", fnConfig.Namespace, "name", fnConfig.Name)
+		recorder.Eventf(fnConfig, corev1.EventTypeWarning, configInvalidReason, "Configuration rejected, the last valid one stays in use: %v", err)
+		return nil
+	}
+	rnInfo, replaced, changed := cache.change(fnConfig)
+	if !changed {
+		return nil
+	}
+
+	functions := &runtimev1alpha1.FunctionList{}
+	if err := c.List(context.TODO(), &client.ListOptions{}, functions); err != nil {
+		log.Error(err, "Error while trying to list the Functions affected by the configuration")
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, fn := range functions.Items {
+		if isAffectedByConfig(replaced, rnInfo, fn.Spec.Runtime) {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: fn.Name, Namespace: fn.Namespace}})
+		}
+	}
+	log.Info("Function controller's configuration changed", "functions", len(requests))
+	return requests
+}
+
+// nsConfigRequests returns the requests of the Functions of a namespace, they are all affected by the configuration of
+// the namespace
+func nsConfigRequests(c client.Client, namespace string) []reconcile.Request {
+	functions := &runtimev1alpha1.FunctionList{}
+	if err := c.List(context.TODO(), &client.ListOptions{Namespace: namespace}, functions); err != nil {
+		log.Error(err, "Error while trying to list the Functions affected by the configuration of the namespace", "namespace", namespace)
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, fn := range functions.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: fn.Name, Namespace: fn.Namespace}})
+	}
+	return requests
+}
+
+// isAffectedByConfig checks whether a Function of a runtime is affected by a change of the configuration. Changes of
+// the runtimes only affect the Functions of the changed runtimes, all other changes affect every Function.
+func isAffectedByConfig(replaced, rnInfo *runtimeUtil.RuntimeInfo, runtime string) bool {
+	if replaced == nil {
+		return true
+	}
+
+	replacedSettings, settings := *replaced, *rnInfo
+	replacedSettings.AvailableRuntimes, settings.AvailableRuntimes = nil, nil
+	if !reflect.DeepEqual(replacedSettings, settings) {
+		return true
+	}
+
+	return !reflect.DeepEqual(findRuntime(replaced, runtime), findRuntime(rnInfo, runtime))
+}
+
+// findRuntime returns the configuration of a runtime, nil if the runtime isn't available
+func findRuntime(rnInfo *runtimeUtil.RuntimeInfo, runtime string) *runtimeUtil.RuntimesSupported {
+	for i := range rnInfo.AvailableRuntimes {
+		if rnInfo.AvailableRuntimes[i].ID == runtime {
+			return &rnInfo.AvailableRuntimes[i]
+		}
+	}
+	return nil
+}
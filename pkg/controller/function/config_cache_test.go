@@ -0,0 +1,215 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"testing"
+
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// testFnConfig returns a version of the Function controller's configuration
+func testFnConfig(resourceVersion string, data map[string]string) *corev1.ConfigMap {
+	config := map[string]string{
+		"dockerRegistry":     "test",
+		"serviceAccountName": "build-bot",
+		"runtimes": `[
+			{"ID": "nodejs6", "DockerFileName": "dockerfile-nodejs6"},
+			{"ID": "nodejs8", "DockerFileName": "dockerfile-nodejs8"}
+		]`,
+	}
+	for key, value := range data {
+		config[key] = value
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            fnConfigName,
+			Namespace:       fnConfigNamespace,
+			ResourceVersion: resourceVersion,
+		},
+		Data: config,
+	}
+}
+
+func TestFnConfigRequests(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	function := func(name, runtime string) *runtimev1alpha1.Function {
+		return &runtimev1alpha1.Function{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       runtimev1alpha1.FunctionSpec{Runtime: runtime},
+		}
+	}
+	request := func(name string) reconcile.Request {
+		return reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: "default"}}
+	}
+
+	c := fake.NewFakeClient(function("node6", "nodejs6"), function("node8", "nodejs8"))
+	recorder := record.NewFakeRecorder(10)
+	cache := newConfigCache()
+
+	// the first valid configuration affects every function
+	g.Expect(fnConfigRequests(c, recorder, cache, testFnConfig("1", nil))).
+		To(gomega.ConsistOf(request("node6"), request("node8")))
+
+	// a version seen before doesn't affect any function
+	g.Expect(fnConfigRequests(c, recorder, cache, testFnConfig("1", nil))).To(gomega.BeEmpty())
+
+	// ConfigMaps other than the configuration don't affect any function
+	other := testFnConfig("2", map[string]string{"dockerRegistry": "other"})
+	other.Name = "other"
+	g.Expect(fnConfigRequests(c, recorder, cache, other)).To(gomega.BeEmpty())
+
+	// changes of a runtime only affect the functions of the runtime
+	g.Expect(fnConfigRequests(c, recorder, cache, testFnConfig("3", map[string]string{
+		"runtimes": `[
+			{"ID": "nodejs6", "DockerFileName": "dockerfile-nodejs6"},
+			{"ID": "nodejs8", "DockerFileName": "dockerfile-nodejs8-v2"}
+		]`,
+	}))).To(gomega.ConsistOf(request("node8")))
+
+	// other changes affect every function
+	g.Expect(fnConfigRequests(c, recorder, cache, testFnConfig("4", map[string]string{
+		"runtimes": `[
+			{"ID": "nodejs6", "DockerFileName": "dockerfile-nodejs6"},
+			{"ID": "nodejs8", "DockerFileName": "dockerfile-nodejs8-v2"}
+		]`,
+		"dockerRegistry": "registry.example.com",
+	}))).To(gomega.ConsistOf(request("node6"), request("node8")))
+	g.Expect(recorder.Events).To(gomega.BeEmpty())
+
+	// an invalid configuration is reported once and doesn't affect any function
+	invalid := testFnConfig("5", map[string]string{"maxBuildRetries": "-1"})
+	g.Expect(fnConfigRequests(c, recorder, cache, invalid)).To(gomega.BeEmpty())
+	g.Expect(recorder.Events).To(gomega.HaveLen(1))
+	g.Expect(<-recorder.Events).To(gomega.HavePrefix(corev1.EventTypeWarning + " ConfigInvalid "))
+	g.Expect(fnConfigRequests(c, recorder, cache, invalid)).To(gomega.BeEmpty())
+	g.Expect(recorder.Events).To(gomega.BeEmpty())
+
+	// the last valid configuration stays in use
+	rnInfo, err := cache.runtimeInfo(invalid, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(rnInfo.RegistryInfo).To(gomega.Equal("registry.example.com"))
+
+	// build pod tolerations aren't supported, the configuration is rejected instead of dropping them
+	tolerations := testFnConfig("6", map[string]string{
+		"dockerRegistry": "other.example.com",
+		"build":          "tolerations:\n- key: builds\n  operator: Exists\n",
+	})
+	g.Expect(fnConfigRequests(c, recorder, cache, tolerations)).To(gomega.BeEmpty())
+	g.Expect(<-recorder.Events).To(gomega.Equal(corev1.EventTypeWarning + " ConfigInvalid Configuration rejected, the last valid one stays in use: " +
+		"Build pod tolerations are not supported by Knative Build, use nodeSelector or affinity instead"))
+	rnInfo, err = cache.runtimeInfo(tolerations, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(rnInfo.BuildSettings("nodejs8").Tolerations).To(gomega.BeEmpty())
+	g.Expect(rnInfo.RegistryInfo).To(gomega.Equal("registry.example.com"))
+}
+
+func TestFnConfigRequestsAfterReconcile(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	c := fake.NewFakeClient(&runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"},
+		Spec:       runtimev1alpha1.FunctionSpec{Runtime: "nodejs8"},
+	})
+	recorder := record.NewFakeRecorder(10)
+	cache := newConfigCache()
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Name: "hello", Namespace: "default"}}
+
+	g.Expect(fnConfigRequests(c, recorder, cache, testFnConfig("1", nil))).To(gomega.ConsistOf(request))
+
+	// a reconcile parsing a new version before its event reaches the handler doesn't hide the change
+	updated := testFnConfig("2", map[string]string{"dockerRegistry": "registry.example.com"})
+	_, err := cache.runtimeInfo(updated, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(fnConfigRequests(c, recorder, cache, updated)).To(gomega.ConsistOf(request))
+	g.Expect(fnConfigRequests(c, recorder, cache, updated)).To(gomega.BeEmpty())
+
+	// nor the rejection of an invalid version
+	invalid := testFnConfig("3", map[string]string{"maxBuildRetries": "-1"})
+	_, err = cache.runtimeInfo(invalid, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(fnConfigRequests(c, recorder, cache, invalid)).To(gomega.BeEmpty())
+	g.Expect(recorder.Events).To(gomega.HaveLen(1))
+	g.Expect(<-recorder.Events).To(gomega.HavePrefix(corev1.EventTypeWarning + " ConfigInvalid "))
+}
+
+func TestConfigCacheRuntimeInfo(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	cache := newConfigCache()
+
+	// there is no valid configuration to fall back to
+	invalid := testFnConfig("1", map[string]string{"maxBuildRetries": "-1"})
+	_, err := cache.runtimeInfo(invalid, nil)
+	g.Expect(err).To(gomega.HaveOccurred())
+	_, err = cache.runtimeInfo(invalid, nil)
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	rnInfo, err := cache.runtimeInfo(testFnConfig("2", nil), nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(rnInfo.RegistryInfo).To(gomega.Equal("test"))
+
+	// a version seen before isn't parsed again
+	cached, err := cache.runtimeInfo(testFnConfig("2", nil), nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(cached).To(gomega.BeIdenticalTo(rnInfo))
+
+	updated, err := cache.runtimeInfo(testFnConfig("3", map[string]string{"dockerRegistry": "registry.example.com"}), nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(updated.RegistryInfo).To(gomega.Equal("registry.example.com"))
+
+	// the configuration of a namespace overrides the last valid configuration
+	nsConfig := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: fnConfigName, Namespace: "team-a"},
+		Data:       map[string]string{"dockerRegistry": "registry.team-a.example.com"},
+	}
+	overridden, err := cache.runtimeInfo(testFnConfig("4", map[string]string{"maxBuildRetries": "-1"}), nsConfig)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(overridden.RegistryInfo).To(gomega.Equal("registry.team-a.example.com"))
+	g.Expect(overridden.BuildServiceAccount).To(gomega.Equal("build-bot"))
+	g.Expect(overridden.Sources).To(gomega.Equal([]string{fnConfigNamespace + "/" + fnConfigName, "team-a/" + fnConfigName}))
+
+	nsConfig.Data["maxBuildRetries"] = "10"
+	_, err = cache.runtimeInfo(testFnConfig("4", nil), nsConfig)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestNsConfigRequests(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	c := fake.NewFakeClient(
+		&runtimev1alpha1.Function{ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "team-a"}},
+		&runtimev1alpha1.Function{ObjectMeta: metav1.ObjectMeta{Name: "payments", Namespace: "team-b"}},
+	)
+	nsConfig := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: fnConfigName, Namespace: "team-a"},
+		Data:       map[string]string{"dockerRegistry": "registry.team-a.example.com"},
+	}
+
+	// the configuration of a namespace only affects the functions of the namespace
+	g.Expect(fnConfigRequests(c, record.NewFakeRecorder(10), newConfigCache(), nsConfig)).To(gomega.ConsistOf(
+		reconcile.Request{NamespacedName: types.NamespacedName{Name: "orders", Namespace: "team-a"}},
+	))
+}
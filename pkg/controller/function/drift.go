@@ -0,0 +1,93 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// annotation of a child of a Function holding the hash of the desired state applied last
+	desiredStateAnnotation = "runtime.kyma-project.io/desired-state"
+
+	// annotation of a child of a Function holding the hash of its state as persisted once the desired state got applied
+	appliedStateAnnotation = "runtime.kyma-project.io/applied-state"
+
+	// annotation of a child of a Function which is left alone by the controller while set to "true", e.g. for debugging
+	unmanagedAnnotation = "runtime.kyma-project.io/unmanaged"
+
+	// reason of the events of children restored to their desired state
+	driftCorrectedReason = "DriftCorrected"
+)
+
+// desiredStateHash returns the hash of the desired state of a child
+func desiredStateHash(desired interface{}) (string, error) {
+	data, err := json.Marshal(desired)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+// setDesiredState records the hash of the desired state of a child
+func setDesiredState(obj metav1.Object, hash string) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[desiredStateAnnotation] = hash
+	obj.SetAnnotations(annotations)
+}
+
+// isUnmanaged checks whether a child opted out of being reconciled
+func isUnmanaged(obj metav1.Object) bool {
+	return obj.GetAnnotations()[unmanagedAnnotation] == "true"
+}
+
+// hasDrifted checks whether the persisted state of a child differs from the one recorded when its desired state got
+// applied last. Children without a recorded state haven't drifted.
+func hasDrifted(obj metav1.Object, state interface{}) (bool, error) {
+	applied, ok := obj.GetAnnotations()[appliedStateAnnotation]
+	if !ok {
+		return false, nil
+	}
+	hash, err := desiredStateHash(state)
+	if err != nil {
+		return false, err
+	}
+	return hash != applied, nil
+}
+
+// setAppliedState records the hash of the persisted state of a child. It includes the fields defaulted by the API
+// server and webhooks, so fields added by hand are detected whether the desired state sets them or not.
+func setAppliedState(obj metav1.Object, state interface{}) error {
+	hash, err := desiredStateHash(state)
+	if err != nil {
+		return err
+	}
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[appliedStateAnnotation] = hash
+	obj.SetAnnotations(annotations)
+	return nil
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"strings"
+	"testing"
+
+	servingv1alpha1 "github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	"github.com/onsi/gomega"
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestHasDrifted(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	desired := corev1.PodSpec{Containers: []corev1.Container{{
+		Image: "test/default-foo:1234",
+		Env:   []corev1.EnvVar{{Name: "FUNC_HANDLER", Value: "main"}},
+		Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+		},
+	}}}
+	// the API server defaults fields the desired state doesn't set
+	persisted := desired.DeepCopy()
+	persisted.Containers[0].TerminationMessagePath = "/dev/termination-log"
+
+	tests := []struct {
+		name    string
+		change  func(*corev1.Container, *corev1.PodSpec)
+		drifted bool
+	}{
+		{name: "unchanged", change: func(*corev1.Container, *corev1.PodSpec) {}},
+		{name: "changed image", change: func(c *corev1.Container, _ *corev1.PodSpec) { c.Image = "test/default-foo:debug" }, drifted: true},
+		{name: "added env", change: func(c *corev1.Container, _ *corev1.PodSpec) {
+			c.Env = append(c.Env, corev1.EnvVar{Name: "DEBUG", Value: "true"})
+		}, drifted: true},
+		{name: "removed env", change: func(c *corev1.Container, _ *corev1.PodSpec) { c.Env = nil }, drifted: true},
+		{name: "changed resource", change: func(c *corev1.Container, _ *corev1.PodSpec) {
+			c.Resources.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")}
+		}, drifted: true},
+		{name: "added resource request", change: func(c *corev1.Container, _ *corev1.PodSpec) {
+			c.Resources.Requests = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}
+		}, drifted: true},
+		{name: "added volume", change: func(c *corev1.Container, spec *corev1.PodSpec) {
+			spec.Volumes = append(spec.Volumes, corev1.Volume{Name: "debug", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}})
+			c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: "debug", MountPath: "/debug"})
+		}, drifted: true},
+		{name: "added probe", change: func(c *corev1.Container, _ *corev1.PodSpec) {
+			c.ReadinessProbe = &corev1.Probe{Handler: corev1.Handler{HTTPGet: &corev1.HTTPGetAction{Path: "/debug"}}}
+		}, drifted: true},
+	}
+
+	for _, test := range tests {
+		pod := &corev1.Pod{Spec: *persisted.DeepCopy()}
+		g.Expect(setAppliedState(pod, pod.Spec)).To(gomega.Succeed(), test.name)
+		test.change(&pod.Spec.Containers[0], &pod.Spec)
+
+		drifted, err := hasDrifted(pod, pod.Spec)
+		g.Expect(err).NotTo(gomega.HaveOccurred(), test.name)
+		g.Expect(drifted).To(gomega.Equal(test.drifted), test.name)
+	}
+
+	// children without a recorded state haven't drifted
+	drifted, err := hasDrifted(&corev1.Pod{Spec: desired}, persisted)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(drifted).To(gomega.BeFalse())
+}
+
+func TestDesiredStateHash(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	hash, err := desiredStateHash(map[string]string{"handler.js": "main() {}"})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	same, err := desiredStateHash(map[string]string{"handler.js": "main() {}"})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	changed, err := desiredStateHash(map[string]string{"handler.js": "main() {return 1}"})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(hash).To(gomega.Equal(same))
+	g.Expect(hash).NotTo(gomega.Equal(changed))
+}
+
+func TestReconcileDrift(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	depKey := types.NamespacedName{Name: "test-drift", Namespace: "default"}
+
+	fnConfig := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "fn-config",
+			Namespace: "default",
+		},
+		Data: map[string]string{
+			"dockerRegistry":     "test",
+			"serviceAccountName": "build-bot",
+			"runtimes": `[
+				{
+					"ID": "nodejs8",
+					"DockerFileName": "dockerfile-nodejs8",
+				}
+			]`,
+		},
+	}
+	fnCreated := &runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      depKey.Name,
+			Namespace: depKey.Namespace,
+		},
+		Spec: runtimev1alpha1.FunctionSpec{
+			Function:            "main() {asdfasdf}",
+			FunctionContentType: "plaintext",
+			Size:                "L",
+			Runtime:             "nodejs8",
+		},
+	}
+
+	mgr, err := manager.New(cfg, manager.Options{})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	c := mgr.GetClient()
+	g.Expect(addBuildTemplate(mgr, newBuildTemplateReconciler(mgr))).NotTo(gomega.HaveOccurred())
+	recorder := record.NewFakeRecorder(100)
+	reconcileFunction := newReconciler(mgr).(*ReconcileFunction)
+	reconcileFunction.recorder = recorder
+
+	stopMgr, mgrStopped := StartTestManager(mgr, g)
+	defer func() {
+		close(stopMgr)
+		mgrStopped.Wait()
+	}()
+
+	g.Expect(c.Create(context.TODO(), fnConfig)).NotTo(gomega.HaveOccurred())
+	g.Expect(c.Create(context.TODO(), fnCreated)).NotTo(gomega.HaveOccurred())
+	defer func() {
+		_ = c.Delete(context.TODO(), fnCreated)
+		_ = c.Delete(context.TODO(), fnConfig)
+		_ = c.Delete(context.TODO(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: depKey.Name, Namespace: depKey.Namespace}})
+		_ = c.Delete(context.TODO(), &servingv1alpha1.Service{ObjectMeta: metav1.ObjectMeta{Name: depKey.Name, Namespace: depKey.Namespace}})
+	}()
+
+	reconcileService := func() *servingv1alpha1.Service {
+		succeedBuilds(c, fnCreated)
+		reconcileFunction.Reconcile(reconcile.Request{NamespacedName: depKey})
+		service := &servingv1alpha1.Service{}
+		if err := c.Get(context.TODO(), depKey, service); err != nil {
+			return nil
+		}
+		return service
+	}
+	env := func(service *servingv1alpha1.Service) []corev1.EnvVar {
+		if service == nil {
+			return nil
+		}
+		return service.Spec.ConfigurationSpec.Template.Spec.RevisionSpec.PodSpec.Containers[0].Env
+	}
+	driftEvents := func() int {
+		count := 0
+		for {
+			select {
+			case event := <-recorder.Events:
+				if strings.Contains(event, driftCorrectedReason) {
+					count++
+				}
+			default:
+				return count
+			}
+		}
+	}
+
+	// the children are created with the hash of their desired state
+	g.Eventually(reconcileService, timeout).ShouldNot(gomega.BeNil())
+	service := reconcileService()
+	g.Expect(service.Annotations).To(gomega.HaveKey(desiredStateAnnotation))
+	desiredEnv := env(service)
+
+	// env added to the Knative Service by hand is removed again
+	containers := service.Spec.ConfigurationSpec.Template.Spec.RevisionSpec.PodSpec.Containers
+	containers[0].Env = append(containers[0].Env, corev1.EnvVar{Name: "DEBUG", Value: "true"})
+	g.Expect(c.Update(context.TODO(), service)).NotTo(gomega.HaveOccurred())
+	g.Eventually(func() []corev1.EnvVar {
+		c.Get(context.TODO(), depKey, service)
+		return env(service)
+	}, timeout).Should(gomega.HaveLen(len(desiredEnv) + 1))
+	g.Eventually(func() []corev1.EnvVar {
+		return env(reconcileService())
+	}, timeout).Should(gomega.Equal(desiredEnv))
+	g.Expect(driftEvents()).To(gomega.BeNumerically(">=", 1))
+
+	// sources edited by hand are restored
+	functionConfigMap := &corev1.ConfigMap{}
+	g.Expect(c.Get(context.TODO(), depKey, functionConfigMap)).NotTo(gomega.HaveOccurred())
+	g.Expect(functionConfigMap.Annotations).To(gomega.HaveKey(desiredStateAnnotation))
+	functionConfigMap.Data["handler.js"] = "main() {debug}"
+	g.Expect(c.Update(context.TODO(), functionConfigMap)).NotTo(gomega.HaveOccurred())
+	g.Eventually(func() string {
+		c.Get(context.TODO(), depKey, functionConfigMap)
+		return functionConfigMap.Data["handler.js"]
+	}, timeout).Should(gomega.Equal("main() {debug}"))
+	g.Eventually(func() string {
+		reconcileFunction.Reconcile(reconcile.Request{NamespacedName: depKey})
+		c.Get(context.TODO(), depKey, functionConfigMap)
+		return functionConfigMap.Data["handler.js"]
+	}, timeout).Should(gomega.Equal(fnCreated.Spec.Function))
+	g.Expect(driftEvents()).To(gomega.BeNumerically(">=", 1))
+
+	// unmanaged children keep the changes made by hand
+	service = reconcileService()
+	service.Annotations[unmanagedAnnotation] = "true"
+	containers = service.Spec.ConfigurationSpec.Template.Spec.RevisionSpec.PodSpec.Containers
+	containers[0].Env = append(containers[0].Env, corev1.EnvVar{Name: "DEBUG", Value: "true"})
+	g.Expect(c.Update(context.TODO(), service)).NotTo(gomega.HaveOccurred())
+	g.Eventually(func() []corev1.EnvVar {
+		c.Get(context.TODO(), depKey, service)
+		return env(service)
+	}, timeout).Should(gomega.HaveLen(len(desiredEnv) + 1))
+	g.Consistently(func() []corev1.EnvVar {
+		return env(reconcileService())
+	}).Should(gomega.HaveLen(len(desiredEnv) + 1))
+	g.Expect(driftEvents()).To(gomega.BeZero())
+}
@@ -19,13 +19,16 @@ package function
 import (
 	"context"
 	"fmt"
-	"os"
+	"net/http"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 
 	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
 
 	buildv1alpha1 "github.com/knative/build/pkg/apis/build/v1alpha1"
+	istiov1alpha3 "github.com/knative/pkg/apis/istio/v1alpha3"
 	servingv1alpha1 "github.com/knative/serving/pkg/apis/serving/v1alpha1"
 	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
@@ -33,6 +36,8 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -42,8 +47,7 @@ import (
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
-	"crypto/sha256"
-
+	"github.com/kyma-incubator/runtime/pkg/metrics"
 	runtimeUtil "github.com/kyma-incubator/runtime/pkg/utils"
 )
 
@@ -52,12 +56,22 @@ var log = logf.Log.WithName("function_controller")
 // Add creates a new Function Controller and adds it to the Manager with default RBAC. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
 func Add(mgr manager.Manager) error {
+	if err := addBuildTemplate(mgr, newBuildTemplateReconciler(mgr)); err != nil {
+		return err
+	}
 	return add(mgr, newReconciler(mgr))
 }
 
 // newReconciler returns a new reconcile.Reconciler
 func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcileFunction{Client: mgr.GetClient(), scheme: mgr.GetScheme()}
+	return &ReconcileFunction{
+		Client:     mgr.GetClient(),
+		scheme:     mgr.GetScheme(),
+		recorder:   mgr.GetRecorder("function-controller"),
+		podLogs:    newPodLogs(mgr.GetConfig()),
+		buildQueue: newBuildQueue(),
+		registry:   &runtimeUtil.RegistryClient{Client: &http.Client{Timeout: registryTimeout}},
+	}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
@@ -75,71 +89,119 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 	}
 
 	// Watch for changes to Service
-	err = c.Watch(&source.Kind{Type: &servingv1alpha1.Service{}}, &handler.EnqueueRequestForObject{})
+	err = c.Watch(&source.Kind{Type: &servingv1alpha1.Service{}}, &handler.EnqueueRequestForOwner{
+		OwnerType:    &runtimev1alpha1.Function{},
+		IsController: true,
+	})
 	if err != nil {
 		return err
 	}
 
-	// Watch for changes to Build
+	// Watch for changes to the ConfigMap holding the sources of a Function
+	err = c.Watch(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestForOwner{
+		OwnerType:    &runtimev1alpha1.Function{},
+		IsController: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to Build. The ClusterBuildTemplates used by the Builds aren't owned by any Function, they are
+	// restored by the build template controller and Functions wait for them.
 	err = c.Watch(&source.Kind{Type: &buildv1alpha1.Build{}}, &handler.EnqueueRequestForOwner{
 		OwnerType:    &runtimev1alpha1.Function{},
 		IsController: true,
 	})
+	if err != nil {
+		return err
+	}
 
-	// TODO(user): Modify this to be the types you create
-	// Uncomment watch a Deployment created by Function - change this for objects you create
-	// err = c.Watch(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestForOwner{
-	// 	IsController: true,
-	// 	OwnerType:    &runtimev1alpha1.Function{},
-	// })
-	// if err != nil {
-	// 	return err
-	// }
+	// Watch for changes to the Function controller's configuration
+	err = c.Watch(&source.Kind{Type: &corev1.ConfigMap{}}, fnConfigHandler(mgr.GetClient(), mgr.GetRecorder("function-controller"), fnConfigs))
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to ServiceAccounts of functions with image pull secrets
+	err = c.Watch(&source.Kind{Type: &corev1.ServiceAccount{}}, &handler.EnqueueRequestForOwner{
+		OwnerType:    &runtimev1alpha1.Function{},
+		IsController: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to VirtualServices of function routes
+	err = c.Watch(&source.Kind{Type: &istiov1alpha3.VirtualService{}}, &handler.EnqueueRequestForOwner{
+		OwnerType:    &runtimev1alpha1.Function{},
+		IsController: true,
+	})
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
 
 var (
 	// name of function config
-	fnConfigName = getEnvDefault("CONTROLLER_CONFIGMAP", "fn-config")
+	fnConfigName = runtimeUtil.ControllerConfigMapKey().Name
 
 	// namespace of function config
-	fnConfigNamespace = getEnvDefault("CONTROLLER_CONFIGMAP_NS", "default")
+	fnConfigNamespace = runtimeUtil.ControllerConfigMapKey().Namespace
 
 	// name of build-template
-	buildTemplateName                      = getEnvDefault("BUILD_TEMPLATE", "function-kaniko")
+	buildTemplateName                      = runtimeUtil.DefaultBuildTemplateName
 	_                 reconcile.Reconciler = &ReconcileFunction{}
 
 	// "build and push step"
-	buildAndPushStep = "build-step-build-and-push"
+	buildAndPushStep = runtimeUtil.BuildAndPushStep
+
+	// build step named in the message of a failed Build
+	buildStepMessage = regexp.MustCompile(`build step "([^"]+)"`)
+
+	// messages logged by kaniko when it reuses a cached layer
+	buildCacheHitMessages = []string{"Using caching version of cmd", "Found cached layer"}
+
+	// errBuildQueued is returned when the build of a function has to wait for other builds to finish
+	errBuildQueued = fmt.Errorf("build queued")
+)
+
+// reasons of the events recorded for Functions
+const (
+	buildStartedReason   = "BuildStarted"
+	buildFailedReason    = "BuildFailed"
+	serviceCreatedReason = "ServiceCreated"
+	revisionReadyReason  = "RevisionReady"
+	configInvalidReason  = "ConfigInvalid"
 )
 
 // ReconcileFunction is the controller.Reconciler implementation for Function objects
 // ReconcileFunction reconciles a Function object
 type ReconcileFunction struct {
 	client.Client
-	scheme *runtime.Scheme
-}
-
-func getEnvDefault(envName string, defaultValue string) string {
-	// use default value if environment variable is empty
-	var value string
-	if value = os.Getenv(envName); value == "" {
-		return defaultValue
-	}
-	return value
+	scheme     *runtime.Scheme
+	recorder   record.EventRecorder
+	podLogs    podLogsFunc
+	buildQueue *buildQueue
+	registry   *runtimeUtil.RegistryClient
 }
 
 // Reconcile reads that state of the cluster for a Function object and makes changes based on the state read
-// and what is in the Function.Spec
+// and what is in the Function.Spec. The changes are made in the functionPhases, the status of the Function is computed
+// along the way and persisted once at the end.
 // +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=pods/log,verbs=get
 // +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 // +kubebuilder:rbac:groups="runtime.kyma-project.io",resources=functions,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="runtime.kyma-project.io",resources=functions/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups="admissionregistration.k8s.io",resources=mutatingwebhookconfigurations;validatingwebhookconfigurations,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="serving.knative.dev",resources=services;routes;configurations;revisions,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="build.knative.dev",resources=builds;buildtemplates;clusterbuildtemplates;services,verbs=get;list;create;update;delete;patch;watch
-// +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;watch;update;list
+// +kubebuilder:rbac:groups="networking.istio.io",resources=virtualservices,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=";apps;extensions",resources=deployments,verbs=create;get;watch;update;delete;list;update;patch
 func (r *ReconcileFunction) Reconcile(request reconcile.Request) (reconcile.Result, error) {
 
@@ -148,90 +210,82 @@ func (r *ReconcileFunction) Reconcile(request reconcile.Request) (reconcile.Resu
 	err := r.getFunctionInstance(request, fn)
 	if err != nil {
 		if errors.IsNotFound(err) {
+			if r.buildQueue != nil {
+				r.buildQueue.remove(request.NamespacedName)
+			}
+			metrics.FunctionDeleted(request.NamespacedName)
 			return reconcile.Result{}, nil
 		}
-		// status of the functon must change to error.
-		r.updateFunctionStatus(fn, runtimev1alpha1.FunctionConditionError)
 
 		log.Error(err, "Error reading Function instance", "namespace", request.Namespace, "name", request.Name)
 		return reconcile.Result{}, err
 	}
 
-	// Get Function Controller Configuration
-	fnConfig := &corev1.ConfigMap{}
-	if err := r.getFunctionControllerConfiguration(fnConfig); err != nil {
-		return reconcile.Result{}, err
+	// Deleted Functions only clean up their images, their children are deleted by the garbage collector
+	if fn.DeletionTimestamp != nil {
+		return r.finalizeFunction(fn)
 	}
 
-	// Get a new *RuntimeInfo
-	rnInfo, err := runtimeUtil.New(fnConfig)
-	if err != nil {
-		log.Error(err, "Error while trying to get a new RuntimeInfo instance", "namespace", fnConfig.Namespace, "name", fnConfig.Name)
-		return reconcile.Result{}, err
+	observed := fn.Status.DeepCopy()
+	if fn.Status.Condition == "" {
+		// new functions are Unknown until a phase sets their condition
+		fn.Status.Condition = runtimev1alpha1.FunctionConditionUnknown
 	}
 
-	// Create Function's ConfigMap
-	foundCm := &corev1.ConfigMap{}
-	deployCm := &corev1.ConfigMap{}
-	_, err = r.createFunctionConfigMap(foundCm, deployCm, fn)
-	if err != nil {
-		if errors.IsNotFound(err) {
-			return reconcile.Result{}, nil
+	result, err := r.reconcileFunction(fn)
+	if statusErr := r.applyFunctionStatus(fn, observed); statusErr != nil {
+		log.Error(statusErr, "Error while trying to update the function Status", "namespace", fn.Namespace, "name", fn.Name)
+		if err == nil {
+			return reconcile.Result{}, statusErr
 		}
-		r.updateFunctionStatus(fn, runtimev1alpha1.FunctionConditionError)
-
-		log.Error(err, "function configmap can't be created. The function could have been deleted.", "namespace", deployCm.Namespace, "name", deployCm.Name)
-		return reconcile.Result{}, err
 	}
 
-	// Update Function's ConfigMap
-	if err := r.updateFunctionConfigMap(foundCm, deployCm); err != nil {
-		// status of the functon must change to error.
-		r.updateFunctionStatus(fn, runtimev1alpha1.FunctionConditionError)
+	return result, err
+}
 
-		log.Error(err, "Error while trying to update Function's ConfigMap:", "namespace", deployCm.Namespace, "name", deployCm.Name)
+// reconcileFunction reconciles a Function with the configuration of its namespace. The status of the Function is
+// only computed, Reconcile persists it.
+func (r *ReconcileFunction) reconcileFunction(fn *runtimev1alpha1.Function) (reconcile.Result, error) {
+	// Get Function Controller Configuration
+	fnConfig := &corev1.ConfigMap{}
+	if err := r.getFunctionControllerConfiguration(fnConfig); err != nil {
+		if errors.IsNotFound(err) {
+			r.recorder.Eventf(fn, corev1.EventTypeWarning, configInvalidReason, "Configuration %s/%s not found", fnConfigNamespace, fnConfigName)
+		}
+		metrics.ReconcileError(metrics.StepConfig)
 		return reconcile.Result{}, err
 	}
 
-	// Create function's image name
-	hash := sha256.New()
-	hash.Write([]byte(foundCm.Data["handler.js"] + foundCm.Data["package.json"]))
-	functionSha := fmt.Sprintf("%x", hash.Sum(nil))
-	imageName := fmt.Sprintf("%s/%s-%s:%s", rnInfo.RegistryInfo, fn.Namespace, fn.Name, functionSha)
-	log.Info("function image", "namespace:", fn.Namespace, "name:", fn.Name, "imageName:", imageName)
-
-	if err := r.getFunctionBuildTemplate(fn); err != nil {
-		// status of the functon must change to error.
-		r.updateFunctionStatus(fn, runtimev1alpha1.FunctionConditionError)
-
+	// Get the configuration of the Function's namespace overriding the controller's configuration
+	nsConfig, err := r.getNamespaceConfiguration(fn.Namespace)
+	if err != nil {
+		metrics.ReconcileError(metrics.StepConfig)
 		return reconcile.Result{}, err
 	}
 
-	// Unique Build name base on function sha
-	shortSha := ""
-	if len(functionSha) > 10 {
-		shortSha = functionSha[0:10]
-	} else if len(functionSha) > 0 && len(functionSha) < 10 {
-		shortSha = functionSha
-	}
-	buildName := fmt.Sprintf("%s-%s", fn.Name, shortSha)
-	if err := r.buildFunctionImage(rnInfo, fn, imageName, buildName); err != nil {
-		// status of the functon must change to error.
-		r.updateFunctionStatus(fn, runtimev1alpha1.FunctionConditionError)
+	// Get the *RuntimeInfo, the last valid one if the configuration is invalid
+	rnInfo, err := fnConfigs.runtimeInfo(fnConfig, nsConfig)
+	if err != nil {
+		if nsConfig != nil {
+			// status of the functon must change to error.
+			fn.Status.Condition = runtimev1alpha1.FunctionConditionError
+		}
 
+		log.Error(err, "Error while trying to get a new RuntimeInfo instance", "namespace", fn.Namespace, "name", fn.Name)
+		r.recorder.Eventf(fn, corev1.EventTypeWarning, configInvalidReason, "Invalid configuration: %v", err)
+		metrics.ReconcileError(metrics.StepConfig)
 		return reconcile.Result{}, err
 	}
 
-	if err := r.serveFunction(rnInfo, foundCm, fn, imageName); err != nil {
-		// status of the functon must change to error.
-		r.updateFunctionStatus(fn, runtimev1alpha1.FunctionConditionError)
+	// Functions delete their images from the registry once they are deleted unless the images are retained
+	if err := r.updateImageCleanupFinalizer(rnInfo, fn); err != nil {
+		log.Error(err, "Error while trying to update the finalizers of the Function", "namespace", fn.Namespace, "name", fn.Name)
+		metrics.ReconcileError(metrics.StepFinalizer)
 		return reconcile.Result{}, err
 	}
+	fn.Status.Config = rnInfo.FunctionConfig(fn)
 
-	r.getFunctionCondition(fn)
-
-	return reconcile.Result{}, nil
-
+	return r.runPhases(functionPhases, &functionReconcile{fn: fn, rnInfo: rnInfo})
 }
 
 // Get Function Controller Configuration
@@ -246,6 +300,24 @@ func (r *ReconcileFunction) getFunctionControllerConfiguration(fnConfig *corev1.
 	return nil
 }
 
+// Get the configuration of a namespace, nil if the namespace has none
+func (r *ReconcileFunction) getNamespaceConfiguration(namespace string) (*corev1.ConfigMap, error) {
+	if namespace == fnConfigNamespace {
+		return nil, nil
+	}
+
+	nsConfig := &corev1.ConfigMap{}
+	err := r.Get(context.TODO(), types.NamespacedName{Name: fnConfigName, Namespace: namespace}, nsConfig)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		log.Error(err, "Unable to read the configuration of the namespace", "namespace", namespace, "name", fnConfigName)
+		return nil, err
+	}
+
+	return nsConfig, nil
+}
+
 // Get the Function instance
 func (r *ReconcileFunction) getFunctionInstance(request reconcile.Request, fn *runtimev1alpha1.Function) error {
 	// Get the Function instance
@@ -257,14 +329,6 @@ func (r *ReconcileFunction) getFunctionInstance(request reconcile.Request, fn *r
 
 	log.Info("Function instance found:", "namespace", fn.Namespace, "name", fn.Name)
 
-	if fn.Status.Condition == "" {
-		// As the instance of the new function was found. It status is updated.
-		err = r.updateFunctionStatus(fn, runtimev1alpha1.FunctionConditionUnknown)
-		if err != nil {
-			return err
-		}
-	}
-
 	return nil
 }
 
@@ -279,6 +343,9 @@ func createFunctionHandlerMap(fn *runtimev1alpha1.Function) map[string]string {
 		data["package.json"] = fn.Spec.Deps
 	}
 
+	// the lockfile is always part of the source as the build mounts it, it is empty without lockfile
+	data["package-lock.json"] = fn.Spec.DepsLock
+
 	return data
 
 }
@@ -288,19 +355,24 @@ func (r *ReconcileFunction) createFunctionConfigMap(foundCm *corev1.ConfigMap, d
 
 	// Create Function Handler
 	deployCm.Data = createFunctionHandlerMap(fn)
+	hash, err := desiredStateHash(deployCm.Data)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
 
 	// Managing a ConfigMap
 	deployCm.ObjectMeta = metav1.ObjectMeta{
-		Labels:    fn.Labels,
-		Namespace: fn.Namespace,
-		Name:      fn.Name,
+		Labels:      fn.Labels,
+		Annotations: map[string]string{desiredStateAnnotation: hash},
+		Namespace:   fn.Namespace,
+		Name:        fn.Name,
 	}
 
 	if err := controllerutil.SetControllerReference(fn, deployCm, r.scheme); err != nil {
 		return reconcile.Result{}, err
 	}
 
-	err := r.Get(context.TODO(), types.NamespacedName{Name: deployCm.Name, Namespace: deployCm.Namespace}, foundCm)
+	err = r.Get(context.TODO(), types.NamespacedName{Name: deployCm.Name, Namespace: deployCm.Namespace}, foundCm)
 	if err != nil && errors.IsNotFound(err) {
 		log.Info("Creating the Function's ConfigMap", "namespace", deployCm.Namespace, "name", deployCm.Name)
 		err = r.Create(context.TODO(), deployCm)
@@ -318,10 +390,22 @@ func (r *ReconcileFunction) createFunctionConfigMap(foundCm *corev1.ConfigMap, d
 	return reconcile.Result{}, nil
 }
 
-// Update found Function's ConfigMap
-func (r *ReconcileFunction) updateFunctionConfigMap(foundCm *corev1.ConfigMap, deployCm *corev1.ConfigMap) error {
+// Update found Function's ConfigMap. Changes made to it by hand are reverted unless it is unmanaged.
+func (r *ReconcileFunction) updateFunctionConfigMap(fn *runtimev1alpha1.Function, foundCm *corev1.ConfigMap, deployCm *corev1.ConfigMap) error {
+
+	if isUnmanaged(foundCm) {
+		log.Info("Function's ConfigMap is unmanaged, skipping the update", "namespace", foundCm.Namespace, "name", foundCm.Name)
+		return nil
+	}
+
+	hash := deployCm.Annotations[desiredStateAnnotation]
+	changed := !reflect.DeepEqual(deployCm.Data, foundCm.Data)
+	if changed || foundCm.Annotations[desiredStateAnnotation] != hash {
+		drifted := changed && foundCm.Annotations[desiredStateAnnotation] == hash
+		if drifted {
+			log.Info("Function's ConfigMap drifted from its desired state", "namespace", foundCm.Namespace, "name", foundCm.Name)
+		}
 
-	if !reflect.DeepEqual(deployCm.Data, foundCm.Data) {
 		foundCm.Data = deployCm.Data
 		foundCm.TypeMeta = deployCm.TypeMeta
 		foundCm.ObjectMeta = deployCm.ObjectMeta
@@ -330,6 +414,9 @@ func (r *ReconcileFunction) updateFunctionConfigMap(foundCm *corev1.ConfigMap, d
 		if err != nil && !errors.IsNotFound(err) {
 			return err
 		}
+		if drifted && err == nil {
+			r.recorder.Eventf(fn, corev1.EventTypeNormal, driftCorrectedReason, "Restored the desired state of ConfigMap %s", foundCm.Name)
+		}
 
 		log.Info("Updated Function'S ConfigMap", "namespace", deployCm.Namespace, "name", deployCm.Name)
 	}
@@ -344,139 +431,220 @@ func (r *ReconcileFunction) updateFunctionConfigMap(foundCm *corev1.ConfigMap, d
 
 }
 
-func (r *ReconcileFunction) getFunctionBuildTemplate(fn *runtimev1alpha1.Function) error {
+// Get the ClusterBuildTemplate used by the Builds of the Function's runtime
+func (r *ReconcileFunction) getFunctionBuildTemplate(name string) error {
 
-	buildTemplateNamespace := fn.Namespace
+	foundBuildTemplate := &buildv1alpha1.ClusterBuildTemplate{}
+	err := r.Get(context.TODO(), types.NamespacedName{Name: name}, foundBuildTemplate)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Error while trying to get Knative ClusterBuildTemplate", "name", name)
+	}
 
-	deployBuildTemplate := &buildv1alpha1.BuildTemplate{
-		TypeMeta: metav1.TypeMeta{
-			APIVersion: "build.knative.dev/v1alpha1",
-			Kind:       "BuildTemplate",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      buildTemplateName,
-			Namespace: buildTemplateNamespace,
-		},
-		Spec: runtimeUtil.GetBuildTemplateSpec(fn),
+	return err
+}
+
+func (r *ReconcileFunction) buildFunctionImage(rnInfo *runtimeUtil.RuntimeInfo, fn *runtimev1alpha1.Function, imageName string, buildName string) error {
+
+	// Create a new Build data structure
+	deployBuild := runtimeUtil.GetBuildResource(rnInfo, fn, imageName, buildName)
+	if retries := buildRetries(fn, buildName); retries > 0 {
+		deployBuild.Annotations = map[string]string{buildRetryAnnotation: strconv.Itoa(int(retries))}
 	}
 
-	if err := controllerutil.SetControllerReference(fn, deployBuildTemplate, r.scheme); err != nil {
+	if err := controllerutil.SetControllerReference(fn, deployBuild, r.scheme); err != nil {
 		return err
 	}
 
-	// Check if the BuildTemplate object already exists, if not create a new one.
-	foundBuildTemplate := &buildv1alpha1.BuildTemplate{}
-	err := r.Get(context.TODO(), types.NamespacedName{Name: deployBuildTemplate.Name, Namespace: deployBuildTemplate.Namespace}, foundBuildTemplate)
+	// Check if the build object (building the function) already exists, if not create a new one.
+	foundBuild := &buildv1alpha1.Build{}
+	err := r.Get(context.TODO(), types.NamespacedName{Name: deployBuild.Name, Namespace: deployBuild.Namespace}, foundBuild)
 	if err != nil && errors.IsNotFound(err) {
-		log.Info("Creating Knative BuildTemplate", "namespace", deployBuildTemplate.Namespace, "name", deployBuildTemplate.Name)
-		err = r.Create(context.TODO(), deployBuildTemplate)
-		if err != nil {
-			log.Error(err, "Error while trying to Create Knative BuildTemplate", "namespace", deployBuildTemplate.Namespace, "name", deployBuildTemplate.Name)
+		if err := r.queueBuild(rnInfo, fn, deployBuild); err != nil {
 			return err
-
 		}
 
-		err = r.updateFunctionStatus(fn, runtimev1alpha1.FunctionConditionBuilding)
+		log.Info("Creating Knative Build", "namespace", deployBuild.Namespace, "name", deployBuild.Name)
+		err = r.Create(context.TODO(), deployBuild)
 		if err != nil {
-			if errors.IsNotFound(err) {
-				return nil
-			}
 			return err
 		}
+		r.recorder.Eventf(fn, corev1.EventTypeNormal, buildStartedReason, "Started Build %s of image %s", deployBuild.Name, imageName)
 
+		fn.Status.Condition = runtimev1alpha1.FunctionConditionBuilding
 		return nil
 
 	} else if err != nil {
-		log.Error(err, "Error while trying to get Knative BuildTemplate", "namespace", deployBuildTemplate.Namespace, "name", deployBuildTemplate.Name)
+		log.Error(err, "Error while trying to create Knative Build", "namespace", deployBuild.Namespace, "name", deployBuild.Name)
 		return err
 	}
 
-	if !reflect.DeepEqual(deployBuildTemplate.Spec, foundBuildTemplate.Spec) {
+	// Update Build object
+	if !reflect.DeepEqual(deployBuild.Spec, foundBuild.Spec) && !compareBuildImages(foundBuild, imageName) {
+		fn.Status.Condition = runtimev1alpha1.FunctionConditionUpdating
 
-		foundBuildTemplate.Spec = deployBuildTemplate.Spec
-		log.Info("Updating Knative BuildTemplate", "namespace", deployBuildTemplate.Namespace, "name", deployBuildTemplate.Name)
-		err = r.Update(context.TODO(), foundBuildTemplate)
-		if err != nil {
-			if errors.IsNotFound(err) {
-				return nil
-			}
-			log.Error(err, "Error while trying to Update Knative BuildTemplate", "namespace", deployBuildTemplate.Namespace, "name", deployBuildTemplate.Name)
+		// the stale Build holds the name of the new one, the new one is created once it's gone
+		log.Info("Deleting stale Knative Build", "namespace", foundBuild.Namespace, "name", foundBuild.Name)
+		if err := r.Delete(context.TODO(), foundBuild); ignoreNotFound(err) != nil {
+			log.Error(err, "Error while trying to delete the stale Knative Build", "namespace", foundBuild.Namespace, "name", foundBuild.Name)
 			return err
 		}
 
-		err = r.updateFunctionStatus(fn, runtimev1alpha1.FunctionConditionBuilding)
-		if err != nil {
-			if errors.IsNotFound(err) {
-				return nil
-			}
-			return err
-		}
+		return nil
+	}
 
+	// the build of the function exists, it doesn't wait in the queue anymore
+	if r.buildQueue != nil {
+		r.buildQueue.remove(types.NamespacedName{Name: fn.Name, Namespace: fn.Namespace})
 	}
 
 	return nil
 }
 
-func (r *ReconcileFunction) buildFunctionImage(rnInfo *runtimeUtil.RuntimeInfo, fn *runtimev1alpha1.Function, imageName string, buildName string) error {
+// queueBuild checks whether the build of a function can start without exceeding the limits of concurrent builds.
+// Otherwise the function keeps its position in the build queue and errBuildQueued is returned.
+func (r *ReconcileFunction) queueBuild(rnInfo *runtimeUtil.RuntimeInfo, fn *runtimev1alpha1.Function, deployBuild *buildv1alpha1.Build) error {
 
-	// Create a new Build data structure
-	deployBuild := runtimeUtil.GetBuildResource(rnInfo, fn, imageName, buildName)
+	if r.buildQueue == nil || (rnInfo.MaxConcurrentBuilds <= 0 && rnInfo.MaxConcurrentBuildsPerNamespace <= 0) {
+		return nil
+	}
 
-	if err := controllerutil.SetControllerReference(fn, deployBuild, r.scheme); err != nil {
+	// builds of functions which didn't finish yet
+	builds := &buildv1alpha1.BuildList{}
+	if err := r.List(context.TODO(), &client.ListOptions{}, builds); err != nil {
+		log.Error(err, "Error while trying to list Knative Builds")
 		return err
 	}
+	running := []types.NamespacedName{}
+	for i := range builds.Items {
+		build := &builds.Items[i]
+		if !isOwnedByFunction(build) || isBuildFinished(build) {
+			continue
+		}
+		running = append(running, types.NamespacedName{Name: build.Name, Namespace: build.Namespace})
+	}
+
+	// functions keep the time they got queued, so they keep their position when the controller restarts
+	queuedTime := metav1.Now()
+	if fn.Status.Build != nil && fn.Status.Build.Name == deployBuild.Name && fn.Status.Build.QueuedTime != nil {
+		queuedTime = *fn.Status.Build.QueuedTime
+	}
+
+	fnKey := types.NamespacedName{Name: fn.Name, Namespace: fn.Namespace}
+	buildKey := types.NamespacedName{Name: deployBuild.Name, Namespace: deployBuild.Namespace}
+	admitted, position := r.buildQueue.admit(fnKey, queuedTime.Time, buildKey, running, rnInfo.MaxConcurrentBuilds, rnInfo.MaxConcurrentBuildsPerNamespace)
+	if admitted {
+		return nil
+	}
+
+	log.Info("Queueing Knative Build", "namespace", deployBuild.Namespace, "name", deployBuild.Name, "position", position)
+	fn.Status.Build = &runtimev1alpha1.FunctionBuildStatus{
+		Name:          deployBuild.Name,
+		QueuePosition: int32(position),
+		QueuedTime:    &queuedTime,
+		Retries:       buildRetries(fn, deployBuild.Name),
+	}
+	fn.Status.Condition = runtimev1alpha1.FunctionConditionBuildQueued
+
+	return errBuildQueued
+}
+
+// isBuildFinished checks whether a Build succeeded or failed
+func isBuildFinished(build *buildv1alpha1.Build) bool {
+	for _, condition := range build.Status.Conditions {
+		if condition.Type == duckv1alpha1.ConditionSucceeded && condition.Status != corev1.ConditionUnknown {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the status of the latest Build of the function. It is persisted with the function condition.
+func (r *ReconcileFunction) getBuildStatus(fn *runtimev1alpha1.Function, buildName string) {
+
+	buildStatus := &runtimev1alpha1.FunctionBuildStatus{Name: buildName, Retries: buildRetries(fn, buildName)}
+	if fn.Status.Build != nil && fn.Status.Build.Name == buildName && (fn.Status.Build.Cache != "" || fn.Status.Build.FailedStep != "") {
+		// the cache usage and the failure of a build don't change once known
+		return
+	}
+	fn.Status.Build = buildStatus
 
-	// Check if the build object (building the function) already exists, if not create a new one.
 	foundBuild := &buildv1alpha1.Build{}
-	err := r.Get(context.TODO(), types.NamespacedName{Name: deployBuild.Name, Namespace: deployBuild.Namespace}, foundBuild)
-	if err != nil && errors.IsNotFound(err) {
-		log.Info("Creating Knative Build", "namespace", deployBuild.Namespace, "name", deployBuild.Name)
-		err = r.Create(context.TODO(), deployBuild)
-		if err != nil {
-			return err
+	if err := r.Get(context.TODO(), types.NamespacedName{Name: buildName, Namespace: fn.Namespace}, foundBuild); err != nil {
+		if !errors.IsNotFound(err) {
+			log.Error(err, "Error while trying to get the Knative Build for the Function Status", "namespace", fn.Namespace, "name", buildName)
 		}
+		return
+	}
+	metrics.ObserveBuild(fn, foundBuild)
 
-		err = r.updateFunctionStatus(fn, runtimev1alpha1.FunctionConditionBuilding)
-		if err != nil {
-			if errors.IsNotFound(err) {
-				return nil
+	if failed, _ := buildFailure(foundBuild); failed {
+		r.getBuildFailure(buildStatus, foundBuild)
+		r.recorder.Eventf(fn, corev1.EventTypeWarning, buildFailedReason, "Build %s failed at step %s: %s", buildName, buildStatus.FailedStep, buildStatus.Message)
+		return
+	}
+
+	if foundBuild.Spec.Template != nil {
+		for _, arg := range foundBuild.Spec.Template.Arguments {
+			if arg.Name == "CACHE" && arg.Value == "false" {
+				buildStatus.Cache = runtimev1alpha1.FunctionBuildCacheDisabled
+				return
 			}
-			return err
 		}
-		return nil
+	}
 
-	} else if err != nil {
-		log.Error(err, "Error while trying to create Knative Build", "namespace", deployBuild.Namespace, "name", deployBuild.Name)
-		return err
+	// kaniko only reports the use of the cache in its logs, they are read once the build succeeded
+	if !buildSucceeded(foundBuild) || foundBuild.Status.Cluster == nil || foundBuild.Status.Cluster.PodName == "" || r.podLogs == nil {
+		return
 	}
 
-	// Update Build object
-	if !reflect.DeepEqual(deployBuild.Spec, foundBuild.Spec) && !compareBuildImages(foundBuild, imageName) {
+	logs, err := r.podLogs(fn.Namespace, foundBuild.Status.Cluster.PodName, buildAndPushStep)
+	if err != nil {
+		log.Error(err, "Error while trying to read the logs of the Knative Build", "namespace", fn.Namespace, "name", buildName)
+		return
+	}
 
-		err := r.updateFunctionStatus(fn, runtimev1alpha1.FunctionConditionUpdating)
-		if err != nil {
-			if errors.IsNotFound(err) {
-				return nil
-			}
-			return err
+	buildStatus.Cache = runtimev1alpha1.FunctionBuildCacheMiss
+	for _, message := range buildCacheHitMessages {
+		if strings.Contains(string(logs), message) {
+			buildStatus.Cache = runtimev1alpha1.FunctionBuildCacheHit
+			break
 		}
+	}
+}
 
-		// create new Build with the new updated image
-		log.Info("Creating new Knative Build", "namespace", deployBuild.Namespace, "name", deployBuild.Name)
-		err = r.Create(context.TODO(), deployBuild)
-		if err != nil {
-			if errors.IsNotFound(err) {
-				return nil
-			}
-			return err
+// Get the step a failed Build stopped at, its termination message and the tail of its logs
+func (r *ReconcileFunction) getBuildFailure(buildStatus *runtimev1alpha1.FunctionBuildStatus, foundBuild *buildv1alpha1.Build) {
+
+	buildStatus.FailedStep = buildAndPushStep
+	for _, condition := range foundBuild.Status.Conditions {
+		if condition.Type != duckv1alpha1.ConditionSucceeded || condition.Status != corev1.ConditionFalse {
+			continue
+		}
+		buildStatus.Message = condition.Message
+		if match := buildStepMessage.FindStringSubmatch(condition.Message); match != nil {
+			buildStatus.FailedStep = match[1]
 		}
+	}
 
-		log.Info("Updated Knative Build", "namespace", deployBuild.Namespace, "name", deployBuild.Name)
+	// the termination message of the step tells more than the message of the Build
+	for _, state := range foundBuild.Status.StepStates {
+		if state.Terminated != nil && state.Terminated.ExitCode != 0 && state.Terminated.Message != "" {
+			buildStatus.Message = state.Terminated.Message
+			break
+		}
+	}
+	buildStatus.Message = tailLogs(buildStatus.Message)
 
-		return nil
+	if foundBuild.Status.Cluster == nil || foundBuild.Status.Cluster.PodName == "" || r.podLogs == nil {
+		return
 	}
 
-	return nil
+	logs, err := r.podLogs(foundBuild.Namespace, foundBuild.Status.Cluster.PodName, buildStatus.FailedStep)
+	if err != nil {
+		log.Error(err, "Error while trying to read the logs of the failed Knative Build", "namespace", foundBuild.Namespace, "name", foundBuild.Name)
+		return
+	}
+	buildStatus.Logs = tailLogs(string(logs))
 }
 
 func compareBuildImages(foundBuild *buildv1alpha1.Build, imageName string) bool {
@@ -502,6 +670,11 @@ func (r *ReconcileFunction) serveFunction(rnInfo *runtimeUtil.RuntimeInfo, found
 		},
 		Spec: runtimeUtil.GetServiceSpec(imageName, *fn, rnInfo),
 	}
+	hash, err := desiredStateHash(deployService.Spec)
+	if err != nil {
+		return err
+	}
+	setDesiredState(deployService, hash)
 
 	if err := controllerutil.SetControllerReference(fn, deployService, r.scheme); err != nil {
 		return err
@@ -509,32 +682,41 @@ func (r *ReconcileFunction) serveFunction(rnInfo *runtimeUtil.RuntimeInfo, found
 
 	// Check if the Serving object (serving the function) already exists, if not create a new one.
 	foundService := &servingv1alpha1.Service{}
-	err := r.Get(context.TODO(), types.NamespacedName{Name: deployService.Name, Namespace: deployService.Namespace}, foundService)
+	err = r.Get(context.TODO(), types.NamespacedName{Name: deployService.Name, Namespace: deployService.Namespace}, foundService)
 	if err != nil && errors.IsNotFound(err) {
 		log.Info("Creating Knative Service", "namespace", deployService.Namespace, "name", deployService.Name)
 		err = r.Create(context.TODO(), deployService)
 		if err != nil {
 			return err
 		}
+		r.recorder.Eventf(fn, corev1.EventTypeNormal, serviceCreatedReason, "Created Knative Service %s", deployService.Name)
 
-		err = r.updateFunctionStatus(fn, runtimev1alpha1.FunctionConditionDeploying)
-		if err != nil {
-			if errors.IsNotFound(err) {
-				return nil
-			}
-			return err
-		}
-
-		return nil
+		fn.Status.Condition = runtimev1alpha1.FunctionConditionDeploying
+		return r.recordServiceState(deployService)
 	} else if err != nil {
 		log.Error(err, "Error while trying to create Knative Service", "namespace", deployService.Namespace, "name", deployService.Name)
 		return err
 	}
 
-	if !reflect.DeepEqual(deployService.Spec, foundService.Spec) && !compareServiceImage(foundService, imageName, fn) {
+	if isUnmanaged(foundService) {
+		log.Info("Knative Service is unmanaged, skipping the update", "namespace", foundService.Namespace, "name", foundService.Name)
+		return nil
+	}
+
+	// the persisted state differs from the one recorded when it got applied once it was changed by hand
+	drifted, err := hasDrifted(foundService, foundService.Spec)
+	if err != nil {
+		return err
+	}
+	if drifted || foundService.Annotations[desiredStateAnnotation] != hash {
+		corrected := drifted && foundService.Annotations[desiredStateAnnotation] == hash
+		if corrected {
+			log.Info("Knative Service drifted from its desired state", "namespace", foundService.Namespace, "name", foundService.Name)
+		}
 
 		foundService.Spec = deployService.Spec
 		foundService.Status = deployService.Status
+		setDesiredState(foundService, hash)
 
 		log.Info("Updating Knative Service", "namespace", deployService.Namespace, "name", deployService.Name)
 		err = r.Update(context.TODO(), foundService)
@@ -543,68 +725,305 @@ func (r *ReconcileFunction) serveFunction(rnInfo *runtimeUtil.RuntimeInfo, found
 		}
 
 		log.Info("Updated Knative Service", "namespace", deployService.Namespace, "name", deployService.Name)
+		if corrected {
+			r.recorder.Eventf(fn, corev1.EventTypeNormal, driftCorrectedReason, "Restored the desired state of Knative Service %s", foundService.Name)
+		}
 
-		err = r.updateFunctionStatus(fn, runtimev1alpha1.FunctionConditionDeploying)
+		fn.Status.Condition = runtimev1alpha1.FunctionConditionDeploying
+		return r.recordServiceState(foundService)
+	}
+
+	if _, ok := foundService.Annotations[appliedStateAnnotation]; !ok {
+		return r.recordServiceState(foundService)
+	}
+	return nil
+}
+
+// recordServiceState records the state of a Knative Service as persisted with the desired state, including the fields
+// defaulted by Knative
+func (r *ReconcileFunction) recordServiceState(service *servingv1alpha1.Service) error {
+	if err := setAppliedState(service, service.Spec); err != nil {
+		return err
+	}
+	return r.Update(context.TODO(), service)
+}
+
+// Ensure the runtime service account and pull secret of a namespace. They are shared by all functions of the namespace and not owned by any of them.
+// The pull secret is generated from the configured registry credentials, so functions never hold the push credentials of builds.
+func (r *ReconcileFunction) runtimeServiceAccount(rnInfo *runtimeUtil.RuntimeInfo, namespace string) error {
+
+	// a runtime service account is configured
+	if rnInfo.RuntimeServiceAccount != runtimeUtil.DefaultRuntimeServiceAccount {
+		return nil
+	}
+
+	withPullSecret := rnInfo.RegistryPullSecret != ""
+	if withPullSecret {
+		credentials := &corev1.Secret{}
+		if err := r.Get(context.TODO(), types.NamespacedName{Name: rnInfo.RegistryPullSecret, Namespace: rnInfo.RegistryPullSecretNamespace}, credentials); err != nil {
+			log.Error(err, "Unable to read the registry pull credentials", "namespace", rnInfo.RegistryPullSecretNamespace, "name", rnInfo.RegistryPullSecret)
+			return err
+		}
+
+		deploySecret, err := runtimeUtil.GetRuntimePullSecret(credentials, namespace)
 		if err != nil {
-			if errors.IsNotFound(err) {
-				return nil
+			return err
+		}
+
+		foundSecret := &corev1.Secret{}
+		err = r.Get(context.TODO(), types.NamespacedName{Name: deploySecret.Name, Namespace: deploySecret.Namespace}, foundSecret)
+		if err != nil && errors.IsNotFound(err) {
+			log.Info("Creating runtime pull Secret", "namespace", deploySecret.Namespace, "name", deploySecret.Name)
+			if err := r.Create(context.TODO(), deploySecret); err != nil {
+				return err
 			}
+		} else if err != nil {
 			return err
+		} else if !reflect.DeepEqual(deploySecret.Data, foundSecret.Data) || foundSecret.Type != deploySecret.Type {
+			foundSecret.Data = deploySecret.Data
+			foundSecret.Type = deploySecret.Type
+			log.Info("Updating runtime pull Secret", "namespace", deploySecret.Namespace, "name", deploySecret.Name)
+			if err := r.Update(context.TODO(), foundSecret); err != nil {
+				return err
+			}
 		}
+	}
+
+	deployServiceAccount := runtimeUtil.GetRuntimeServiceAccount(namespace, withPullSecret)
+	foundServiceAccount := &corev1.ServiceAccount{}
+	err := r.Get(context.TODO(), types.NamespacedName{Name: deployServiceAccount.Name, Namespace: deployServiceAccount.Namespace}, foundServiceAccount)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating runtime ServiceAccount", "namespace", deployServiceAccount.Namespace, "name", deployServiceAccount.Name)
+		return r.Create(context.TODO(), deployServiceAccount)
+	} else if err != nil {
+		return err
+	}
 
+	if !reflect.DeepEqual(deployServiceAccount.ImagePullSecrets, foundServiceAccount.ImagePullSecrets) {
+		foundServiceAccount.ImagePullSecrets = deployServiceAccount.ImagePullSecrets
+		log.Info("Updating runtime ServiceAccount", "namespace", deployServiceAccount.Namespace, "name", deployServiceAccount.Name)
+		return r.Update(context.TODO(), foundServiceAccount)
 	}
 
 	return nil
 }
 
-func compareServiceImage(foundService *servingv1alpha1.Service, imageName string, fn *runtimev1alpha1.Function) bool {
+// Manage the service account of a function with image pull secrets. It gets the image pull secrets of the function
+// and the ones of the runtime service account, so the image of the function can still be pulled from the registry.
+// The service account is deleted once the function has no image pull secrets anymore.
+func (r *ReconcileFunction) functionServiceAccount(rnInfo *runtimeUtil.RuntimeInfo, fn *runtimev1alpha1.Function) error {
 
-	if len(foundService.Spec.ConfigurationSpec.Template.Spec.RevisionSpec.PodSpec.Containers) > 0 {
-		args := foundService.Spec.ConfigurationSpec.Template.Spec.RevisionSpec.PodSpec.Containers
-		for _, arg := range args {
-			if arg.Image == imageName && strings.Contains(arg.Image, fn.Name) {
-				return true
-			}
+	deployServiceAccount := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:    fn.Labels,
+			Namespace: fn.Namespace,
+			Name:      runtimeUtil.FunctionServiceAccountName(fn),
+		},
+	}
+
+	needsServiceAccount := fn.Spec.ServiceAccountName == "" && len(fn.Spec.ImagePullSecrets) > 0
+	if needsServiceAccount {
+		runtimeServiceAccount := &corev1.ServiceAccount{}
+		err := r.Get(context.TODO(), types.NamespacedName{Name: rnInfo.RuntimeServiceAccount, Namespace: fn.Namespace}, runtimeServiceAccount)
+		if ignoreNotFound(err) != nil {
+			return err
 		}
+		deployServiceAccount.ImagePullSecrets = mergeImagePullSecrets(runtimeServiceAccount.ImagePullSecrets, fn.Spec.ImagePullSecrets)
 	}
-	return false
+
+	if err := controllerutil.SetControllerReference(fn, deployServiceAccount, r.scheme); err != nil {
+		return err
+	}
+
+	foundServiceAccount := &corev1.ServiceAccount{}
+	err := r.Get(context.TODO(), types.NamespacedName{Name: deployServiceAccount.Name, Namespace: deployServiceAccount.Namespace}, foundServiceAccount)
+	if err != nil && errors.IsNotFound(err) {
+		if !needsServiceAccount {
+			return nil
+		}
+
+		log.Info("Creating ServiceAccount", "namespace", deployServiceAccount.Namespace, "name", deployServiceAccount.Name)
+		return r.Create(context.TODO(), deployServiceAccount)
+	} else if err != nil {
+		log.Error(err, "Error while trying to get ServiceAccount", "namespace", deployServiceAccount.Namespace, "name", deployServiceAccount.Name)
+		return err
+	}
+
+	// only touch service accounts which are owned by this function
+	if !metav1.IsControlledBy(foundServiceAccount, fn) {
+		if !needsServiceAccount {
+			return nil
+		}
+		return fmt.Errorf("ServiceAccount %s/%s already exists and is not owned by the function", foundServiceAccount.Namespace, foundServiceAccount.Name)
+	}
+
+	if !needsServiceAccount {
+		log.Info("Deleting ServiceAccount", "namespace", foundServiceAccount.Namespace, "name", foundServiceAccount.Name)
+		return ignoreNotFound(r.Delete(context.TODO(), foundServiceAccount))
+	}
+
+	if !reflect.DeepEqual(deployServiceAccount.ImagePullSecrets, foundServiceAccount.ImagePullSecrets) {
+		foundServiceAccount.ImagePullSecrets = deployServiceAccount.ImagePullSecrets
+
+		log.Info("Updating ServiceAccount", "namespace", deployServiceAccount.Namespace, "name", deployServiceAccount.Name)
+		return r.Update(context.TODO(), foundServiceAccount)
+	}
+
+	return nil
+}
+
+// mergeImagePullSecrets returns all secrets of both lists without duplicates
+func mergeImagePullSecrets(secrets []corev1.LocalObjectReference, additional []corev1.LocalObjectReference) []corev1.LocalObjectReference {
+	merged := []corev1.LocalObjectReference{}
+	seen := map[string]bool{}
+	for _, secret := range append(append([]corev1.LocalObjectReference{}, secrets...), additional...) {
+		if seen[secret.Name] {
+			continue
+		}
+		seen[secret.Name] = true
+		merged = append(merged, secret)
+	}
+	return merged
+}
+
+// Expose the function on its custom routes through an istio VirtualService. The VirtualService is deleted once the function has no routes.
+func (r *ReconcileFunction) routeFunction(rnInfo *runtimeUtil.RuntimeInfo, fn *runtimev1alpha1.Function) error {
+
+	deployVirtualService := &istiov1alpha3.VirtualService{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:    fn.Labels,
+			Namespace: fn.Namespace,
+			Name:      fn.Name,
+		},
+		Spec: runtimeUtil.GetVirtualServiceSpec(fn, rnInfo),
+	}
+
+	if err := controllerutil.SetControllerReference(fn, deployVirtualService, r.scheme); err != nil {
+		return err
+	}
+
+	foundVirtualService := &istiov1alpha3.VirtualService{}
+	err := r.Get(context.TODO(), types.NamespacedName{Name: deployVirtualService.Name, Namespace: deployVirtualService.Namespace}, foundVirtualService)
+	if err != nil && errors.IsNotFound(err) {
+		if len(fn.Spec.Routes) == 0 {
+			return nil
+		}
+
+		log.Info("Creating VirtualService", "namespace", deployVirtualService.Namespace, "name", deployVirtualService.Name)
+		err = r.Create(context.TODO(), deployVirtualService)
+		if err != nil {
+			log.Error(err, "Error while trying to create VirtualService", "namespace", deployVirtualService.Namespace, "name", deployVirtualService.Name)
+			return err
+		}
+		return nil
+	} else if err != nil {
+		log.Error(err, "Error while trying to get VirtualService", "namespace", deployVirtualService.Namespace, "name", deployVirtualService.Name)
+		return err
+	}
+
+	// only remove VirtualServices which are owned by this function
+	if !metav1.IsControlledBy(foundVirtualService, fn) {
+		return fmt.Errorf("VirtualService %s/%s already exists and is not owned by the function", foundVirtualService.Namespace, foundVirtualService.Name)
+	}
+
+	if len(fn.Spec.Routes) == 0 {
+		log.Info("Deleting VirtualService", "namespace", foundVirtualService.Namespace, "name", foundVirtualService.Name)
+		return ignoreNotFound(r.Delete(context.TODO(), foundVirtualService))
+	}
+
+	if !reflect.DeepEqual(deployVirtualService.Spec, foundVirtualService.Spec) {
+		foundVirtualService.Spec = deployVirtualService.Spec
+
+		log.Info("Updating VirtualService", "namespace", deployVirtualService.Namespace, "name", deployVirtualService.Name)
+		err = r.Update(context.TODO(), foundVirtualService)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// virtualServiceReady checks whether the VirtualService of the routes of the function exists and matches them.
+// Functions without routes don't need a VirtualService.
+func (r *ReconcileFunction) virtualServiceReady(rnInfo *runtimeUtil.RuntimeInfo, fn *runtimev1alpha1.Function) (bool, error) {
+	if len(fn.Spec.Routes) == 0 {
+		return true, nil
+	}
+
+	foundVirtualService := &istiov1alpha3.VirtualService{}
+	err := r.Get(context.TODO(), types.NamespacedName{Name: fn.Name, Namespace: fn.Namespace}, foundVirtualService)
+	if errors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return metav1.IsControlledBy(foundVirtualService, fn) &&
+		reflect.DeepEqual(runtimeUtil.GetVirtualServiceSpec(fn, rnInfo), foundVirtualService.Spec), nil
+}
+
+// getRoutesStatus returns the status of each route of the function. All routes share the readiness of the Knative
+// route and of the VirtualService of the function.
+func getRoutesStatus(fn *runtimev1alpha1.Function, ready bool) []runtimev1alpha1.FunctionRouteStatus {
+	if len(fn.Spec.Routes) == 0 {
+		return nil
+	}
+
+	routes := make([]runtimev1alpha1.FunctionRouteStatus, 0, len(fn.Spec.Routes))
+	for _, route := range fn.Spec.Routes {
+		routes = append(routes, runtimev1alpha1.FunctionRouteStatus{
+			Host:  route.Host,
+			Path:  runtimeUtil.NormalizeRoutePath(route.Path),
+			Ready: ready,
+		})
+	}
+
+	return routes
 }
 
 // It defines if the function condition is running or deploying base on the status of the Knative service.
 // A function is running is if the Status of the Knative service has:
 // - the last created revision and the last ready revision are the same.
 // - the conditions service, route and configuration should have status true and type ready.
-// Update the status of the function base on the defined function condition.
+// The function condition is set accordingly, it is persisted with the phase the reconcile stopped at.
 // For a function get the status error either the creation or update of the knative service or build must have failed.
-func (r *ReconcileFunction) getFunctionCondition(fn *runtimev1alpha1.Function) {
+// The routes of the function are only ready while its VirtualService matches them too.
+func (r *ReconcileFunction) getFunctionCondition(rnInfo *runtimeUtil.RuntimeInfo, fn *runtimev1alpha1.Function) phaseResult {
 
 	serviceReady := false
 	configurationsReady := false
 	routesReady := false
 
-	// Get the status of the Build
+	// Get the status of the latest Build
+	buildName := fn.Name
+	if fn.Status.Build != nil && fn.Status.Build.Name != "" {
+		buildName = fn.Status.Build.Name
+	}
 	foundBuild := &buildv1alpha1.Build{}
-	if err := r.Get(context.TODO(), types.NamespacedName{Name: fn.Name, Namespace: fn.Namespace}, foundBuild); ignoreNotFound(err) != nil {
-		log.Error(err, "Error while trying to get the Knative Build for the Function Status", "namespace", fn.Namespace, "name", fn.Name)
-		return
+	if err := r.Get(context.TODO(), types.NamespacedName{Name: buildName, Namespace: fn.Namespace}, foundBuild); ignoreNotFound(err) != nil {
+		log.Error(err, "Error while trying to get the Knative Build for the Function Status", "namespace", fn.Namespace, "name", buildName)
+		return phaseFailedResult(err)
 	}
 
 	// if build show error, set function status to error too
 	for _, condition := range foundBuild.Status.Conditions {
 		if condition.Type == duckv1alpha1.ConditionSucceeded && condition.Status == corev1.ConditionFalse {
-			err := r.updateFunctionStatus(fn, runtimev1alpha1.FunctionConditionError)
-			if err != nil {
-				log.Error(err, "Error while trying to update the function Status", "namespace", fn.Namespace, "name", fn.Name)
-			}
-			return
+			fn.Status.Routes = getRoutesStatus(fn, false)
+			fn.Status.Condition = runtimev1alpha1.FunctionConditionError
+			return phaseFailedResult(nil)
 		}
 	}
 
 	// Get Knative Service
 	foundService := &servingv1alpha1.Service{}
 	if err := r.Get(context.TODO(), types.NamespacedName{Name: fn.Name, Namespace: fn.Namespace}, foundService); err != nil {
+		if errors.IsNotFound(err) {
+			// the Knative Service was just created
+			return phaseInProgressResult(readyPollInterval)
+		}
 		log.Error(err, "Error while trying to get the Knative Service for the function Status", "namespace", fn.Namespace, "name", fn.Name)
-		return
+		return phaseFailedResult(err)
 	}
 
 	// latest created and ready revisions share the same name.
@@ -645,24 +1064,59 @@ func (r *ReconcileFunction) getFunctionCondition(fn *runtimev1alpha1.Function) {
 		fnCondition = runtimev1alpha1.FunctionConditionRunning
 
 	}
-	err := r.updateFunctionStatus(fn, fnCondition)
+	virtualServiceReady, err := r.virtualServiceReady(rnInfo, fn)
 	if err != nil {
-		log.Error(err, "Error while trying to update the function Status", "namespace", fn.Namespace, "name", fn.Name)
-		return
+		log.Error(err, "Error while trying to get the VirtualService for the function Status", "namespace", fn.Namespace, "name", fn.Name)
+		return phaseFailedResult(err)
 	}
+	fn.Status.Routes = getRoutesStatus(fn, routesReady && virtualServiceReady)
+	wasRunning := fn.Status.Condition == runtimev1alpha1.FunctionConditionRunning
+	fn.Status.Condition = fnCondition
 
 	log.Info(fmt.Sprintf("Function status: %s", fnCondition), "namespace", fn.Namespace, "name", fn.Name)
 
+	if fnCondition != runtimev1alpha1.FunctionConditionRunning {
+		return phaseInProgressResult(readyPollInterval)
+	}
+	if !wasRunning {
+		r.recorder.Eventf(fn, corev1.EventTypeNormal, revisionReadyReason, "Revision %s is ready", foundService.Status.LatestReadyRevisionName)
+	}
+	return phaseDoneResult()
 }
 
-// Update the status of the function JSONPath: .status.condition
-func (r *ReconcileFunction) updateFunctionStatus(fn *runtimev1alpha1.Function, condition runtimev1alpha1.FunctionCondition) error {
+// applyFunctionStatus persists the status a reconcile computed for the function unless it equals the observed status.
+// On conflicts, e.g. with edits of the spec, the status is applied to the latest version of the function, so it isn't lost.
+func (r *ReconcileFunction) applyFunctionStatus(fn *runtimev1alpha1.Function, observed *runtimev1alpha1.FunctionStatus) error {
+	status := fn.Status.DeepCopy()
+	if reflect.DeepEqual(status, observed) {
+		metrics.ObserveFunction(fn)
+		return nil
+	}
 
-	fn.Status.Condition = condition
-	err := r.Status().Update(context.TODO(), fn)
-	if err != nil && !errors.IsNotFound(err) {
+	// the cache of the client may lag behind the conflicting write, the retries back off until it caught up
+	latest := fn
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		if latest == nil {
+			latest = &runtimev1alpha1.Function{}
+			if err := r.Get(context.TODO(), types.NamespacedName{Name: fn.Name, Namespace: fn.Namespace}, latest); err != nil {
+				return err
+			}
+			if reflect.DeepEqual(&latest.Status, status) {
+				return nil
+			}
+		}
+
+		latest.Status = *status
+		err := r.Status().Update(context.TODO(), latest)
+		if errors.IsConflict(err) {
+			latest = nil
+		}
 		return err
+	})
+	if err != nil {
+		return ignoreNotFound(err)
 	}
+	metrics.ObserveFunction(fn)
 
 	return nil
 }
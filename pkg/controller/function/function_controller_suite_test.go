@@ -25,6 +25,7 @@ import (
 	"testing"
 
 	buildv1alpha1 "github.com/knative/build/pkg/apis/build/v1alpha1"
+	istiov1alpha3 "github.com/knative/pkg/apis/istio/v1alpha3"
 	servingv1alpha1 "github.com/knative/serving/pkg/apis/serving/v1alpha1"
 	"github.com/kyma-incubator/runtime/pkg/apis"
 	"github.com/onsi/gomega"
@@ -40,8 +41,11 @@ var cfg *rest.Config
 
 func TestMain(m *testing.M) {
 	t := &envtest.Environment{
-		Config:            cfg,
-		CRDDirectoryPaths: []string{filepath.Join("..", "..", "..", "config", "crds")},
+		Config: cfg,
+		CRDDirectoryPaths: []string{
+			filepath.Join("..", "..", "..", "config", "crds"),
+			filepath.Join("..", "..", "..", "test", "crds"),
+		},
 	}
 
 	logf.SetLogger(logf.ZapLogger(false))
@@ -55,6 +59,10 @@ func TestMain(m *testing.M) {
 		log.Error(err, "unable add Build APIs to scheme")
 		os.Exit(1)
 	}
+	if err := istiov1alpha3.AddToScheme(scheme.Scheme); err != nil {
+		log.Error(err, "unable add Istio APIs to scheme")
+		os.Exit(1)
+	}
 
 	var err error
 	if cfg, err = t.Start(); err != nil {
@@ -19,6 +19,7 @@ package function
 import (
 	"crypto/sha256"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -29,13 +30,17 @@ import (
 	duckv1beta1 "github.com/knative/pkg/apis/duck/v1beta1"
 	servingv1alpha1 "github.com/knative/serving/pkg/apis/serving/v1alpha1"
 	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	runtimeUtil "github.com/kyma-incubator/runtime/pkg/utils"
 	"github.com/onsi/gomega"
 	"github.com/onsi/gomega/gstruct"
 	"golang.org/x/net/context"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -45,6 +50,23 @@ var c client.Client
 
 const timeout = time.Second * 20
 
+// succeedBuilds marks the Builds of a function succeeded as if their images got pushed, the Knative Service of the
+// function is only deployed then
+func succeedBuilds(c client.Client, fn *runtimev1alpha1.Function) {
+	builds := &buildv1alpha1.BuildList{}
+	if err := c.List(context.TODO(), client.InNamespace(fn.Namespace), builds); err != nil {
+		return
+	}
+	for i := range builds.Items {
+		build := &builds.Items[i]
+		if !metav1.IsControlledBy(build, fn) || buildSucceeded(build) {
+			continue
+		}
+		build.Status.Conditions = []duckv1alpha1.Condition{{Type: duckv1alpha1.ConditionSucceeded, Status: corev1.ConditionTrue}}
+		_ = c.Status().Update(context.TODO(), build)
+	}
+}
+
 func TestReconcile(t *testing.T) {
 	g := gomega.NewGomegaWithT(t)
 	var depKey = types.NamespacedName{Name: "foo", Namespace: "default"}
@@ -116,6 +138,7 @@ func TestReconcile(t *testing.T) {
 	c = mgr.GetClient()
 	recFn, requests, errors := SetupTestReconcile(newReconciler(mgr))
 	g.Expect(add(mgr, recFn)).NotTo(gomega.HaveOccurred())
+	g.Expect(addBuildTemplate(mgr, newBuildTemplateReconciler(mgr))).NotTo(gomega.HaveOccurred())
 	stopMgr, mgrStopped := StartTestManager(mgr, g)
 	defer func() {
 		close(stopMgr)
@@ -141,21 +164,24 @@ func TestReconcile(t *testing.T) {
 	g.Expect(functionConfigMap.Data["handler.js"]).To(gomega.Equal(fnCreated.Spec.Function))
 	g.Expect(functionConfigMap.Data["package.json"]).To(gomega.Equal("{}"))
 
-	// get service
+	// get service, it is deployed once the image got built
 	service := &servingv1alpha1.Service{}
-	g.Eventually(func() error { return c.Get(context.TODO(), depKey, service) }, timeout).
-		Should(gomega.Succeed())
+	g.Eventually(func() error {
+		succeedBuilds(c, fnCreated)
+		return c.Get(context.TODO(), depKey, service)
+	}, timeout).Should(gomega.Succeed())
 	g.Expect(service.Namespace).To(gomega.Equal("default"))
 
 	// ensure container environment variables are correct
 	g.Expect(service.Spec.ConfigurationSpec.Template.Spec.RevisionSpec.PodSpec.Containers[0].Env).To(gomega.Equal(expectedEnv))
 
-	// Unique Build name base on function sha
+	// Unique Build name based on the image
 	hash := sha256.New()
 	hash.Write([]byte(functionConfigMap.Data["handler.js"] + functionConfigMap.Data["package.json"]))
 	functionSha := fmt.Sprintf("%x", hash.Sum(nil))
-	shortSha := functionSha[0:10]
-	buildName := fmt.Sprintf("%s-%s", fnCreated.Name, shortSha)
+	hash = sha256.New()
+	hash.Write([]byte(fmt.Sprintf("test/default.foo:%s", functionSha)))
+	buildName := fmt.Sprintf("%s-%s", fnCreated.Name, fmt.Sprintf("%x", hash.Sum(nil))[0:10])
 
 	// get the build object
 	build := &buildv1alpha1.Build{}
@@ -165,9 +191,9 @@ func TestReconcile(t *testing.T) {
 		Should(gomega.Succeed())
 
 	// get the build template
-	buildTemplate := &buildv1alpha1.BuildTemplate{}
+	buildTemplate := &buildv1alpha1.ClusterBuildTemplate{}
 	g.Eventually(func() error {
-		return c.Get(context.TODO(), types.NamespacedName{Name: "function-kaniko", Namespace: "default"}, buildTemplate)
+		return c.Get(context.TODO(), types.NamespacedName{Name: "function-kaniko"}, buildTemplate)
 	}, timeout).
 		Should(gomega.Succeed())
 
@@ -183,12 +209,19 @@ func TestReconcile(t *testing.T) {
 			"Name": gomega.BeEquivalentTo("DOCKERFILE"),
 		}),
 	))
-	// TODO: ignore order
-	// ensure build template references correct config map
-	var configMapNameNodeJs6 = "dockerfile-nodejs-6"
-	var configMapNameNodeJs8 = "dockerfile-nodejs-8"
-	g.Expect(buildTemplate.Spec.Volumes[0].ConfigMap.LocalObjectReference.Name).To(gomega.BeEquivalentTo(configMapNameNodeJs6))
-	g.Expect(buildTemplate.Spec.Volumes[1].ConfigMap.LocalObjectReference.Name).To(gomega.BeEquivalentTo(configMapNameNodeJs8))
+	// ensure build template references the config maps of the available runtimes
+	g.Eventually(func() []string {
+		c.Get(context.TODO(), types.NamespacedName{Name: "function-kaniko"}, buildTemplate)
+		names := []string{}
+		for _, volume := range buildTemplate.Spec.Volumes {
+			names = append(names, volume.ConfigMap.LocalObjectReference.Name)
+		}
+		return names
+	}, timeout).Should(gomega.Equal([]string{"dockerfile-nodejs8"}))
+
+	// ensure the build uses the shared build template
+	g.Expect(build.Spec.Template.Name).To(gomega.Equal("function-kaniko"))
+	g.Expect(build.Spec.Template.Kind).To(gomega.Equal(buildv1alpha1.ClusterBuildTemplateKind))
 
 	// g.Expect(service.Spec.RunLatest.Configuration.RevisionTemplate.Spec.Container.Image).To(gomega.HavePrefix("test/default-foo"))
 	g.Expect(build.Spec.ServiceAccountName).To(gomega.Equal("build-bot"))
@@ -215,6 +248,21 @@ func TestReconcile(t *testing.T) {
 	g.Expect(c.Get(context.TODO(), depKey, fnUpdatedFetched)).NotTo(gomega.HaveOccurred())
 	g.Expect(fnUpdatedFetched.Spec).To(gomega.Equal(fnCreated.Spec))
 
+	// add a runtime to the function controller configuration
+	fnConfigUpdated := &corev1.ConfigMap{}
+	g.Expect(c.Get(context.TODO(), types.NamespacedName{Name: fnConfig.Name, Namespace: fnConfig.Namespace}, fnConfigUpdated)).NotTo(gomega.HaveOccurred())
+	fnConfigUpdated.Data["runtimes"] = `[
+		{
+			"ID": "nodejs8",
+			"DockerFileName": "dockerfile-nodejs8",
+		},
+		{
+			"ID": "nodejs10",
+			"DockerFileName": "dockerfile-nodejs10",
+		}
+	]`
+	g.Expect(c.Update(context.TODO(), fnConfigUpdated)).NotTo(gomega.HaveOccurred())
+
 	// update function code and add dependencies
 	fnUpdated := fnUpdatedFetched.DeepCopy()
 	fnUpdated.Spec.Function = `main() {return "bla"}`
@@ -242,15 +290,51 @@ func TestReconcile(t *testing.T) {
 		return cmUpdated.Data["package.json"]
 	}, timeout, 1*time.Second).Should(gomega.Equal(`dependencies`))
 
-	// ensure updated knative service has updated image
+	// ensure updated knative service has updated image once it got built
 	ksvcUpdated := &servingv1alpha1.Service{}
-	g.Expect(c.Get(context.TODO(), depKey, ksvcUpdated)).NotTo(gomega.HaveOccurred())
 	hash = sha256.New()
 	print("cmUpdated: %v", cmUpdated)
 	hash.Write([]byte(cmUpdated.Data["handler.js"] + cmUpdated.Data["package.json"]))
 	functionSha = fmt.Sprintf("%x", hash.Sum(nil))
-	g.Expect(ksvcUpdated.Spec.ConfigurationSpec.Template.Spec.RevisionSpec.PodSpec.Containers[0].Image).
-		To(gomega.Equal(fmt.Sprintf("test/%s-%s:%s", "default", "foo", functionSha)))
+	g.Eventually(func() string {
+		succeedBuilds(c, fnCreated)
+		if err := c.Get(context.TODO(), depKey, ksvcUpdated); err != nil {
+			return ""
+		}
+		return ksvcUpdated.Spec.ConfigurationSpec.Template.Spec.RevisionSpec.PodSpec.Containers[0].Image
+	}, timeout).Should(gomega.Equal(fmt.Sprintf("test/%s.%s:%s", "default", "foo", functionSha)))
+
+	// move the images to another registry, the function is rebuilt and deployed from there
+	g.Expect(c.Get(context.TODO(), types.NamespacedName{Name: fnConfig.Name, Namespace: fnConfig.Namespace}, fnConfigUpdated)).NotTo(gomega.HaveOccurred())
+	fnConfigUpdated.Data["dockerRegistry"] = "other"
+	g.Expect(c.Update(context.TODO(), fnConfigUpdated)).NotTo(gomega.HaveOccurred())
+	g.Eventually(func() string {
+		succeedBuilds(c, fnCreated)
+		if err := c.Get(context.TODO(), depKey, ksvcUpdated); err != nil {
+			return ""
+		}
+		return ksvcUpdated.Spec.ConfigurationSpec.Template.Spec.RevisionSpec.PodSpec.Containers[0].Image
+	}, timeout).Should(gomega.Equal(fmt.Sprintf("other/%s.%s:%s", "default", "foo", functionSha)))
+	fnMoved := &runtimev1alpha1.Function{}
+	g.Expect(c.Get(context.TODO(), depKey, fnMoved)).NotTo(gomega.HaveOccurred())
+	g.Expect(fnMoved.Status.Condition).NotTo(gomega.Equal(runtimev1alpha1.FunctionConditionError))
+
+	// the images built in both registries are recorded, they are deleted with the function
+	g.Eventually(func() []string {
+		c.Get(context.TODO(), depKey, fnMoved)
+		return fnMoved.Status.Images
+	}, timeout).Should(gomega.ContainElement("other/default.foo:" + functionSha))
+	g.Expect(fnMoved.Status.Images).To(gomega.ContainElement("test/default.foo:" + functionSha))
+
+	// ensure the build template got updated with the added runtime
+	g.Eventually(func() []string {
+		c.Get(context.TODO(), types.NamespacedName{Name: "function-kaniko"}, buildTemplate)
+		names := []string{}
+		for _, volume := range buildTemplate.Spec.Volumes {
+			names = append(names, volume.ConfigMap.LocalObjectReference.Name)
+		}
+		return names
+	}, timeout).Should(gomega.Equal([]string{"dockerfile-nodejs8", "dockerfile-nodejs10"}))
 
 	g.Expect(fnUpdatedFetched.Status.Condition).To(gomega.Equal(runtimev1alpha1.FunctionConditionDeploying))
 
@@ -306,6 +390,7 @@ func TestReconcileDeleteFunction(t *testing.T) {
 	c = mgr.GetClient()
 	recFn, requests, errors := SetupTestReconcile(newReconciler(mgr))
 	g.Expect(add(mgr, recFn)).NotTo(gomega.HaveOccurred())
+	g.Expect(addBuildTemplate(mgr, newBuildTemplateReconciler(mgr))).NotTo(gomega.HaveOccurred())
 	stopMgr, mgrStopped := StartTestManager(mgr, g)
 	defer func() {
 		close(stopMgr)
@@ -336,6 +421,375 @@ func TestReconcileDeleteFunction(t *testing.T) {
 	g.Eventually(errors).ShouldNot(gomega.Receive(gomega.Succeed()))
 }
 
+// Test that deleted children of a function are created again
+func TestReconcileRestoresChildren(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	depKey := types.NamespacedName{Name: "test-restore-children", Namespace: "default"}
+
+	fnConfig := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "fn-config",
+			Namespace: "default",
+		},
+		Data: map[string]string{
+			"dockerRegistry":     "test",
+			"serviceAccountName": "build-bot",
+			"runtimes": `[
+				{
+					"ID": "nodejs8",
+					"DockerFileName": "dockerfile-nodejs8",
+				}
+			]`,
+		},
+	}
+	fnCreated := &runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      depKey.Name,
+			Namespace: depKey.Namespace,
+		},
+		Spec: runtimev1alpha1.FunctionSpec{
+			Function:            "main() {asdfasdf}",
+			FunctionContentType: "plaintext",
+			Size:                "L",
+			Runtime:             "nodejs8",
+		},
+	}
+
+	// start manager
+	mgr, err := manager.New(cfg, manager.Options{})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	c = mgr.GetClient()
+	recFn, requests, errors := SetupTestReconcile(newReconciler(mgr))
+	g.Expect(add(mgr, recFn)).NotTo(gomega.HaveOccurred())
+	g.Expect(addBuildTemplate(mgr, newBuildTemplateReconciler(mgr))).NotTo(gomega.HaveOccurred())
+
+	// the children are restored by any number of reconciles
+	drained := make(chan struct{})
+	defer close(drained)
+	go func() {
+		for {
+			select {
+			case <-requests:
+			case <-errors:
+			case <-drained:
+				return
+			}
+		}
+	}()
+
+	stopMgr, mgrStopped := StartTestManager(mgr, g)
+	defer func() {
+		close(stopMgr)
+		mgrStopped.Wait()
+	}()
+
+	g.Expect(c.Create(context.TODO(), fnConfig)).NotTo(gomega.HaveOccurred())
+	g.Expect(c.Create(context.TODO(), fnCreated)).NotTo(gomega.HaveOccurred())
+	defer func() {
+		_ = c.Delete(context.TODO(), fnCreated)
+		_ = c.Delete(context.TODO(), fnConfig)
+	}()
+
+	// the Build is named after the sources of the function
+	var buildKey types.NamespacedName
+	g.Eventually(func() string {
+		builds := &buildv1alpha1.BuildList{}
+		c.List(context.TODO(), client.InNamespace("default"), builds)
+		for _, build := range builds.Items {
+			if metav1.IsControlledBy(&build, fnCreated) {
+				buildKey = types.NamespacedName{Name: build.Name, Namespace: build.Namespace}
+			}
+		}
+		return buildKey.Name
+	}, timeout).ShouldNot(gomega.BeEmpty())
+
+	children := []struct {
+		kind string
+		key  types.NamespacedName
+		obj  runtime.Object
+	}{
+		{kind: "ConfigMap", key: depKey, obj: &corev1.ConfigMap{}},
+		{kind: "Service", key: depKey, obj: &servingv1alpha1.Service{}},
+		{kind: "Build", key: buildKey, obj: &buildv1alpha1.Build{}},
+	}
+
+	for _, child := range children {
+		uid := func() types.UID {
+			succeedBuilds(c, fnCreated)
+			if err := c.Get(context.TODO(), child.key, child.obj); err != nil {
+				return ""
+			}
+			return child.obj.(metav1.Object).GetUID()
+		}
+		g.Eventually(uid, timeout).ShouldNot(gomega.BeEmpty(), child.kind)
+		deletedUID := uid()
+
+		// deleting a child reconciles the owning function, which creates it again
+		g.Expect(c.Delete(context.TODO(), child.obj)).NotTo(gomega.HaveOccurred(), child.kind)
+		g.Eventually(uid, timeout).ShouldNot(gomega.Or(gomega.BeEmpty(), gomega.Equal(deletedUID)), child.kind)
+	}
+}
+
+// Test that the revision pod of a function never references the push credentials of builds
+func TestReconcileRuntimeWithoutPushCredentials(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	objectName := "test-runtime-without-push-credentials"
+	depKey := types.NamespacedName{Name: objectName, Namespace: "default"}
+
+	pushSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "push-credentials",
+			Namespace:   "default",
+			Annotations: map[string]string{"build.knative.dev/docker-0": "https://index.docker.io/v1/"},
+		},
+		Type: corev1.SecretTypeBasicAuth,
+		Data: map[string][]byte{
+			corev1.BasicAuthUsernameKey: []byte("push"),
+			corev1.BasicAuthPasswordKey: []byte("push"),
+		},
+	}
+	pullSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pull-credentials",
+			Namespace: "default",
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`),
+		},
+	}
+	buildServiceAccount := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "push-bot",
+			Namespace: "default",
+		},
+		Secrets: []corev1.ObjectReference{{Name: pushSecret.Name}},
+	}
+	fnConfig := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "fn-config",
+			Namespace: "default",
+		},
+		Data: map[string]string{
+			"dockerRegistry":     "test",
+			"serviceAccountName": buildServiceAccount.Name,
+			"registryPullSecret": pullSecret.Name,
+		},
+	}
+	fnCreated := &runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      objectName,
+			Namespace: "default",
+		},
+		Spec: runtimev1alpha1.FunctionSpec{
+			Function:            "main() {asdfasdf}",
+			FunctionContentType: "plaintext",
+			Size:                "L",
+			Runtime:             "nodejs8",
+		},
+	}
+
+	// start manager
+	mgr, err := manager.New(cfg, manager.Options{})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	c = mgr.GetClient()
+	recFn, requests, _ := SetupTestReconcile(newReconciler(mgr))
+	g.Expect(add(mgr, recFn)).NotTo(gomega.HaveOccurred())
+	g.Expect(addBuildTemplate(mgr, newBuildTemplateReconciler(mgr))).NotTo(gomega.HaveOccurred())
+	stopMgr, mgrStopped := StartTestManager(mgr, g)
+	defer func() {
+		close(stopMgr)
+		mgrStopped.Wait()
+	}()
+
+	for _, obj := range []runtime.Object{pushSecret, pullSecret, buildServiceAccount, fnConfig, fnCreated} {
+		g.Expect(c.Create(context.TODO(), obj)).NotTo(gomega.HaveOccurred())
+	}
+	defer func() {
+		for _, obj := range []runtime.Object{fnCreated, fnConfig, buildServiceAccount, pullSecret, pushSecret} {
+			_ = c.Delete(context.TODO(), obj)
+		}
+	}()
+
+	g.Eventually(requests, timeout).Should(gomega.Receive(gomega.Equal(reconcile.Request{NamespacedName: depKey})))
+
+	// get service, it is deployed once the image got built
+	service := &servingv1alpha1.Service{}
+	g.Eventually(func() error {
+		succeedBuilds(c, fnCreated)
+		return c.Get(context.TODO(), depKey, service)
+	}, timeout).Should(gomega.Succeed())
+	podSpec := service.Spec.ConfigurationSpec.Template.Spec.RevisionSpec.PodSpec
+
+	// the revision pod runs with the runtime service account and doesn't mount the push credentials
+	g.Expect(podSpec.ServiceAccountName).To(gomega.Equal("function-runtime"))
+	for _, volume := range podSpec.Volumes {
+		g.Expect(volume.Secret).To(gomega.Or(gomega.BeNil(), gstruct.PointTo(gstruct.MatchFields(gstruct.IgnoreExtras, gstruct.Fields{
+			"SecretName": gomega.Not(gomega.Equal(pushSecret.Name)),
+		}))))
+	}
+
+	// the runtime service account only references the generated pull secret
+	runtimeServiceAccount := &corev1.ServiceAccount{}
+	g.Eventually(func() error {
+		return c.Get(context.TODO(), types.NamespacedName{Name: "function-runtime", Namespace: "default"}, runtimeServiceAccount)
+	}, timeout).Should(gomega.Succeed())
+	g.Expect(runtimeServiceAccount.ImagePullSecrets).To(gomega.Equal([]corev1.LocalObjectReference{{Name: "function-runtime-registry"}}))
+	g.Expect(runtimeServiceAccount.Secrets).NotTo(gomega.ContainElement(gstruct.MatchFields(gstruct.IgnoreExtras, gstruct.Fields{
+		"Name": gomega.Equal(pushSecret.Name),
+	})))
+
+	runtimePullSecret := &corev1.Secret{}
+	g.Expect(c.Get(context.TODO(), types.NamespacedName{Name: "function-runtime-registry", Namespace: "default"}, runtimePullSecret)).To(gomega.Succeed())
+	g.Expect(runtimePullSecret.Data).To(gomega.Equal(pullSecret.Data))
+
+	// builds keep the push credentials
+	builds := &buildv1alpha1.BuildList{}
+	g.Eventually(func() int {
+		c.List(context.TODO(), client.InNamespace("default"), builds)
+		count := 0
+		for _, build := range builds.Items {
+			if metav1.IsControlledBy(&build, fnCreated) {
+				g.Expect(build.Spec.ServiceAccountName).To(gomega.Equal(buildServiceAccount.Name))
+				count++
+			}
+		}
+		return count
+	}, timeout).Should(gomega.BeNumerically(">", 0))
+}
+
+func TestReconcileEvents(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	depKey := types.NamespacedName{Name: "test-reconcile-events", Namespace: "default"}
+
+	fnConfig := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "fn-config",
+			Namespace: "default",
+		},
+		Data: map[string]string{
+			"dockerRegistry":     "test",
+			"serviceAccountName": "build-bot",
+			"runtimes": `[
+				{
+					"ID": "nodejs8",
+					"DockerFileName": "dockerfile-nodejs8",
+				}
+			]`,
+		},
+	}
+	fnCreated := &runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      depKey.Name,
+			Namespace: depKey.Namespace,
+		},
+		Spec: runtimev1alpha1.FunctionSpec{
+			Function:            "main() {asdfasdf}",
+			FunctionContentType: "plaintext",
+			Size:                "L",
+			Runtime:             "nodejs8",
+		},
+	}
+
+	mgr, err := manager.New(cfg, manager.Options{})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	c := mgr.GetClient()
+	g.Expect(addBuildTemplate(mgr, newBuildTemplateReconciler(mgr))).NotTo(gomega.HaveOccurred())
+	recorder := record.NewFakeRecorder(100)
+	reconcileFunction := newReconciler(mgr).(*ReconcileFunction)
+	reconcileFunction.recorder = recorder
+
+	stopMgr, mgrStopped := StartTestManager(mgr, g)
+	defer func() {
+		close(stopMgr)
+		mgrStopped.Wait()
+	}()
+
+	g.Expect(c.Create(context.TODO(), fnCreated)).NotTo(gomega.HaveOccurred())
+	defer func() {
+		_ = c.Delete(context.TODO(), fnCreated)
+		_ = c.Delete(context.TODO(), fnConfig)
+		_ = c.Delete(context.TODO(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: depKey.Name, Namespace: depKey.Namespace}})
+		_ = c.Delete(context.TODO(), &servingv1alpha1.Service{ObjectMeta: metav1.ObjectMeta{Name: depKey.Name, Namespace: depKey.Namespace}})
+	}()
+
+	events := []string{}
+	// finishBuilds finishes the builds of the function which are running, they succeed unless it is replaced
+	finishBuilds := succeedBuilds
+	reconcileEvents := func() []string {
+		finishBuilds(c, fnCreated)
+		reconcileFunction.Reconcile(reconcile.Request{NamespacedName: depKey})
+		for {
+			select {
+			case event := <-recorder.Events:
+				events = append(events, event)
+			default:
+				return events
+			}
+		}
+	}
+
+	// a missing configuration is reported
+	g.Eventually(reconcileEvents, timeout).Should(gomega.ContainElement(
+		"Warning ConfigInvalid Configuration default/fn-config not found",
+	))
+
+	// the build and the Knative Service are reported once created, the Knative Service once the build succeeded
+	g.Expect(c.Create(context.TODO(), fnConfig)).NotTo(gomega.HaveOccurred())
+	g.Eventually(reconcileEvents, timeout).Should(gomega.ContainElement(gomega.HavePrefix("Normal ServiceCreated ")))
+	g.Expect(events).To(gomega.ContainElement(gomega.HavePrefix("Normal BuildStarted Started Build " + depKey.Name + "-")))
+	g.Expect(events).To(gomega.ContainElement("Normal ServiceCreated Created Knative Service " + depKey.Name))
+
+	// the revision is reported once the Knative Service is ready
+	service := &servingv1alpha1.Service{}
+	g.Expect(c.Get(context.TODO(), depKey, service)).NotTo(gomega.HaveOccurred())
+	service.Status = servingv1alpha1.ServiceStatus{
+		ConfigurationStatusFields: servingv1alpha1.ConfigurationStatusFields{
+			LatestCreatedRevisionName: depKey.Name + "-00001",
+			LatestReadyRevisionName:   depKey.Name + "-00001",
+		},
+		Status: duckv1beta1.Status{
+			Conditions: []apis.Condition{
+				{Type: servingv1alpha1.ServiceConditionReady, Status: corev1.ConditionTrue},
+				{Type: servingv1alpha1.RouteConditionReady, Status: corev1.ConditionTrue},
+				{Type: servingv1alpha1.ConfigurationConditionReady, Status: corev1.ConditionTrue},
+			},
+		},
+	}
+	g.Expect(c.Status().Update(context.TODO(), service)).NotTo(gomega.HaveOccurred())
+	g.Eventually(reconcileEvents, timeout).Should(gomega.ContainElement(
+		"Normal RevisionReady Revision " + depKey.Name + "-00001 is ready",
+	))
+
+	// a Build failing for good is reported with its failed step and message
+	failedBuild := ""
+	finishBuilds = func(c client.Client, fn *runtimev1alpha1.Function) {
+		builds := &buildv1alpha1.BuildList{}
+		if err := c.List(context.TODO(), client.InNamespace(fn.Namespace), builds); err != nil {
+			return
+		}
+		for i := range builds.Items {
+			build := &builds.Items[i]
+			if !metav1.IsControlledBy(build, fn) || len(build.Status.Conditions) > 0 {
+				continue
+			}
+			build.Status = failedBuildStatus("error pushing image: UNAUTHORIZED: authentication required", 1, "Error")
+			if c.Status().Update(context.TODO(), build) == nil {
+				failedBuild = build.Name
+			}
+		}
+	}
+	fnUpdated := &runtimev1alpha1.Function{}
+	g.Expect(c.Get(context.TODO(), depKey, fnUpdated)).NotTo(gomega.HaveOccurred())
+	fnUpdated.Spec.Function = `main() {return "updated"}`
+	g.Expect(c.Update(context.TODO(), fnUpdated)).NotTo(gomega.HaveOccurred())
+	g.Eventually(reconcileEvents, timeout).Should(gomega.ContainElement(gomega.HavePrefix("Warning BuildFailed ")))
+	g.Expect(failedBuild).NotTo(gomega.BeEmpty())
+	g.Expect(events).To(gomega.ContainElement(
+		"Warning BuildFailed Build " + failedBuild + " failed at step build-step-build-and-push: error pushing image: UNAUTHORIZED: authentication required",
+	))
+}
+
 // Test status of newly created function
 func TestFunctionConditionNewFunction(t *testing.T) {
 	g := gomega.NewGomegaWithT(t)
@@ -356,7 +810,7 @@ func TestFunctionConditionNewFunction(t *testing.T) {
 
 	g.Expect(c.Create(context.TODO(), &function)).Should(gomega.Succeed())
 
-	reconcileFunction.getFunctionCondition(&function)
+	reconcileFunction.getFunctionCondition(&runtimeUtil.RuntimeInfo{}, &function)
 
 	// no knative objects present => no function status
 	g.Expect(fmt.Sprint(function.Status.Condition)).To(gomega.Equal(""))
@@ -424,11 +878,187 @@ func TestFunctionConditionBuildError(t *testing.T) {
 	g.Expect(c.Status().Update(context.TODO(), &foundBuild)).Should(gomega.Succeed())
 
 	g.Eventually(func() runtimev1alpha1.FunctionCondition {
-		reconcileFunction.getFunctionCondition(&function)
+		reconcileFunction.getFunctionCondition(&runtimeUtil.RuntimeInfo{}, &function)
 		return function.Status.Condition
 	}).Should(gomega.Equal(runtimev1alpha1.FunctionConditionError))
 }
 
+func TestFunctionBuildStatus(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	mgr, err := manager.New(cfg, manager.Options{})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	c := mgr.GetClient()
+
+	stopMgr, mgrStopped := StartTestManager(mgr, g)
+	defer func() {
+		close(stopMgr)
+		mgrStopped.Wait()
+	}()
+
+	buildLogs := map[string]string{
+		"test-build-cache-hit":  "INFO[0010] Using caching version of cmd: RUN /kubeless-npm-install.sh",
+		"test-build-cache-miss": "INFO[0010] RUN /kubeless-npm-install.sh",
+	}
+	readLogs := 0
+	reconcileFunction := &ReconcileFunction{
+		Client: c,
+		scheme: scheme.Scheme,
+		podLogs: func(namespace, name, container string) ([]byte, error) {
+			g.Expect(container).To(gomega.Equal(buildAndPushStep))
+			readLogs++
+			return []byte(buildLogs[name]), nil
+		},
+	}
+
+	tests := []struct {
+		name      string
+		cache     string
+		succeeded corev1.ConditionStatus
+		expected  runtimev1alpha1.FunctionBuildCache
+	}{
+		{name: "test-build-cache-hit", cache: "true", succeeded: corev1.ConditionTrue, expected: runtimev1alpha1.FunctionBuildCacheHit},
+		{name: "test-build-cache-miss", cache: "true", succeeded: corev1.ConditionTrue, expected: runtimev1alpha1.FunctionBuildCacheMiss},
+		{name: "test-build-cache-disabled", cache: "false", succeeded: corev1.ConditionTrue, expected: runtimev1alpha1.FunctionBuildCacheDisabled},
+		{name: "test-build-cache-running", cache: "true", succeeded: corev1.ConditionUnknown, expected: ""},
+	}
+
+	for _, test := range tests {
+		build := &buildv1alpha1.Build{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      test.name,
+				Namespace: "default",
+			},
+			Spec: buildv1alpha1.BuildSpec{
+				Template: &buildv1alpha1.TemplateInstantiationSpec{
+					Name:      "function-kaniko",
+					Kind:      buildv1alpha1.ClusterBuildTemplateKind,
+					Arguments: []buildv1alpha1.ArgumentSpec{{Name: "CACHE", Value: test.cache}},
+				},
+			},
+		}
+		g.Expect(c.Create(context.TODO(), build)).Should(gomega.Succeed())
+		defer c.Delete(context.TODO(), build)
+
+		g.Eventually(func() error {
+			return c.Get(context.TODO(), types.NamespacedName{Name: test.name, Namespace: "default"}, build)
+		}).Should(gomega.Succeed())
+		build.Status = buildv1alpha1.BuildStatus{
+			Cluster: &buildv1alpha1.ClusterSpec{Namespace: "default", PodName: test.name},
+			Status: duckv1alpha1.Status{
+				Conditions: []duckv1alpha1.Condition{
+					{
+						Type:   duckv1alpha1.ConditionSucceeded,
+						Status: test.succeeded,
+					},
+				},
+			},
+		}
+		g.Expect(c.Status().Update(context.TODO(), build)).Should(gomega.Succeed())
+
+		function := &runtimev1alpha1.Function{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      test.name,
+				Namespace: "default",
+			},
+		}
+		g.Eventually(func() runtimev1alpha1.FunctionBuildCache {
+			reconcileFunction.getBuildStatus(function, test.name)
+			return function.Status.Build.Cache
+		}).Should(gomega.Equal(test.expected), test.name)
+		g.Expect(function.Status.Build.Name).To(gomega.Equal(test.name))
+
+		// the logs are read once per build
+		read := readLogs
+		reconcileFunction.getBuildStatus(function, test.name)
+		g.Expect(function.Status.Build.Cache).To(gomega.Equal(test.expected))
+		if test.expected != "" {
+			g.Expect(readLogs).To(gomega.Equal(read))
+		}
+	}
+}
+
+func TestFunctionBuildFailureStatus(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	objectName := "test-build-failure-status"
+
+	mgr, err := manager.New(cfg, manager.Options{})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	c := mgr.GetClient()
+
+	stopMgr, mgrStopped := StartTestManager(mgr, g)
+	defer func() {
+		close(stopMgr)
+		mgrStopped.Wait()
+	}()
+
+	logs := []string{}
+	for i := 1; i <= 30; i++ {
+		logs = append(logs, fmt.Sprintf("line %d", i))
+	}
+	recorder := record.NewFakeRecorder(10)
+	reconcileFunction := &ReconcileFunction{
+		Client:   c,
+		scheme:   scheme.Scheme,
+		recorder: recorder,
+		podLogs: func(namespace, name, container string) ([]byte, error) {
+			g.Expect(name).To(gomega.Equal(objectName + "-pod"))
+			g.Expect(container).To(gomega.Equal(buildAndPushStep))
+			return []byte(strings.Join(logs, "\n") + "\n"), nil
+		},
+	}
+
+	build := &buildv1alpha1.Build{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      objectName,
+			Namespace: "default",
+		},
+	}
+	g.Expect(c.Create(context.TODO(), build)).Should(gomega.Succeed())
+	defer c.Delete(context.TODO(), build)
+
+	g.Eventually(func() error {
+		return c.Get(context.TODO(), types.NamespacedName{Name: objectName, Namespace: "default"}, build)
+	}).Should(gomega.Succeed())
+	build.Status = buildv1alpha1.BuildStatus{
+		Cluster: &buildv1alpha1.ClusterSpec{Namespace: "default", PodName: objectName + "-pod"},
+		Status: duckv1alpha1.Status{
+			Conditions: []duckv1alpha1.Condition{
+				{
+					Type:    duckv1alpha1.ConditionSucceeded,
+					Status:  corev1.ConditionFalse,
+					Message: `build step "build-step-build-and-push" exited with code 1 (image: "docker-pullable://gcr.io/kaniko-project/executor"); for logs run: kubectl -n default logs test-build-failure-status-pod -c build-step-build-and-push`,
+				},
+			},
+		},
+		StepStates: []corev1.ContainerState{
+			{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}},
+			{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, Message: "error pushing image: UNAUTHORIZED"}},
+		},
+	}
+	g.Expect(c.Status().Update(context.TODO(), build)).Should(gomega.Succeed())
+
+	function := &runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      objectName,
+			Namespace: "default",
+		},
+	}
+	g.Eventually(func() string {
+		reconcileFunction.getBuildStatus(function, objectName)
+		return function.Status.Build.FailedStep
+	}).Should(gomega.Equal(buildAndPushStep))
+	g.Expect(function.Status.Build.Message).To(gomega.Equal("error pushing image: UNAUTHORIZED"))
+	g.Expect(function.Status.Build.Logs).To(gomega.Equal(strings.Join(logs[10:], "\n")))
+	g.Expect(recorder.Events).To(gomega.Receive(gomega.Equal(
+		"Warning BuildFailed Build test-build-failure-status failed at step build-step-build-and-push: error pushing image: UNAUTHORIZED",
+	)))
+
+	// the failure is only reported once
+	reconcileFunction.getBuildStatus(function, objectName)
+	g.Expect(recorder.Events).NotTo(gomega.Receive())
+}
+
 func TestFunctionConditionServiceSuccess(t *testing.T) {
 	g := gomega.NewGomegaWithT(t)
 
@@ -437,7 +1067,8 @@ func TestFunctionConditionServiceSuccess(t *testing.T) {
 	mgr, err := manager.New(cfg, manager.Options{})
 	g.Expect(err).NotTo(gomega.HaveOccurred())
 	c := mgr.GetClient()
-	reconcileFunction := &ReconcileFunction{Client: c, scheme: scheme.Scheme}
+	recorder := record.NewFakeRecorder(10)
+	reconcileFunction := &ReconcileFunction{Client: c, scheme: scheme.Scheme, recorder: recorder}
 
 	stopMgr, mgrStopped := StartTestManager(mgr, g)
 	defer func() {
@@ -497,9 +1128,14 @@ func TestFunctionConditionServiceSuccess(t *testing.T) {
 	g.Expect(c.Status().Update(context.TODO(), &foundService)).Should(gomega.Succeed())
 
 	g.Eventually(func() runtimev1alpha1.FunctionCondition {
-		reconcileFunction.getFunctionCondition(&function)
+		reconcileFunction.getFunctionCondition(&runtimeUtil.RuntimeInfo{}, &function)
 		return function.Status.Condition
 	}).Should(gomega.Equal(runtimev1alpha1.FunctionConditionRunning))
+	g.Expect(recorder.Events).To(gomega.Receive(gomega.Equal("Normal RevisionReady Revision foo is ready")))
+
+	// a Function which stays ready isn't reported again
+	reconcileFunction.getFunctionCondition(&runtimeUtil.RuntimeInfo{}, &function)
+	g.Expect(recorder.Events).NotTo(gomega.Receive())
 }
 
 func TestFunctionConditionServiceError(t *testing.T) {
@@ -569,7 +1205,7 @@ func TestFunctionConditionServiceError(t *testing.T) {
 	g.Expect(c.Status().Update(context.TODO(), &foundService)).Should(gomega.Succeed())
 
 	g.Eventually(func() runtimev1alpha1.FunctionCondition {
-		reconcileFunction.getFunctionCondition(&function)
+		reconcileFunction.getFunctionCondition(&runtimeUtil.RuntimeInfo{}, &function)
 		return function.Status.Condition
 	}).Should(gomega.Equal(runtimev1alpha1.FunctionConditionDeploying))
 }
@@ -586,9 +1222,10 @@ func TestCreateFunctionHandlerMap(t *testing.T) {
 	functionHandlerMap := createFunctionHandlerMap(&function)
 
 	mapx := map[string]string{
-		"handler":      "handler.main",
-		"handler.js":   functionCode,
-		"package.json": functionDependecies,
+		"handler":           "handler.main",
+		"handler.js":        functionCode,
+		"package.json":      functionDependecies,
+		"package-lock.json": "",
 	}
 	g.Expect(functionHandlerMap).To(gomega.Equal(mapx))
 }
@@ -603,9 +1240,101 @@ func TestCreateFunctionHandlerMapNoDependencies(t *testing.T) {
 	functionHandlerMap := createFunctionHandlerMap(&function)
 
 	mapx := map[string]string{
-		"handler":      "handler.main",
-		"handler.js":   functionCode,
-		"package.json": "{}",
+		"handler":           "handler.main",
+		"handler.js":        functionCode,
+		"package.json":      "{}",
+		"package-lock.json": "",
 	}
 	g.Expect(functionHandlerMap).To(gomega.Equal(mapx))
 }
+
+func TestCreateFunctionHandlerMapLockfile(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	function := runtimev1alpha1.Function{Spec: runtimev1alpha1.FunctionSpec{
+		Function: "some function code",
+		Deps:     `{"name": "foo", "version": "1.0.0"}`,
+		DepsLock: `{"name": "foo", "version": "1.0.0", "lockfileVersion": 1}`,
+	},
+	}
+	functionHandlerMap := createFunctionHandlerMap(&function)
+
+	g.Expect(functionHandlerMap).To(gomega.HaveKeyWithValue("package.json", function.Spec.Deps))
+	g.Expect(functionHandlerMap).To(gomega.HaveKeyWithValue("package-lock.json", function.Spec.DepsLock))
+}
+
+func TestMergeImagePullSecrets(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	merged := mergeImagePullSecrets(
+		[]corev1.LocalObjectReference{{Name: "registry"}, {Name: "mirror"}},
+		[]corev1.LocalObjectReference{{Name: "mirror"}, {Name: "private"}},
+	)
+	g.Expect(merged).To(gomega.Equal([]corev1.LocalObjectReference{{Name: "registry"}, {Name: "mirror"}, {Name: "private"}}))
+}
+
+func TestApplyFunctionStatus(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	depKey := types.NamespacedName{Name: "test-status", Namespace: "default"}
+
+	// a client without cache, the reconciler reads the writes of the concurrent spec edits right away
+	c, err := client.New(cfg, client.Options{Scheme: scheme.Scheme})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	reconcileFunction := &ReconcileFunction{Client: c, scheme: scheme.Scheme}
+
+	fnCreated := &runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: depKey.Name, Namespace: depKey.Namespace},
+		Spec: runtimev1alpha1.FunctionSpec{
+			Function:            "main() {0}",
+			FunctionContentType: "plaintext",
+			Size:                "S",
+			Runtime:             "nodejs8",
+		},
+	}
+	g.Expect(c.Create(context.TODO(), fnCreated)).NotTo(gomega.HaveOccurred())
+	defer c.Delete(context.TODO(), fnCreated)
+
+	// the reconciles compute their status from the Function they observed before the spec edits
+	stale := &runtimev1alpha1.Function{}
+	g.Expect(c.Get(context.TODO(), depKey, stale)).NotTo(gomega.HaveOccurred())
+
+	const edits = 10
+	edited := make(chan error)
+	go func() {
+		for i := 1; i <= edits; i++ {
+			err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+				fn := &runtimev1alpha1.Function{}
+				if err := c.Get(context.TODO(), depKey, fn); err != nil {
+					return err
+				}
+				fn.Spec.Function = fmt.Sprintf("main() {%d}", i)
+				return c.Update(context.TODO(), fn)
+			})
+			if err != nil {
+				edited <- err
+				return
+			}
+		}
+		edited <- nil
+	}()
+
+	for i := 1; i <= edits; i++ {
+		fn := stale.DeepCopy()
+		fn.Status.Condition = runtimev1alpha1.FunctionConditionBuilding
+		fn.Status.Build = &runtimev1alpha1.FunctionBuildStatus{Name: fmt.Sprintf("%s-%d", depKey.Name, i)}
+		g.Expect(reconcileFunction.applyFunctionStatus(fn, &stale.Status)).NotTo(gomega.HaveOccurred())
+	}
+	g.Expect(<-edited).NotTo(gomega.HaveOccurred())
+
+	// neither the spec edits nor the status of the last reconcile got lost
+	found := &runtimev1alpha1.Function{}
+	g.Expect(c.Get(context.TODO(), depKey, found)).NotTo(gomega.HaveOccurred())
+	g.Expect(found.Spec.Function).To(gomega.Equal(fmt.Sprintf("main() {%d}", edits)))
+	g.Expect(found.Status.Condition).To(gomega.Equal(runtimev1alpha1.FunctionConditionBuilding))
+	g.Expect(found.Status.Build).NotTo(gomega.BeNil())
+	g.Expect(found.Status.Build.Name).To(gomega.Equal(fmt.Sprintf("%s-%d", depKey.Name, edits)))
+
+	// an unchanged status isn't written
+	resourceVersion := found.ResourceVersion
+	g.Expect(reconcileFunction.applyFunctionStatus(found, found.Status.DeepCopy())).NotTo(gomega.HaveOccurred())
+	g.Expect(c.Get(context.TODO(), depKey, found)).NotTo(gomega.HaveOccurred())
+	g.Expect(found.ResourceVersion).To(gomega.Equal(resourceVersion))
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	"github.com/kyma-incubator/runtime/pkg/metrics"
+	runtimeUtil "github.com/kyma-incubator/runtime/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// finalizer of Functions deleting their images from the registry once they are deleted
+	imageCleanupFinalizer = "runtime.kyma-project.io/image-cleanup"
+
+	// failed deletions of images are retried in this interval until the timeout, then the images are left behind
+	imageCleanupRetryInterval = 30 * time.Second
+	imageCleanupTimeout       = 5 * time.Minute
+
+	// timeout of the requests to the registry
+	registryTimeout = 30 * time.Second
+
+	// reasons of the events of the deletion of images
+	imagesDeletedReason      = "ImagesDeleted"
+	imageCleanupFailedReason = "ImageCleanupFailed"
+)
+
+// cleansUpImages checks whether the images of a repository are deleted once their Function is deleted. Images are
+// retained if configured so and on Docker Hub, which doesn't support deleting them.
+func cleansUpImages(rnInfo *runtimeUtil.RuntimeInfo, repository string) bool {
+	host, _ := runtimeUtil.ParseRepository(repository)
+	return !rnInfo.RetainImages && !runtimeUtil.IsDockerHub(host)
+}
+
+// updateImageCleanupFinalizer adds the finalizer to the Functions whose images are cleaned up and removes it from others
+func (r *ReconcileFunction) updateImageCleanupFinalizer(rnInfo *runtimeUtil.RuntimeInfo, fn *runtimev1alpha1.Function) error {
+	cleanup := cleansUpImages(rnInfo, runtimeUtil.ImageRepository(rnInfo.RegistryInfo, fn))
+	if cleanup == hasFinalizer(fn, imageCleanupFinalizer) {
+		return nil
+	}
+
+	// the update returns the persisted status, the status computed by the reconcile is kept
+	updated := fn.DeepCopy()
+	if cleanup {
+		updated.Finalizers = append(updated.Finalizers, imageCleanupFinalizer)
+	} else {
+		updated.Finalizers = removeFinalizer(updated.Finalizers, imageCleanupFinalizer)
+	}
+	if err := r.Update(context.TODO(), updated); err != nil {
+		return err
+	}
+	fn.ObjectMeta = updated.ObjectMeta
+	return nil
+}
+
+// finalizeFunction deletes the images of a deleted Function from the registry before the Function is gone. Failed
+// deletions are retried until the timeout, the images are left behind then to never block the deletion for longer.
+func (r *ReconcileFunction) finalizeFunction(fn *runtimev1alpha1.Function) (reconcile.Result, error) {
+	if !hasFinalizer(fn, imageCleanupFinalizer) {
+		return reconcile.Result{}, nil
+	}
+
+	repositories, deleted, err := r.deleteFunctionImages(fn)
+	repository := strings.Join(repositories, ", ")
+	if err != nil {
+		log.Error(err, "Error while trying to delete the images of the Function", "namespace", fn.Namespace, "name", fn.Name, "repositories", repositories)
+		metrics.ReconcileError(metrics.StepFinalizer)
+		if time.Since(fn.DeletionTimestamp.Time) < imageCleanupTimeout {
+			r.recorder.Eventf(fn, corev1.EventTypeWarning, imageCleanupFailedReason, "Unable to delete the images from %s, retrying: %v", repository, err)
+			return reconcile.Result{RequeueAfter: imageCleanupRetryInterval}, nil
+		}
+		r.recorder.Eventf(fn, corev1.EventTypeWarning, imageCleanupFailedReason, "Unable to delete the images from %s, they are left behind: %v", repository, err)
+	} else if len(deleted) > 0 {
+		log.Info("Deleted the images of the Function", "namespace", fn.Namespace, "name", fn.Name, "images", deleted)
+		r.recorder.Eventf(fn, corev1.EventTypeNormal, imagesDeletedReason, "Deleted %d images from %s", len(deleted), repository)
+	}
+
+	fn.Finalizers = removeFinalizer(fn.Finalizers, imageCleanupFinalizer)
+	if err := r.Update(context.TODO(), fn); err != nil {
+		return reconcile.Result{}, ignoreNotFound(err)
+	}
+	return reconcile.Result{}, nil
+}
+
+// deleteFunctionImages deletes the images built for a Function from the registries they were pushed to, other images
+// of the repositories are kept. It returns the repositories of the images and the deleted images.
+func (r *ReconcileFunction) deleteFunctionImages(fn *runtimev1alpha1.Function) ([]string, []string, error) {
+	repositories, tags := imageTags(fn.Status.Images)
+	if len(repositories) == 0 {
+		return nil, nil, nil
+	}
+
+	fnConfig := &corev1.ConfigMap{}
+	if err := r.getFunctionControllerConfiguration(fnConfig); err != nil {
+		return repositories, nil, err
+	}
+	nsConfig, err := r.getNamespaceConfiguration(fn.Namespace)
+	if err != nil {
+		return repositories, nil, err
+	}
+	rnInfo, err := fnConfigs.runtimeInfo(fnConfig, nsConfig)
+	if err != nil {
+		return repositories, nil, err
+	}
+
+	registryClient := r.registry
+	if registryClient == nil {
+		registryClient = &runtimeUtil.RegistryClient{}
+	}
+
+	deleted := []string{}
+	for _, repository := range repositories {
+		if !cleansUpImages(rnInfo, repository) {
+			continue
+		}
+
+		host, _ := runtimeUtil.ParseRepository(repository)
+		credentials, err := r.registryCredentials(rnInfo.BuildServiceAccount, fn.Namespace, host)
+		if err != nil {
+			return repositories, deleted, err
+		}
+
+		deletedTags, err := registryClient.DeleteTags(repository, credentials, tags[repository])
+		for _, tag := range deletedTags {
+			deleted = append(deleted, repository+":"+tag)
+		}
+		if err != nil {
+			return repositories, deleted, err
+		}
+	}
+	return repositories, deleted, nil
+}
+
+// recordImage adds an image built for a Function to its status, so it is deleted once the Function is deleted
+func recordImage(fn *runtimev1alpha1.Function, image string) {
+	for _, recorded := range fn.Status.Images {
+		if recorded == image {
+			return
+		}
+	}
+	fn.Status.Images = append(fn.Status.Images, image)
+}
+
+// imageTags returns the repositories of image references in order and the tags of the images by repository
+func imageTags(images []string) ([]string, map[string][]string) {
+	repositories := []string{}
+	tags := map[string][]string{}
+	for _, image := range images {
+		i := strings.LastIndex(image, ":")
+		if i < 0 || i < strings.LastIndex(image, "/") {
+			continue
+		}
+		repository, tag := image[:i], image[i+1:]
+		if _, ok := tags[repository]; !ok {
+			repositories = append(repositories, repository)
+		}
+		tags[repository] = append(tags[repository], tag)
+	}
+	return repositories, tags
+}
+
+// registryCredentials returns the credentials of a registry the build service account pushes with, nil if it has none
+func (r *ReconcileFunction) registryCredentials(serviceAccountName, namespace, host string) (*runtimeUtil.RegistryCredentials, error) {
+	sa := &corev1.ServiceAccount{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Name: serviceAccountName, Namespace: namespace}, sa); err != nil {
+		return nil, ignoreNotFound(err)
+	}
+
+	secrets := []string{}
+	for _, secret := range sa.Secrets {
+		secrets = append(secrets, secret.Name)
+	}
+	for _, secret := range sa.ImagePullSecrets {
+		secrets = append(secrets, secret.Name)
+	}
+	for _, name := range secrets {
+		secret := &corev1.Secret{}
+		if err := r.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, secret); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		credentials, err := runtimeUtil.GetRegistryCredentials(secret, host)
+		if err != nil || credentials != nil {
+			return credentials, err
+		}
+	}
+	return nil, nil
+}
+
+func hasFinalizer(fn *runtimev1alpha1.Function, finalizer string) bool {
+	for _, f := range fn.Finalizers {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func removeFinalizer(finalizers []string, finalizer string) []string {
+	result := []string{}
+	for _, f := range finalizers {
+		if f != finalizer {
+			result = append(result, f)
+		}
+	}
+	return result
+}
@@ -0,0 +1,294 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	runtimeUtil "github.com/kyma-incubator/runtime/pkg/utils"
+	"github.com/onsi/gomega"
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// imageRegistry is an in-process registry speaking the parts of the Docker Registry v2 API used to delete images
+type imageRegistry struct {
+	mu sync.Mutex
+	// digests of the manifests by repository and tag
+	tags     map[string]map[string]string
+	requests int
+	failing  bool
+}
+
+func (ir *imageRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+
+	ir.requests++
+	if ir.failing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if username, password, _ := r.BasicAuth(); username != "push" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/tags/list"):
+		repository := strings.TrimSuffix(path, "/tags/list")
+		tags := []string{}
+		for tag := range ir.tags[repository] {
+			tags = append(tags, `"`+tag+`"`)
+		}
+		w.Write([]byte(`{"name":"` + repository + `","tags":[` + strings.Join(tags, ",") + `]}`))
+	case r.Method == http.MethodHead && strings.Contains(path, "/manifests/"):
+		parts := strings.SplitN(path, "/manifests/", 2)
+		digest, ok := ir.tags[parts[0]][parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+	case r.Method == http.MethodDelete && strings.Contains(path, "/manifests/"):
+		parts := strings.SplitN(path, "/manifests/", 2)
+		for tag, digest := range ir.tags[parts[0]] {
+			if digest == parts[1] {
+				delete(ir.tags[parts[0]], tag)
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// imageCleanupObjects returns the configuration of a registry and the credentials the build service accounts of the
+// namespaces push to it with
+func imageCleanupObjects(server *httptest.Server, resourceVersion, retainImages string, namespaces ...string) []runtime.Object {
+	objects := []runtime.Object{
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            fnConfigName,
+				Namespace:       fnConfigNamespace,
+				ResourceVersion: resourceVersion,
+			},
+			Data: map[string]string{
+				"dockerRegistry":     strings.TrimPrefix(server.URL, "http://"),
+				"serviceAccountName": "build-bot",
+				"retainImages":       retainImages,
+			},
+		},
+	}
+	for _, namespace := range namespaces {
+		objects = append(objects,
+			&corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{Name: "build-bot", Namespace: namespace},
+				Secrets:    []corev1.ObjectReference{{Name: "push-credentials"}},
+			},
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "push-credentials",
+					Namespace:   namespace,
+					Annotations: map[string]string{"build.knative.dev/docker-0": server.URL},
+				},
+				Type: corev1.SecretTypeBasicAuth,
+				Data: map[string][]byte{
+					corev1.BasicAuthUsernameKey: []byte("push"),
+					corev1.BasicAuthPasswordKey: []byte("secret"),
+				},
+			},
+		)
+	}
+	return objects
+}
+
+func TestFinalizeFunction(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	registry := &imageRegistry{}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	sourcesTag, depsTag := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	depKey := types.NamespacedName{Name: "hello", Namespace: "default"}
+
+	tests := []struct {
+		name         string
+		retainImages string
+		failing      bool
+		deleted      time.Duration
+		requeue      bool
+		tags         []string
+		event        string
+	}{
+		{name: "images are deleted", deleted: time.Minute,
+			tags: []string{"debug"}, event: "Normal ImagesDeleted Deleted 2 images from " + host + "/default.hello"},
+		{name: "failures are retried", failing: true, deleted: time.Minute, requeue: true,
+			tags: []string{sourcesTag, depsTag, "debug"}, event: "Warning ImageCleanupFailed Unable to delete the images from " + host + "/default.hello, retrying"},
+		{name: "failures don't block the deletion for longer than the timeout", failing: true, deleted: 10 * time.Minute,
+			tags: []string{sourcesTag, depsTag, "debug"}, event: "Warning ImageCleanupFailed Unable to delete the images from " + host + "/default.hello, they are left behind"},
+		{name: "retained images are kept", retainImages: "true", deleted: time.Minute,
+			tags: []string{sourcesTag, depsTag, "debug"}},
+	}
+
+	for i, test := range tests {
+		registry.tags = map[string]map[string]string{
+			"default.hello": {sourcesTag: "sha256:1", depsTag: "sha256:2", "debug": "sha256:3"},
+		}
+		registry.failing, registry.requests = test.failing, 0
+
+		deletionTimestamp := metav1.NewTime(time.Now().Add(-test.deleted))
+		fn := &runtimev1alpha1.Function{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              depKey.Name,
+				Namespace:         depKey.Namespace,
+				DeletionTimestamp: &deletionTimestamp,
+				Finalizers:        []string{imageCleanupFinalizer},
+			},
+			Status: runtimev1alpha1.FunctionStatus{
+				Condition: runtimev1alpha1.FunctionConditionRunning,
+				Images:    []string{host + "/default.hello:" + sourcesTag, host + "/default.hello:" + depsTag},
+			},
+		}
+
+		c := fake.NewFakeClient(append(imageCleanupObjects(server, "image-cleanup-"+strconv.Itoa(i), test.retainImages, "default"), fn)...)
+		recorder := record.NewFakeRecorder(10)
+		reconcileFunction := &ReconcileFunction{Client: c, scheme: scheme.Scheme, recorder: recorder, registry: &runtimeUtil.RegistryClient{}}
+
+		result, err := reconcileFunction.Reconcile(reconcile.Request{NamespacedName: depKey})
+		g.Expect(err).NotTo(gomega.HaveOccurred(), test.name)
+		if test.requeue {
+			g.Expect(result).To(gomega.Equal(reconcile.Result{RequeueAfter: imageCleanupRetryInterval}), test.name)
+		} else {
+			g.Expect(result).To(gomega.Equal(reconcile.Result{}), test.name)
+		}
+
+		found := &runtimev1alpha1.Function{}
+		g.Expect(c.Get(context.TODO(), depKey, found)).To(gomega.Succeed(), test.name)
+		g.Expect(hasFinalizer(found, imageCleanupFinalizer)).To(gomega.Equal(test.requeue), test.name)
+
+		tags := []string{}
+		for tag := range registry.tags["default.hello"] {
+			tags = append(tags, tag)
+		}
+		g.Expect(tags).To(gomega.ConsistOf(test.tags), test.name)
+
+		if test.event != "" {
+			g.Expect(recorder.Events).To(gomega.Receive(gomega.HavePrefix(test.event)), test.name)
+		} else {
+			g.Expect(recorder.Events).NotTo(gomega.Receive(), test.name)
+			g.Expect(registry.requests).To(gomega.BeZero(), test.name)
+		}
+	}
+}
+
+// Test that only the images built for a Function are deleted, not the ones of Functions whose namespace and name
+// joined with a dash are the same, nor other tags of their manifests
+func TestFinalizeFunctionOwnImages(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	registry := &imageRegistry{}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	sourcesTag, otherTag, releasedTag := strings.Repeat("a", 64), strings.Repeat("b", 64), strings.Repeat("c", 64)
+	deletionTimestamp := metav1.NewTime(time.Now())
+	fn := &runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "b-c",
+			Namespace:         "a",
+			DeletionTimestamp: &deletionTimestamp,
+			Finalizers:        []string{imageCleanupFinalizer},
+		},
+	}
+	other := &runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "c", Namespace: "a-b", Finalizers: []string{imageCleanupFinalizer}},
+	}
+	repository, otherRepository := runtimeUtil.ImageRepository(host, fn), runtimeUtil.ImageRepository(host, other)
+	g.Expect(repository).NotTo(gomega.Equal(otherRepository))
+	fn.Status.Images = []string{repository + ":" + sourcesTag, repository + ":" + releasedTag}
+	other.Status.Images = []string{otherRepository + ":" + otherTag}
+
+	_, name := runtimeUtil.ParseRepository(repository)
+	_, otherName := runtimeUtil.ParseRepository(otherRepository)
+	registry.tags = map[string]map[string]string{
+		name:      {sourcesTag: "sha256:1", releasedTag: "sha256:2", "release": "sha256:2"},
+		otherName: {otherTag: "sha256:3"},
+	}
+
+	c := fake.NewFakeClient(append(imageCleanupObjects(server, "image-cleanup-own", "", "a", "a-b"), fn, other)...)
+	recorder := record.NewFakeRecorder(10)
+	reconcileFunction := &ReconcileFunction{Client: c, scheme: scheme.Scheme, recorder: recorder, registry: &runtimeUtil.RegistryClient{}}
+
+	_, err := reconcileFunction.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: fn.Name, Namespace: fn.Namespace}})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(recorder.Events).To(gomega.Receive(gomega.Equal("Normal ImagesDeleted Deleted 1 images from " + repository)))
+
+	// the manifest tagged by hand is kept, the images of the other Function too
+	g.Expect(registry.tags[name]).To(gomega.Equal(map[string]string{releasedTag: "sha256:2", "release": "sha256:2"}))
+	g.Expect(registry.tags[otherName]).To(gomega.Equal(map[string]string{otherTag: "sha256:3"}))
+}
+
+func TestUpdateImageCleanupFinalizer(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	tests := []struct {
+		name       string
+		rnInfo     *runtimeUtil.RuntimeInfo
+		finalizers []string
+		expected   []string
+	}{
+		{name: "images are cleaned up", rnInfo: &runtimeUtil.RuntimeInfo{RegistryInfo: "registry.example.com"},
+			finalizers: []string{"other"}, expected: []string{"other", imageCleanupFinalizer}},
+		{name: "images are retained", rnInfo: &runtimeUtil.RuntimeInfo{RegistryInfo: "registry.example.com", RetainImages: true},
+			finalizers: []string{imageCleanupFinalizer, "other"}, expected: []string{"other"}},
+		{name: "images on Docker Hub can't be deleted", rnInfo: &runtimeUtil.RuntimeInfo{RegistryInfo: "myorg"},
+			finalizers: []string{imageCleanupFinalizer}, expected: []string{}},
+	}
+
+	for _, test := range tests {
+		fn := &runtimev1alpha1.Function{
+			ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default", Finalizers: test.finalizers},
+		}
+		c := fake.NewFakeClient(fn)
+		reconcileFunction := &ReconcileFunction{Client: c, scheme: scheme.Scheme}
+
+		g.Expect(reconcileFunction.updateImageCleanupFinalizer(test.rnInfo, fn)).To(gomega.Succeed(), test.name)
+
+		found := &runtimev1alpha1.Function{}
+		g.Expect(c.Get(context.TODO(), types.NamespacedName{Name: "hello", Namespace: "default"}, found)).To(gomega.Succeed(), test.name)
+		g.Expect(found.Finalizers).To(gomega.ConsistOf(test.expected), test.name)
+	}
+}
@@ -0,0 +1,252 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	buildv1alpha1 "github.com/knative/build/pkg/apis/build/v1alpha1"
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	"github.com/kyma-incubator/runtime/pkg/metrics"
+	runtimeUtil "github.com/kyma-incubator/runtime/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// interval functions check whether the ClusterBuildTemplate of their runtime got created
+	buildTemplatePollInterval = 5 * time.Second
+
+	// interval functions check whether the Build of their image succeeded
+	buildPollInterval = 10 * time.Second
+
+	// interval functions check whether their revision became ready
+	readyPollInterval = 15 * time.Second
+)
+
+// phaseState is the outcome of a phase of the reconcile of a Function
+type phaseState int
+
+const (
+	// the phase reached its desired state, the next phase runs
+	phaseDone phaseState = iota
+	// the phase waits for something to happen, the Function is reconciled again after the requeue or with the next event
+	phaseInProgress
+	// the phase failed, errors are retried with a backoff while failures of the Function wait for it to change
+	phaseFailed
+)
+
+// phaseResult is the outcome of a phase and when the Function is reconciled again
+type phaseResult struct {
+	state        phaseState
+	requeueAfter time.Duration
+	err          error
+}
+
+func phaseDoneResult() phaseResult {
+	return phaseResult{state: phaseDone}
+}
+
+// phaseInProgressResult waits for the requeue, zero waits for the next event of the Function
+func phaseInProgressResult(requeueAfter time.Duration) phaseResult {
+	return phaseResult{state: phaseInProgress, requeueAfter: requeueAfter}
+}
+
+// phaseFailedResult fails the Function, nil errors are failures of the Function which aren't retried
+func phaseFailedResult(err error) phaseResult {
+	return phaseResult{state: phaseFailed, err: err}
+}
+
+// functionReconcile is the state the phases of a reconcile of a Function share
+type functionReconcile struct {
+	fn     *runtimev1alpha1.Function
+	rnInfo *runtimeUtil.RuntimeInfo
+
+	// ConfigMap holding the sources of the Function, and the image and the Build derived from them
+	configMap *corev1.ConfigMap
+	imageName string
+	buildName string
+}
+
+// functionPhase is a phase of the reconcile of a Function. Phases are idempotent, they run on every reconcile.
+type functionPhase struct {
+	name runtimev1alpha1.FunctionPhase
+
+	// step labels the errors of the phase in the metrics
+	step string
+
+	run func(r *ReconcileFunction, fr *functionReconcile) phaseResult
+}
+
+// functionPhases are the phases of the reconcile of a Function in order. The Knative Service is deployed once the
+// image got pushed, the Ready phase waits for its revision.
+var functionPhases = []functionPhase{
+	{name: runtimev1alpha1.FunctionPhaseConfigMap, step: metrics.StepConfigMap, run: (*ReconcileFunction).reconcileConfigMap},
+	{name: runtimev1alpha1.FunctionPhaseBuildTemplate, step: metrics.StepTemplate, run: (*ReconcileFunction).reconcileBuildTemplate},
+	{name: runtimev1alpha1.FunctionPhaseBuild, step: metrics.StepBuild, run: (*ReconcileFunction).reconcileBuild},
+	{name: runtimev1alpha1.FunctionPhaseDeploy, step: metrics.StepServe, run: (*ReconcileFunction).reconcileDeploy},
+	{name: runtimev1alpha1.FunctionPhaseReady, step: metrics.StepReady, run: (*ReconcileFunction).reconcileReady},
+}
+
+// runPhases runs the phases of a Function in order until one of them isn't done. The phase the Function stopped at
+// is set in its status, failed phases set the condition Error.
+func (r *ReconcileFunction) runPhases(phases []functionPhase, fr *functionReconcile) (reconcile.Result, error) {
+	fn := fr.fn
+
+	for _, phase := range phases {
+		fn.Status.Phase = phase.name
+		result := phase.run(r, fr)
+		switch result.state {
+		case phaseInProgress:
+			log.Info("Function phase in progress", "namespace", fn.Namespace, "name", fn.Name, "phase", phase.name, "requeueAfter", result.requeueAfter.String())
+			return reconcile.Result{RequeueAfter: result.requeueAfter}, nil
+		case phaseFailed:
+			if result.err != nil {
+				log.Error(result.err, "Function phase failed", "namespace", fn.Namespace, "name", fn.Name, "phase", phase.name)
+				metrics.ReconcileError(phase.step)
+			}
+			fn.Status.Condition = runtimev1alpha1.FunctionConditionError
+			return reconcile.Result{}, result.err
+		}
+	}
+
+	return reconcile.Result{}, nil
+}
+
+// reconcileConfigMap creates or updates the ConfigMap holding the sources of the Function. The name of the image and
+// of the Build of the Function are derived from the sources.
+func (r *ReconcileFunction) reconcileConfigMap(fr *functionReconcile) phaseResult {
+	fn := fr.fn
+
+	// Create Function's ConfigMap
+	foundCm := &corev1.ConfigMap{}
+	deployCm := &corev1.ConfigMap{}
+	if _, err := r.createFunctionConfigMap(foundCm, deployCm, fn); err != nil {
+		if errors.IsNotFound(err) {
+			return phaseInProgressResult(0)
+		}
+		log.Error(err, "function configmap can't be created. The function could have been deleted.", "namespace", deployCm.Namespace, "name", deployCm.Name)
+		return phaseFailedResult(err)
+	}
+
+	// Update Function's ConfigMap
+	if err := r.updateFunctionConfigMap(fn, foundCm, deployCm); err != nil {
+		log.Error(err, "Error while trying to update Function's ConfigMap:", "namespace", deployCm.Namespace, "name", deployCm.Name)
+		return phaseFailedResult(err)
+	}
+	fr.configMap = foundCm
+
+	// Create function's image name
+	hash := sha256.New()
+	hash.Write([]byte(foundCm.Data["handler.js"] + foundCm.Data["package.json"] + foundCm.Data["package-lock.json"]))
+	functionSha := fmt.Sprintf("%x", hash.Sum(nil))
+	fr.imageName = fmt.Sprintf("%s:%s", runtimeUtil.ImageRepository(fr.rnInfo.RegistryInfo, fn), functionSha)
+	log.Info("function image", "namespace:", fn.Namespace, "name:", fn.Name, "imageName:", fr.imageName)
+
+	// Unique Build name based on the image, Functions are rebuilt once their sources or their image repository change
+	hash = sha256.New()
+	hash.Write([]byte(fr.imageName))
+	fr.buildName = fmt.Sprintf("%s-%s", fn.Name, fmt.Sprintf("%x", hash.Sum(nil))[0:10])
+
+	return phaseDoneResult()
+}
+
+// reconcileBuildTemplate waits for the ClusterBuildTemplate of the Function's runtime, it is created by the build
+// template controller
+func (r *ReconcileFunction) reconcileBuildTemplate(fr *functionReconcile) phaseResult {
+	name := fr.rnInfo.BuildTemplateName(fr.fn.Spec.Runtime)
+	if err := r.getFunctionBuildTemplate(name); err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("Waiting for the Knative ClusterBuildTemplate", "name", name)
+			return phaseInProgressResult(buildTemplatePollInterval)
+		}
+		return phaseFailedResult(err)
+	}
+
+	return phaseDoneResult()
+}
+
+// reconcileBuild starts the Build of the image of the Function once the queue admits it and waits for it to succeed.
+// Builds failed with a transient error are retried after their backoff, the Function fails once its Build failed for good.
+func (r *ReconcileFunction) reconcileBuild(fr *functionReconcile) phaseResult {
+	fn := fr.fn
+
+	if retryAfter, err := r.retryBuild(fr.rnInfo, fn, fr.buildName); err != nil {
+		return phaseFailedResult(err)
+	} else if retryAfter > 0 {
+		return phaseInProgressResult(retryAfter)
+	}
+
+	if err := r.buildFunctionImage(fr.rnInfo, fn, fr.imageName, fr.buildName); err != nil {
+		if err == errBuildQueued {
+			return phaseInProgressResult(buildQueuePollInterval)
+		}
+		return phaseFailedResult(err)
+	}
+
+	r.getBuildStatus(fn, fr.buildName)
+	if fn.Status.Build != nil && fn.Status.Build.FailedStep != "" {
+		fn.Status.Routes = getRoutesStatus(fn, false)
+		return phaseFailedResult(nil)
+	}
+
+	build := &buildv1alpha1.Build{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Name: fr.buildName, Namespace: fn.Namespace}, build); err != nil {
+		if errors.IsNotFound(err) {
+			return phaseInProgressResult(buildPollInterval)
+		}
+		return phaseFailedResult(err)
+	}
+	// a stale Build of another image is being replaced
+	if !buildSucceeded(build) || !compareBuildImages(build, fr.imageName) {
+		return phaseInProgressResult(buildPollInterval)
+	}
+	recordImage(fn, fr.imageName)
+
+	return phaseDoneResult()
+}
+
+// reconcileDeploy creates or updates the service accounts, the Knative Service and the routes of the Function
+func (r *ReconcileFunction) reconcileDeploy(fr *functionReconcile) phaseResult {
+	if err := r.runtimeServiceAccount(fr.rnInfo, fr.fn.Namespace); err != nil {
+		return phaseFailedResult(err)
+	}
+
+	if err := r.functionServiceAccount(fr.rnInfo, fr.fn); err != nil {
+		return phaseFailedResult(err)
+	}
+
+	if err := r.serveFunction(fr.rnInfo, fr.configMap, fr.fn, fr.imageName); err != nil {
+		return phaseFailedResult(err)
+	}
+
+	if err := r.routeFunction(fr.rnInfo, fr.fn); err != nil {
+		return phaseFailedResult(err)
+	}
+
+	return phaseDoneResult()
+}
+
+// reconcileReady waits for the revision of the Function to become ready
+func (r *ReconcileFunction) reconcileReady(fr *functionReconcile) phaseResult {
+	return r.getFunctionCondition(fr.rnInfo, fr.fn)
+}
@@ -0,0 +1,396 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	buildv1alpha1 "github.com/knative/build/pkg/apis/build/v1alpha1"
+	"github.com/knative/pkg/apis"
+	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
+	duckv1beta1 "github.com/knative/pkg/apis/duck/v1beta1"
+	istiov1alpha3 "github.com/knative/pkg/apis/istio/v1alpha3"
+	servingv1alpha1 "github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	runtimeUtil "github.com/kyma-incubator/runtime/pkg/utils"
+	"github.com/onsi/gomega"
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// phaseFunction returns a Function of the phase tests
+func phaseFunction() *runtimev1alpha1.Function {
+	return &runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"},
+		Spec:       runtimev1alpha1.FunctionSpec{Function: "main() {}", Runtime: "nodejs8"},
+		Status:     runtimev1alpha1.FunctionStatus{Condition: runtimev1alpha1.FunctionConditionUnknown},
+	}
+}
+
+func TestRunPhases(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	phaseNames := []runtimev1alpha1.FunctionPhase{
+		runtimev1alpha1.FunctionPhaseConfigMap,
+		runtimev1alpha1.FunctionPhaseBuild,
+		runtimev1alpha1.FunctionPhaseReady,
+	}
+	errPhase := fmt.Errorf("phase failed")
+
+	tests := []struct {
+		name      string
+		results   []phaseResult
+		ran       int
+		result    reconcile.Result
+		err       error
+		phase     runtimev1alpha1.FunctionPhase
+		condition runtimev1alpha1.FunctionCondition
+	}{
+		{
+			name:      "all phases are done",
+			results:   []phaseResult{phaseDoneResult(), phaseDoneResult(), phaseDoneResult()},
+			ran:       3,
+			phase:     runtimev1alpha1.FunctionPhaseReady,
+			condition: runtimev1alpha1.FunctionConditionRunning,
+		},
+		{
+			name:      "a phase in progress requeues",
+			results:   []phaseResult{phaseDoneResult(), phaseInProgressResult(time.Minute), phaseDoneResult()},
+			ran:       2,
+			result:    reconcile.Result{RequeueAfter: time.Minute},
+			phase:     runtimev1alpha1.FunctionPhaseBuild,
+			condition: runtimev1alpha1.FunctionConditionBuilding,
+		},
+		{
+			name:      "a phase in progress waits for the next event",
+			results:   []phaseResult{phaseInProgressResult(0), phaseDoneResult(), phaseDoneResult()},
+			ran:       1,
+			phase:     runtimev1alpha1.FunctionPhaseConfigMap,
+			condition: runtimev1alpha1.FunctionConditionUnknown,
+		},
+		{
+			name:      "a phase failing with an error is retried",
+			results:   []phaseResult{phaseDoneResult(), phaseFailedResult(errPhase), phaseDoneResult()},
+			ran:       2,
+			err:       errPhase,
+			phase:     runtimev1alpha1.FunctionPhaseBuild,
+			condition: runtimev1alpha1.FunctionConditionError,
+		},
+		{
+			name:      "a failed Function waits for its next change",
+			results:   []phaseResult{phaseDoneResult(), phaseDoneResult(), phaseFailedResult(nil)},
+			ran:       3,
+			phase:     runtimev1alpha1.FunctionPhaseReady,
+			condition: runtimev1alpha1.FunctionConditionError,
+		},
+	}
+
+	for _, test := range tests {
+		fn := phaseFunction()
+		reconcileFunction := &ReconcileFunction{scheme: scheme.Scheme}
+
+		// the phases set the condition of the Build phase and of the Ready phase
+		ran := 0
+		phases := []functionPhase{}
+		for i, name := range phaseNames {
+			i := i
+			phases = append(phases, functionPhase{name: name, step: string(name), run: func(r *ReconcileFunction, fr *functionReconcile) phaseResult {
+				ran++
+				switch i {
+				case 1:
+					fr.fn.Status.Condition = runtimev1alpha1.FunctionConditionBuilding
+				case 2:
+					fr.fn.Status.Condition = runtimev1alpha1.FunctionConditionRunning
+				}
+				return test.results[i]
+			}})
+		}
+
+		result, err := reconcileFunction.runPhases(phases, &functionReconcile{fn: fn})
+		if test.err != nil {
+			g.Expect(err).To(gomega.Equal(test.err), test.name)
+		} else {
+			g.Expect(err).NotTo(gomega.HaveOccurred(), test.name)
+		}
+		g.Expect(result).To(gomega.Equal(test.result), test.name)
+		g.Expect(ran).To(gomega.Equal(test.ran), test.name)
+
+		// the phase and the condition are applied by the reconcile once all phases ran
+		g.Expect(fn.Status.Phase).To(gomega.Equal(test.phase), test.name)
+		g.Expect(fn.Status.Condition).To(gomega.Equal(test.condition), test.name)
+	}
+}
+
+func TestReconcileBuildTemplate(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	rnInfo := &runtimeUtil.RuntimeInfo{}
+	buildTemplate := &buildv1alpha1.ClusterBuildTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: rnInfo.BuildTemplateName("nodejs8")},
+	}
+
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		result  phaseResult
+	}{
+		{name: "the ClusterBuildTemplate is missing", result: phaseInProgressResult(buildTemplatePollInterval)},
+		{name: "the ClusterBuildTemplate exists", objects: []runtime.Object{buildTemplate}, result: phaseDoneResult()},
+	}
+
+	for _, test := range tests {
+		reconcileFunction := &ReconcileFunction{Client: fake.NewFakeClient(test.objects...), scheme: scheme.Scheme}
+		result := reconcileFunction.reconcileBuildTemplate(&functionReconcile{fn: phaseFunction(), rnInfo: rnInfo})
+		g.Expect(result).To(gomega.Equal(test.result), test.name)
+	}
+}
+
+func TestReconcileBuild(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	rnInfo := &runtimeUtil.RuntimeInfo{MaxConcurrentBuilds: 1, MaxBuildRetries: 2}
+	imageName, buildName := "registry.example.com/default.hello:0123456789", "hello-0123456789"
+
+	// build returns the Build of the Function with a status
+	build := func(status buildv1alpha1.BuildStatus) *buildv1alpha1.Build {
+		b := runtimeUtil.GetBuildResource(rnInfo, phaseFunction(), imageName, buildName)
+		b.Status = status
+		return b
+	}
+	// otherBuild is a Build of another Function which didn't finish yet
+	otherBuild := &buildv1alpha1.Build{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "other-0123456789",
+			Namespace: "default",
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: runtimev1alpha1.SchemeGroupVersion.String(),
+				Kind:       "Function",
+				Name:       "other",
+				Controller: func() *bool { b := true; return &b }(),
+			}},
+		},
+	}
+	// staleBuild is a succeeded Build of the image the Function had in another registry
+	staleBuild := runtimeUtil.GetBuildResource(rnInfo, phaseFunction(), "other.example.com/default.hello:0123456789", buildName)
+	staleBuild.Status = buildv1alpha1.BuildStatus{Status: duckv1alpha1.Status{Conditions: []duckv1alpha1.Condition{
+		{Type: duckv1alpha1.ConditionSucceeded, Status: corev1.ConditionTrue},
+	}}}
+	transientFailure := failedBuildStatus("503 Service Unavailable", 1, "Error")
+	transientFailure.CompletionTime = &metav1.Time{Time: time.Now()}
+
+	succeeded := buildv1alpha1.BuildStatus{Status: duckv1alpha1.Status{Conditions: []duckv1alpha1.Condition{
+		{Type: duckv1alpha1.ConditionSucceeded, Status: corev1.ConditionTrue},
+	}}}
+
+	tests := []struct {
+		name         string
+		objects      []runtime.Object
+		state        phaseState
+		requeueAfter time.Duration
+		retry        bool
+		condition    runtimev1alpha1.FunctionCondition
+		started      bool
+		replaced     bool
+	}{
+		{name: "the Build is started", state: phaseInProgress, requeueAfter: buildPollInterval,
+			condition: runtimev1alpha1.FunctionConditionBuilding, started: true},
+		{name: "a running Build is in progress", objects: []runtime.Object{build(buildv1alpha1.BuildStatus{})}, state: phaseInProgress,
+			requeueAfter: buildPollInterval, condition: runtimev1alpha1.FunctionConditionUnknown},
+		{name: "a succeeded Build is done", objects: []runtime.Object{build(succeeded)}, state: phaseDone,
+			condition: runtimev1alpha1.FunctionConditionUnknown},
+		{name: "the Build waits in the queue", objects: []runtime.Object{otherBuild}, state: phaseInProgress,
+			requeueAfter: buildQueuePollInterval, condition: runtimev1alpha1.FunctionConditionBuildQueued},
+		{name: "a Build failed with a transient error waits for its retry", objects: []runtime.Object{build(transientFailure)}, state: phaseInProgress,
+			retry: true, condition: runtimev1alpha1.FunctionConditionBuilding},
+		{name: "a Build failed for good fails the Function", objects: []runtime.Object{build(failedBuildStatus("UNAUTHORIZED", 1, "Error"))}, state: phaseFailed,
+			condition: runtimev1alpha1.FunctionConditionUnknown},
+		{name: "a stale Build of another image is replaced", objects: []runtime.Object{staleBuild}, state: phaseInProgress,
+			requeueAfter: buildPollInterval, condition: runtimev1alpha1.FunctionConditionUpdating, replaced: true},
+	}
+
+	for _, test := range tests {
+		fn := phaseFunction()
+		recorder := record.NewFakeRecorder(10)
+		reconcileFunction := &ReconcileFunction{
+			Client:     fake.NewFakeClient(append(test.objects, fn)...),
+			scheme:     scheme.Scheme,
+			recorder:   recorder,
+			buildQueue: newBuildQueue(),
+		}
+
+		result := reconcileFunction.reconcileBuild(&functionReconcile{fn: fn, rnInfo: rnInfo, imageName: imageName, buildName: buildName})
+		g.Expect(result.state).To(gomega.Equal(test.state), test.name)
+		g.Expect(result.err).NotTo(gomega.HaveOccurred(), test.name)
+		g.Expect(fn.Status.Condition).To(gomega.Equal(test.condition), test.name)
+
+		switch {
+		case test.retry:
+			g.Expect(result.requeueAfter).To(gomega.BeNumerically("~", buildRetryBackoff, 5*time.Second), test.name)
+		case test.state == phaseInProgress:
+			g.Expect(result.requeueAfter).To(gomega.Equal(test.requeueAfter), test.name)
+		case test.state == phaseFailed:
+			g.Expect(fn.Status.Build.FailedStep).NotTo(gomega.BeEmpty(), test.name)
+		}
+		if test.condition == runtimev1alpha1.FunctionConditionBuildQueued {
+			g.Expect(fn.Status.Build.QueuePosition).To(gomega.Equal(int32(1)), test.name)
+		}
+
+		if test.started {
+			g.Expect(recorder.Events).To(gomega.Receive(gomega.HavePrefix("Normal BuildStarted ")), test.name)
+			g.Expect(reconcileFunction.Get(context.TODO(), types.NamespacedName{Name: buildName, Namespace: fn.Namespace}, &buildv1alpha1.Build{})).To(gomega.Succeed(), test.name)
+		}
+		if test.replaced {
+			err := reconcileFunction.Get(context.TODO(), types.NamespacedName{Name: buildName, Namespace: fn.Namespace}, &buildv1alpha1.Build{})
+			g.Expect(errors.IsNotFound(err)).To(gomega.BeTrue(), test.name)
+
+			// the Build of the image is started once the stale one is gone
+			result = reconcileFunction.reconcileBuild(&functionReconcile{fn: fn, rnInfo: rnInfo, imageName: imageName, buildName: buildName})
+			g.Expect(result.state).To(gomega.Equal(phaseInProgress), test.name)
+			g.Expect(recorder.Events).To(gomega.Receive(gomega.HavePrefix("Normal BuildStarted ")), test.name)
+			newBuild := &buildv1alpha1.Build{}
+			g.Expect(reconcileFunction.Get(context.TODO(), types.NamespacedName{Name: buildName, Namespace: fn.Namespace}, newBuild)).To(gomega.Succeed(), test.name)
+			g.Expect(compareBuildImages(newBuild, imageName)).To(gomega.BeTrue(), test.name)
+		}
+	}
+}
+
+func TestReconcileReady(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	// service returns the Knative Service of the Function with its conditions
+	service := func(status corev1.ConditionStatus) *servingv1alpha1.Service {
+		return &servingv1alpha1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"},
+			Status: servingv1alpha1.ServiceStatus{
+				ConfigurationStatusFields: servingv1alpha1.ConfigurationStatusFields{
+					LatestCreatedRevisionName: "hello-00001",
+					LatestReadyRevisionName:   "hello-00001",
+				},
+				Status: duckv1beta1.Status{
+					Conditions: []apis.Condition{
+						{Type: servingv1alpha1.ServiceConditionReady, Status: status},
+						{Type: servingv1alpha1.RouteConditionReady, Status: status},
+						{Type: servingv1alpha1.ConfigurationConditionReady, Status: status},
+					},
+				},
+			},
+		}
+	}
+	failedBuild := &buildv1alpha1.Build{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"},
+		Status:     failedBuildStatus("UNAUTHORIZED", 1, "Error"),
+	}
+
+	tests := []struct {
+		name      string
+		objects   []runtime.Object
+		result    phaseResult
+		condition runtimev1alpha1.FunctionCondition
+		event     string
+	}{
+		{name: "the Knative Service isn't created yet", result: phaseInProgressResult(readyPollInterval),
+			condition: runtimev1alpha1.FunctionConditionUnknown},
+		{name: "the revision isn't ready", objects: []runtime.Object{service(corev1.ConditionFalse)}, result: phaseInProgressResult(readyPollInterval),
+			condition: runtimev1alpha1.FunctionConditionDeploying},
+		{name: "the revision is ready", objects: []runtime.Object{service(corev1.ConditionTrue)}, result: phaseDoneResult(),
+			condition: runtimev1alpha1.FunctionConditionRunning, event: "Normal RevisionReady Revision hello-00001 is ready"},
+		{name: "the Build failed", objects: []runtime.Object{failedBuild, service(corev1.ConditionFalse)}, result: phaseFailedResult(nil),
+			condition: runtimev1alpha1.FunctionConditionError},
+	}
+
+	for _, test := range tests {
+		fn := phaseFunction()
+		recorder := record.NewFakeRecorder(10)
+		reconcileFunction := &ReconcileFunction{Client: fake.NewFakeClient(test.objects...), scheme: scheme.Scheme, recorder: recorder}
+
+		result := reconcileFunction.reconcileReady(&functionReconcile{fn: fn})
+		g.Expect(result).To(gomega.Equal(test.result), test.name)
+		g.Expect(fn.Status.Condition).To(gomega.Equal(test.condition), test.name)
+		if test.event != "" {
+			g.Expect(recorder.Events).To(gomega.Receive(gomega.Equal(test.event)), test.name)
+		} else {
+			g.Expect(recorder.Events).NotTo(gomega.Receive(), test.name)
+		}
+	}
+}
+
+// Test that the routes of a Function are only ready while its VirtualService matches them
+func TestReconcileReadyRoutes(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	fn := phaseFunction()
+	fn.UID = "hello-uid"
+	fn.Spec.Routes = []runtimev1alpha1.FunctionRoute{{Host: "hello.example.com", Path: "/api"}}
+	rnInfo := &runtimeUtil.RuntimeInfo{}
+	readyService := &servingv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"},
+		Status: servingv1alpha1.ServiceStatus{
+			ConfigurationStatusFields: servingv1alpha1.ConfigurationStatusFields{
+				LatestCreatedRevisionName: "hello-00001",
+				LatestReadyRevisionName:   "hello-00001",
+			},
+			Status: duckv1beta1.Status{
+				Conditions: []apis.Condition{
+					{Type: servingv1alpha1.ServiceConditionReady, Status: corev1.ConditionTrue},
+					{Type: servingv1alpha1.RouteConditionReady, Status: corev1.ConditionTrue},
+					{Type: servingv1alpha1.ConfigurationConditionReady, Status: corev1.ConditionTrue},
+				},
+			},
+		},
+	}
+
+	c := fake.NewFakeClient(readyService)
+	reconcileFunction := &ReconcileFunction{Client: c, scheme: scheme.Scheme, recorder: record.NewFakeRecorder(10)}
+	routeReady := func() bool {
+		g.Expect(reconcileFunction.reconcileReady(&functionReconcile{fn: fn, rnInfo: rnInfo})).To(gomega.Equal(phaseDoneResult()))
+		g.Expect(fn.Status.Routes).To(gomega.HaveLen(1))
+		return fn.Status.Routes[0].Ready
+	}
+
+	// the Knative route is ready but the VirtualService is missing
+	g.Expect(routeReady()).To(gomega.BeFalse())
+
+	g.Expect(reconcileFunction.routeFunction(rnInfo, fn)).NotTo(gomega.HaveOccurred())
+	g.Expect(routeReady()).To(gomega.BeTrue())
+
+	// a drifted VirtualService isn't ready until the route is reconciled again
+	virtualService := &istiov1alpha3.VirtualService{}
+	key := types.NamespacedName{Name: "hello", Namespace: "default"}
+	g.Expect(c.Get(context.TODO(), key, virtualService)).NotTo(gomega.HaveOccurred())
+	virtualService.Spec.Hosts = []string{"other.example.com"}
+	g.Expect(c.Update(context.TODO(), virtualService)).NotTo(gomega.HaveOccurred())
+	g.Expect(routeReady()).To(gomega.BeFalse())
+
+	g.Expect(reconcileFunction.routeFunction(rnInfo, fn)).NotTo(gomega.HaveOccurred())
+	g.Expect(routeReady()).To(gomega.BeTrue())
+
+	// a matching VirtualService which isn't owned by the Function doesn't route it
+	g.Expect(c.Get(context.TODO(), key, virtualService)).NotTo(gomega.HaveOccurred())
+	virtualService.OwnerReferences = nil
+	g.Expect(c.Update(context.TODO(), virtualService)).NotTo(gomega.HaveOccurred())
+	g.Expect(routeReady()).To(gomega.BeFalse())
+}
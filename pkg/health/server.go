@@ -0,0 +1,102 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+var log = logf.Log.WithName("health")
+
+// duration given to open probes when the server shuts down
+const shutdownTimeout = 5 * time.Second
+
+// Server serves the liveness probe on GET /healthz and the readiness probe on GET /readyz. The manager is live as
+// long as it serves, it is ready once the manager serving the webhooks synced its caches.
+type Server struct {
+	addr  string
+	ready int32
+}
+
+var _ manager.Runnable = &Server{}
+
+// New returns a Server listening on addr, which isn't ready until a manager started its ReadinessCheck
+func New(addr string) *Server {
+	return &Server{addr: addr}
+}
+
+// ReadinessCheck returns a Runnable which marks the Server ready while the manager it is added to runs. Managers start
+// their Runnables once their caches synced.
+func (s *Server) ReadinessCheck() manager.Runnable {
+	return manager.RunnableFunc(func(stop <-chan struct{}) error {
+		atomic.StoreInt32(&s.ready, 1)
+		log.Info("Manager is ready")
+		<-stop
+		atomic.StoreInt32(&s.ready, 0)
+		return nil
+	})
+}
+
+// Start serves the probes until stop is closed
+func (s *Server) Start(stop <-chan struct{}) error {
+	server := &http.Server{Addr: s.addr, Handler: s}
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Info("Serving health probes", "addr", s.addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			errs <- err
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-stop:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
+
+// ServeHTTP answers the liveness and the readiness probes
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch req.URL.Path {
+	case "/healthz":
+		w.Write([]byte("ok"))
+	case "/readyz":
+		if atomic.LoadInt32(&s.ready) == 0 {
+			http.Error(w, "caches not synced", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	default:
+		http.NotFound(w, req)
+	}
+}
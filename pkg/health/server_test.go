@@ -0,0 +1,56 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/onsi/gomega"
+)
+
+func TestServeHTTP(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	server := New(":0")
+	probe := func(method, path string) func() int {
+		return func() int {
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+			return rec.Code
+		}
+	}
+
+	// the manager is live but not ready until its caches synced
+	g.Expect(probe(http.MethodGet, "/healthz")()).To(gomega.Equal(http.StatusOK))
+	g.Expect(probe(http.MethodGet, "/readyz")()).To(gomega.Equal(http.StatusServiceUnavailable))
+
+	stop := make(chan struct{})
+	stopped := make(chan error)
+	go func() { stopped <- server.ReadinessCheck().Start(stop) }()
+	g.Eventually(probe(http.MethodGet, "/readyz")).Should(gomega.Equal(http.StatusOK))
+	g.Expect(probe(http.MethodGet, "/healthz")()).To(gomega.Equal(http.StatusOK))
+
+	g.Expect(probe(http.MethodGet, "/metrics")()).To(gomega.Equal(http.StatusNotFound))
+	g.Expect(probe(http.MethodPost, "/readyz")()).To(gomega.Equal(http.StatusMethodNotAllowed))
+
+	// the manager isn't ready anymore once it stopped
+	close(stop)
+	g.Expect(<-stopped).NotTo(gomega.HaveOccurred())
+	g.Expect(probe(http.MethodGet, "/readyz")()).To(gomega.Equal(http.StatusServiceUnavailable))
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leader
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+	leaderlock "sigs.k8s.io/controller-runtime/pkg/leaderelection"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+var log = logf.Log.WithName("leader")
+
+// timings of the leader election, the defaults of controller-runtime managers
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// Manager starts the Runnables added to it only once the replica is elected the leader, while the manager it wraps
+// runs its cache and its other Runnables on every replica. Managers with leader election don't start anything, not
+// even the webhook server, until they lead, so the replicas share a manager and only its controllers wait.
+type Manager struct {
+	manager.Manager
+	lock    resourcelock.Interface
+	elected chan struct{}
+}
+
+// New returns a Manager adding Runnables to mgr. Without leader election every replica leads.
+func New(mgr manager.Manager, options leaderlock.Options) (*Manager, error) {
+	lock, err := leaderlock.NewResourceLock(mgr.GetConfig(), recorderProvider{mgr}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{Manager: mgr, lock: lock, elected: make(chan struct{})}
+	if lock == nil {
+		close(m.elected)
+		return m, nil
+	}
+	return m, mgr.Add(manager.RunnableFunc(m.elect))
+}
+
+// Add sets the fields of r and starts it with the manager once the replica leads
+func (m *Manager) Add(r manager.Runnable) error {
+	if err := m.Manager.SetFields(r); err != nil {
+		return err
+	}
+
+	return m.Manager.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		select {
+		case <-m.elected:
+		case <-stop:
+			return nil
+		}
+		return r.Start(stop)
+	}))
+}
+
+// elect campaigns for the lock until stop is closed. Losing the lock is an error, the replica has to restart as the
+// Runnables of the leader can't be stopped on their own.
+func (m *Manager) elect(stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          m.lock,
+		LeaseDuration: leaseDuration,
+		RenewDeadline: renewDeadline,
+		RetryPeriod:   retryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				log.Info("Elected leader", "identity", m.lock.Identity())
+				close(m.elected)
+			},
+			OnStoppedLeading: func() {
+				log.Info("Stopped leading", "identity", m.lock.Identity())
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info("Campaigning for leader", "lock", m.lock.Describe())
+	elector.Run(ctx)
+
+	select {
+	case <-stop:
+		return nil
+	default:
+		return errors.New("leader election lost")
+	}
+}
+
+// recorderProvider records the leader election events with the recorders of a manager
+type recorderProvider struct {
+	manager.Manager
+}
+
+func (p recorderProvider) GetEventRecorderFor(name string) record.EventRecorder {
+	return p.GetRecorder(name)
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leader
+
+import (
+	"testing"
+
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// fakeManager records the Runnables added to it instead of starting them
+type fakeManager struct {
+	manager.Manager
+	fields    []interface{}
+	runnables []manager.Runnable
+}
+
+func (f *fakeManager) SetFields(i interface{}) error {
+	f.fields = append(f.fields, i)
+	return nil
+}
+
+func (f *fakeManager) Add(r manager.Runnable) error {
+	f.runnables = append(f.runnables, r)
+	return nil
+}
+
+// Test that the Runnables of the leader only start once the replica is elected
+func TestAdd(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	fake := &fakeManager{}
+	m := &Manager{Manager: fake, elected: make(chan struct{})}
+
+	started := make(chan struct{})
+	controller := manager.RunnableFunc(func(stop <-chan struct{}) error {
+		close(started)
+		<-stop
+		return nil
+	})
+	g.Expect(m.Add(controller)).NotTo(gomega.HaveOccurred())
+	g.Expect(fake.fields).To(gomega.HaveLen(1))
+	g.Expect(fake.runnables).To(gomega.HaveLen(1))
+
+	// the manager starts the Runnable on every replica, it waits for the election
+	stop := make(chan struct{})
+	stopped := make(chan error)
+	go func() { stopped <- fake.runnables[0].Start(stop) }()
+	g.Consistently(started).ShouldNot(gomega.BeClosed())
+
+	close(m.elected)
+	g.Eventually(started).Should(gomega.BeClosed())
+
+	close(stop)
+	g.Eventually(stopped).Should(gomega.Receive(gomega.BeNil()))
+}
+
+// Test that Runnables of replicas which never lead stop without starting
+func TestAddNotElected(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	fake := &fakeManager{}
+	m := &Manager{Manager: fake, elected: make(chan struct{})}
+
+	started := false
+	g.Expect(m.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		started = true
+		return nil
+	}))).NotTo(gomega.HaveOccurred())
+
+	stop := make(chan struct{})
+	close(stop)
+	g.Expect(fake.runnables[0].Start(stop)).NotTo(gomega.HaveOccurred())
+	g.Expect(started).To(gomega.BeFalse())
+}
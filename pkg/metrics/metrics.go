@@ -0,0 +1,242 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics holds the Prometheus metrics of the Function controller and webhooks. They are registered with the
+// registry of controller-runtime and served by the manager on --metrics-addr.
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	buildv1alpha1 "github.com/knative/build/pkg/apis/build/v1alpha1"
+	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	admissiontypes "sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+// steps of the reconcile of a Function which can fail
+const (
+	StepConfig    = "config"
+	StepConfigMap = "configmap"
+	StepTemplate  = "template"
+	StepBuild     = "build"
+	StepServe     = "serve"
+	StepReady     = "ready"
+	StepFinalizer = "finalizer"
+)
+
+// outcomes of the Builds of Functions
+const (
+	BuildSucceeded = "succeeded"
+	BuildFailed    = "failed"
+	BuildTimedOut  = "timeout"
+)
+
+// reason of the condition of Knative Builds which ran out of time
+const buildTimeoutReason = "BuildTimeout"
+
+var (
+	functions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "runtime_functions",
+		Help: "Number of Functions by namespace, runtime and condition.",
+	}, []string{"namespace", "runtime", "condition"})
+
+	buildDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "runtime_function_build_duration_seconds",
+		Help:    "Duration of the Builds of Functions by runtime and outcome.",
+		Buckets: []float64{15, 30, 60, 120, 300, 600, 1200, 1800, 3600},
+	}, []string{"runtime", "outcome"})
+
+	readyDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "runtime_function_ready_duration_seconds",
+		Help:    "Time from a change of the spec of a Function until it is Running.",
+		Buckets: []float64{5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600},
+	})
+
+	reconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "runtime_function_reconcile_errors_total",
+		Help: "Number of reconciles of Functions which failed by step.",
+	}, []string{"step"})
+
+	admissionDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "runtime_function_admission_decisions_total",
+		Help: "Number of admission requests of Functions by webhook, operation and decision.",
+	}, []string{"webhook", "operation", "decision"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(functions, buildDuration, readyDuration, reconcileErrors, admissionDecisions)
+}
+
+// started is when the controller started, Builds and Functions finished before were observed by its predecessor
+var started = time.Now()
+
+// functionState is what the metrics know about a Function
+type functionState struct {
+	uid       types.UID
+	runtime   string
+	condition string
+
+	// generation of the spec seen last, when it was seen first and whether it was Running since
+	generation int64
+	changed    time.Time
+	running    bool
+
+	// Build observed last
+	build string
+}
+
+var (
+	mu     sync.Mutex
+	states = map[types.NamespacedName]*functionState{}
+	counts = map[[3]string]int{}
+)
+
+// ObserveFunction records the condition of a Function after its status was updated. The time until a change of the
+// spec is Running is observed once per generation.
+func ObserveFunction(fn *runtimev1alpha1.Function) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	key := types.NamespacedName{Namespace: fn.Namespace, Name: fn.Name}
+	state, ok := states[key]
+	if ok && state.uid == fn.UID {
+		count(key.Namespace, state.runtime, state.condition, -1)
+	} else {
+		if ok {
+			count(key.Namespace, state.runtime, state.condition, -1)
+		}
+		state = &functionState{uid: fn.UID, generation: fn.Generation, changed: now}
+		if fn.Generation <= 1 && fn.CreationTimestamp.After(started) {
+			state.changed = fn.CreationTimestamp.Time
+		}
+		// a Function seen Running first may have been Running long before the controller started
+		state.running = fn.Status.Condition == runtimev1alpha1.FunctionConditionRunning
+		states[key] = state
+	}
+	if fn.Generation != state.generation {
+		state.generation, state.changed, state.running = fn.Generation, now, false
+	}
+
+	state.runtime, state.condition = fn.Spec.Runtime, string(fn.Status.Condition)
+	count(key.Namespace, state.runtime, state.condition, 1)
+
+	if fn.Status.Condition == runtimev1alpha1.FunctionConditionRunning && !state.running {
+		readyDuration.Observe(now.Sub(state.changed).Seconds())
+		state.running = true
+	}
+}
+
+// FunctionDeleted forgets a deleted Function
+func FunctionDeleted(key types.NamespacedName) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if state, ok := states[key]; ok {
+		count(key.Namespace, state.runtime, state.condition, -1)
+		delete(states, key)
+	}
+}
+
+// count changes the number of Functions with a runtime and condition, the series is removed once there are none
+func count(namespace, runtime, condition string, delta int) {
+	labels := [3]string{namespace, runtime, condition}
+	counts[labels] += delta
+	if counts[labels] > 0 {
+		functions.WithLabelValues(namespace, runtime, condition).Set(float64(counts[labels]))
+		return
+	}
+	delete(counts, labels)
+	functions.DeleteLabelValues(namespace, runtime, condition)
+}
+
+// ObserveBuild records the duration and the outcome of the Build of a Function once it finished
+func ObserveBuild(fn *runtimev1alpha1.Function, build *buildv1alpha1.Build) {
+	outcome := buildOutcome(build)
+	if outcome == "" {
+		return
+	}
+
+	completed := time.Now()
+	if build.Status.CompletionTime != nil {
+		completed = build.Status.CompletionTime.Time
+	}
+	if completed.Before(started) {
+		return
+	}
+	start := build.CreationTimestamp.Time
+	if build.Status.StartTime != nil {
+		start = build.Status.StartTime.Time
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	key := types.NamespacedName{Namespace: fn.Namespace, Name: fn.Name}
+	state, ok := states[key]
+	if !ok || state.uid != fn.UID {
+		// the Function is observed once its status is updated
+		return
+	}
+	if state.build == build.Name {
+		return
+	}
+	state.build = build.Name
+
+	buildDuration.WithLabelValues(fn.Spec.Runtime, outcome).Observe(completed.Sub(start).Seconds())
+}
+
+// buildOutcome returns the outcome of a Build, empty while it runs
+func buildOutcome(build *buildv1alpha1.Build) string {
+	for _, condition := range build.Status.Conditions {
+		if condition.Type != duckv1alpha1.ConditionSucceeded {
+			continue
+		}
+		switch {
+		case condition.Status == corev1.ConditionTrue:
+			return BuildSucceeded
+		case condition.Status == corev1.ConditionFalse && condition.Reason == buildTimeoutReason:
+			return BuildTimedOut
+		case condition.Status == corev1.ConditionFalse:
+			return BuildFailed
+		}
+	}
+	return ""
+}
+
+// ReconcileError counts a reconcile of a Function which failed at a step
+func ReconcileError(step string) {
+	reconcileErrors.WithLabelValues(step).Inc()
+}
+
+// AdmissionDecision counts the decision of a webhook on an admission request of a Function
+func AdmissionDecision(webhook string, req admissiontypes.Request, resp admissiontypes.Response) {
+	operation := ""
+	if req.AdmissionRequest != nil {
+		operation = string(req.AdmissionRequest.Operation)
+	}
+	decision := "denied"
+	if resp.Response != nil && resp.Response.Allowed {
+		decision = "allowed"
+	}
+	admissionDecisions.WithLabelValues(webhook, operation, decision).Inc()
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	buildv1alpha1 "github.com/knative/build/pkg/apis/build/v1alpha1"
+	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	"github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	admissiontypes "sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+// scrape returns the samples served by the metrics endpoint of the manager by series
+func scrape(g *gomega.GomegaWithT) map[string]float64 {
+	rec := httptest.NewRecorder()
+	promhttp.HandlerFor(ctrlmetrics.Registry, promhttp.HandlerOpts{}).
+		ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	g.Expect(rec.Code).To(gomega.Equal(http.StatusOK))
+
+	samples := map[string]float64{}
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		g.Expect(err).NotTo(gomega.HaveOccurred(), line)
+		samples[line[:i]] = value
+	}
+	return samples
+}
+
+func TestObserveFunction(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	fn := &runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "hello",
+			Namespace:         "metrics-functions",
+			UID:               "1",
+			Generation:        1,
+			CreationTimestamp: metav1.Now(),
+		},
+		Spec: runtimev1alpha1.FunctionSpec{Runtime: "nodejs8"},
+	}
+	series := func(condition runtimev1alpha1.FunctionCondition) string {
+		return fmt.Sprintf(`runtime_functions{condition="%s",namespace="metrics-functions",runtime="nodejs8"}`, condition)
+	}
+	observe := func(generation int64, condition runtimev1alpha1.FunctionCondition) {
+		fn.Generation, fn.Status.Condition = generation, condition
+		ObserveFunction(fn)
+	}
+	readyCount := `runtime_function_ready_duration_seconds_count`
+	ready := scrape(g)[readyCount]
+
+	// Functions are counted by their condition
+	observe(1, runtimev1alpha1.FunctionConditionUnknown)
+	g.Expect(scrape(g)).To(gomega.HaveKeyWithValue(series(runtimev1alpha1.FunctionConditionUnknown), 1.0))
+	observe(1, runtimev1alpha1.FunctionConditionBuilding)
+	samples := scrape(g)
+	g.Expect(samples).NotTo(gomega.HaveKey(series(runtimev1alpha1.FunctionConditionUnknown)))
+	g.Expect(samples).To(gomega.HaveKeyWithValue(series(runtimev1alpha1.FunctionConditionBuilding), 1.0))
+
+	// the time until a spec is Running is observed once
+	observe(1, runtimev1alpha1.FunctionConditionRunning)
+	g.Expect(scrape(g)).To(gomega.HaveKeyWithValue(readyCount, ready+1))
+	observe(1, runtimev1alpha1.FunctionConditionRunning)
+	g.Expect(scrape(g)).To(gomega.HaveKeyWithValue(readyCount, ready+1))
+
+	// and again once a changed spec is Running
+	observe(2, runtimev1alpha1.FunctionConditionDeploying)
+	observe(2, runtimev1alpha1.FunctionConditionRunning)
+	samples = scrape(g)
+	g.Expect(samples).To(gomega.HaveKeyWithValue(readyCount, ready+2))
+	g.Expect(samples).To(gomega.HaveKeyWithValue(series(runtimev1alpha1.FunctionConditionRunning), 1.0))
+
+	// deleted Functions aren't counted anymore
+	FunctionDeleted(types.NamespacedName{Name: "hello", Namespace: "metrics-functions"})
+	g.Expect(scrape(g)).NotTo(gomega.HaveKey(series(runtimev1alpha1.FunctionConditionRunning)))
+
+	// Functions Running when seen first may have been Running since long before the controller started
+	fn.UID, fn.CreationTimestamp = "2", metav1.NewTime(started.Add(-time.Hour))
+	observe(5, runtimev1alpha1.FunctionConditionRunning)
+	samples = scrape(g)
+	g.Expect(samples).To(gomega.HaveKeyWithValue(readyCount, ready+2))
+	g.Expect(samples).To(gomega.HaveKeyWithValue(series(runtimev1alpha1.FunctionConditionRunning), 1.0))
+}
+
+func TestObserveBuild(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	fn := &runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "metrics-builds", UID: "1", Generation: 1},
+		Spec:       runtimev1alpha1.FunctionSpec{Runtime: "metrics-builds"},
+		Status:     runtimev1alpha1.FunctionStatus{Condition: runtimev1alpha1.FunctionConditionBuilding},
+	}
+	build := func(name string, status corev1.ConditionStatus, reason string, completed time.Time) *buildv1alpha1.Build {
+		build := &buildv1alpha1.Build{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "metrics-builds"}}
+		build.Status.StartTime = &metav1.Time{Time: completed.Add(-90 * time.Second)}
+		build.Status.CompletionTime = &metav1.Time{Time: completed}
+		build.Status.Conditions = duckv1alpha1.Conditions{{Type: duckv1alpha1.ConditionSucceeded, Status: status, Reason: reason}}
+		return build
+	}
+	series := func(outcome string) string {
+		return fmt.Sprintf(`runtime_function_build_duration_seconds_count{outcome="%s",runtime="metrics-builds"}`, outcome)
+	}
+
+	// Builds of Functions not observed yet are observed later on
+	ObserveBuild(fn, build("hello-1", corev1.ConditionTrue, "", time.Now()))
+	g.Expect(scrape(g)).NotTo(gomega.HaveKey(series(BuildSucceeded)))
+	ObserveFunction(fn)
+
+	// finished Builds are observed once by their outcome
+	ObserveBuild(fn, build("hello-1", corev1.ConditionTrue, "", time.Now()))
+	ObserveBuild(fn, build("hello-1", corev1.ConditionTrue, "", time.Now()))
+	samples := scrape(g)
+	g.Expect(samples).To(gomega.HaveKeyWithValue(series(BuildSucceeded), 1.0))
+	g.Expect(samples).To(gomega.HaveKeyWithValue(
+		`runtime_function_build_duration_seconds_sum{outcome="succeeded",runtime="metrics-builds"}`, 90.0,
+	))
+	g.Expect(samples).To(gomega.HaveKeyWithValue(
+		`runtime_function_build_duration_seconds_bucket{outcome="succeeded",runtime="metrics-builds",le="60"}`, 0.0,
+	))
+	g.Expect(samples).To(gomega.HaveKeyWithValue(
+		`runtime_function_build_duration_seconds_bucket{outcome="succeeded",runtime="metrics-builds",le="120"}`, 1.0,
+	))
+
+	// running Builds aren't observed
+	ObserveBuild(fn, build("hello-2", corev1.ConditionUnknown, "", time.Now()))
+	ObserveBuild(fn, build("hello-2", corev1.ConditionFalse, "BuildTimeout", time.Now()))
+	ObserveBuild(fn, build("hello-3", corev1.ConditionFalse, "", time.Now()))
+	samples = scrape(g)
+	g.Expect(samples).To(gomega.HaveKeyWithValue(series(BuildTimedOut), 1.0))
+	g.Expect(samples).To(gomega.HaveKeyWithValue(series(BuildFailed), 1.0))
+
+	// Builds finished before the controller started were observed by its predecessor
+	ObserveBuild(fn, build("hello-4", corev1.ConditionFalse, "", started.Add(-time.Minute)))
+	g.Expect(scrape(g)).To(gomega.HaveKeyWithValue(series(BuildFailed), 1.0))
+}
+
+func TestReconcileError(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	series := `runtime_function_reconcile_errors_total{step="template"}`
+	errors := scrape(g)[series]
+
+	ReconcileError(StepTemplate)
+	ReconcileError(StepTemplate)
+	g.Expect(scrape(g)).To(gomega.HaveKeyWithValue(series, errors+2))
+}
+
+func TestAdmissionDecision(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	request := func(operation admissionv1beta1.Operation) admissiontypes.Request {
+		return admissiontypes.Request{AdmissionRequest: &admissionv1beta1.AdmissionRequest{Operation: operation}}
+	}
+	AdmissionDecision("metrics-webhook", request(admissionv1beta1.Create), admission.ValidationResponse(true, "allowed to be admitted"))
+	AdmissionDecision("metrics-webhook", request(admissionv1beta1.Update), admission.ValidationResponse(false, "route host must not be empty"))
+	AdmissionDecision("metrics-webhook", request(admissionv1beta1.Create), admission.ErrorResponse(http.StatusBadRequest, fmt.Errorf("invalid")))
+
+	samples := scrape(g)
+	g.Expect(samples).To(gomega.HaveKeyWithValue(
+		`runtime_function_admission_decisions_total{decision="allowed",operation="CREATE",webhook="metrics-webhook"}`, 1.0,
+	))
+	g.Expect(samples).To(gomega.HaveKeyWithValue(
+		`runtime_function_admission_decisions_total{decision="denied",operation="UPDATE",webhook="metrics-webhook"}`, 1.0,
+	))
+	g.Expect(samples).To(gomega.HaveKeyWithValue(
+		`runtime_function_admission_decisions_total{decision="denied",operation="CREATE",webhook="metrics-webhook"}`, 1.0,
+	))
+}
@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	buildv1alpha1 "github.com/knative/build/pkg/apis/build/v1alpha1"
@@ -12,12 +15,96 @@ import (
 
 var buildTimeout = os.Getenv("BUILD_TIMEOUT")
 
+// DefaultBuildTemplateName is the name of the ClusterBuildTemplate shared by the builds of all runtimes
+var DefaultBuildTemplateName = getEnvDefault("BUILD_TEMPLATE", "function-kaniko")
+
+const defaultBuildTimeout = 30 * time.Minute
+
 var defaultMode = int32(420)
 
+// BuildAndPushStep is the container of the build and push step in the pods of builds
+const BuildAndPushStep = "build-step-build-and-push"
+
+const (
+	// NpmrcKey is the key of the .npmrc in the Secrets referenced by functions
+	NpmrcKey = ".npmrc"
+
+	// volume holding the .npmrc, it is mounted into the build step only and kaniko doesn't snapshot mounted paths
+	npmrcVolumeName = "npmrc"
+	npmrcMountPath  = "/npmrc"
+)
+
+var npmrcMode = int32(256)
+
+var defaultBuildCache = "true"
+
+// kaniko derives the cache repository from the image name unless the builds pass one
+var defaultBuildCacheRepository = ""
+
+func getEnvDefault(envName string, defaultValue string) string {
+	if value := os.Getenv(envName); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// ParseBuildTimeout parses a build timeout given as a number of minutes e.g. 20 or as a duration e.g. 1h30m
+func ParseBuildTimeout(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+
+	var timeout time.Duration
+	if minutes, err := strconv.Atoi(value); err == nil {
+		timeout = time.Duration(minutes) * time.Minute
+	} else if timeout, err = time.ParseDuration(value); err != nil {
+		return 0, fmt.Errorf("invalid build timeout '%s', expected a number of minutes e.g. 20 or a duration e.g. 1h30m", value)
+	}
+
+	if timeout <= 0 {
+		return 0, fmt.Errorf("build timeout '%s' must be positive", value)
+	}
+	return timeout, nil
+}
+
+// DefaultBuildTimeout returns the build timeout set by the BUILD_TIMEOUT environment variable, 30 minutes if it is unset
+func DefaultBuildTimeout() (time.Duration, error) {
+	if strings.TrimSpace(buildTimeout) == "" {
+		return defaultBuildTimeout, nil
+	}
+	return ParseBuildTimeout(buildTimeout)
+}
+
+// BuildTimeout returns the timeout of the builds of a function, it is capped by the maximum build timeout
+func (ri *RuntimeInfo) BuildTimeout(fn *runtimev1alpha1.Function) time.Duration {
+	// invalid values of BUILD_TIMEOUT are rejected at startup
+	timeout, err := DefaultBuildTimeout()
+	if err != nil {
+		timeout = defaultBuildTimeout
+	}
+
+	if fn.Spec.Build != nil && fn.Spec.Build.Timeout != nil {
+		timeout = fn.Spec.Build.Timeout.Duration
+	}
+
+	if ri.MaxBuildTimeout > 0 && timeout > ri.MaxBuildTimeout {
+		timeout = ri.MaxBuildTimeout
+	}
+	return timeout
+}
+
+// BuildCacheEnabled checks whether the builds of a function use the layer cache
+func BuildCacheEnabled(fn *runtimev1alpha1.Function) bool {
+	return fn.Spec.Build == nil || !fn.Spec.Build.DisableCache
+}
+
 func GetBuildResource(rnInfo *RuntimeInfo, fn *runtimev1alpha1.Function, imageName string, buildName string) *buildv1alpha1.Build {
 
 	args := []buildv1alpha1.ArgumentSpec{}
 	args = append(args, buildv1alpha1.ArgumentSpec{Name: "IMAGE", Value: imageName})
+	args = append(args, buildv1alpha1.ArgumentSpec{Name: "CACHE", Value: strconv.FormatBool(BuildCacheEnabled(fn))})
+	if rnInfo.BuildCacheRepository != "" {
+		// the cache repository may be overridden per namespace, the shared build template only declares it
+		args = append(args, buildv1alpha1.ArgumentSpec{Name: "CACHE_REPO", Value: rnInfo.BuildCacheRepository})
+	}
 
 	for _, rt := range rnInfo.AvailableRuntimes {
 		if rt.ID == fn.Spec.Runtime {
@@ -27,11 +114,6 @@ func GetBuildResource(rnInfo *RuntimeInfo, fn *runtimev1alpha1.Function, imageNa
 
 	envs := []corev1.EnvVar{}
 
-	timeout, err := time.ParseDuration(buildTimeout)
-	if err != nil {
-		timeout = 30 * time.Minute
-	}
-
 	vols := []corev1.Volume{
 		{
 			Name: "source",
@@ -44,8 +126,11 @@ func GetBuildResource(rnInfo *RuntimeInfo, fn *runtimev1alpha1.Function, imageNa
 				},
 			},
 		},
+		npmrcVolume(rnInfo, fn),
 	}
 
+	settings := rnInfo.BuildSettings(fn.Spec.Runtime)
+
 	b := buildv1alpha1.Build{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "build.knative.dev/v1alpha1",
@@ -57,25 +142,85 @@ func GetBuildResource(rnInfo *RuntimeInfo, fn *runtimev1alpha1.Function, imageNa
 			Labels:    fn.Labels,
 		},
 		Spec: buildv1alpha1.BuildSpec{
-			ServiceAccountName: rnInfo.ServiceAccount,
+			ServiceAccountName: rnInfo.BuildServiceAccount,
 			Template: &buildv1alpha1.TemplateInstantiationSpec{
-				Name:      "function-kaniko",
-				Kind:      buildv1alpha1.BuildTemplateKind,
+				Name:      rnInfo.BuildTemplateName(fn.Spec.Runtime),
+				Kind:      buildv1alpha1.ClusterBuildTemplateKind,
 				Arguments: args,
 				Env:       envs,
 			},
-			Volumes: vols,
+			Volumes:      vols,
+			NodeSelector: settings.NodeSelector,
+			Affinity:     settings.Affinity,
 		},
 	}
 
 	if b.Spec.Timeout == nil {
-		b.Spec.Timeout = &metav1.Duration{Duration: timeout}
+		b.Spec.Timeout = &metav1.Duration{Duration: rnInfo.BuildTimeout(fn)}
 	}
 
 	return &b
 }
 
-func GetBuildTemplateSpec(fn *runtimev1alpha1.Function) buildv1alpha1.BuildTemplateSpec {
+// NpmrcSecret returns the Secret holding the .npmrc of the builds of a function and whether it may be missing. The Secret
+// referenced by the function replaces the configured one, which is optional.
+func (ri *RuntimeInfo) NpmrcSecret(fn *runtimev1alpha1.Function) (string, bool) {
+	if fn.Spec.Build != nil && fn.Spec.Build.NpmrcSecretRef != nil && fn.Spec.Build.NpmrcSecretRef.Name != "" {
+		return fn.Spec.Build.NpmrcSecretRef.Name, false
+	}
+	return ri.NpmrcSecretName, true
+}
+
+// npmrcVolume returns the volume of the .npmrc used to install the dependencies of a function. The Secret referenced
+// by the function has to exist, the default Secret of the configuration is optional. The volume is empty without both.
+func npmrcVolume(rnInfo *RuntimeInfo, fn *runtimev1alpha1.Function) corev1.Volume {
+	volume := corev1.Volume{
+		Name: npmrcVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	}
+
+	secretName, optional := rnInfo.NpmrcSecret(fn)
+	if secretName == "" {
+		return volume
+	}
+
+	volume.VolumeSource = corev1.VolumeSource{
+		Secret: &corev1.SecretVolumeSource{
+			SecretName:  secretName,
+			Items:       []corev1.KeyToPath{{Key: NpmrcKey, Path: NpmrcKey}},
+			DefaultMode: &npmrcMode,
+			Optional:    &optional,
+		},
+	}
+	return volume
+}
+
+// GetBuildTemplateSpec returns the spec of the ClusterBuildTemplate of a runtime, the runtime is empty for the
+// template shared by all runtimes
+func GetBuildTemplateSpec(rnInfo *RuntimeInfo, runtime string) buildv1alpha1.BuildTemplateSpec {
+
+	// one volume per Dockerfile ConfigMap referenced by the available runtimes
+	volumes := []corev1.Volume{}
+	dockerFileNames := []string{}
+	for _, rt := range rnInfo.AvailableRuntimes {
+		if rt.DockerFileName == "" || containsString(dockerFileNames, rt.DockerFileName) {
+			continue
+		}
+		dockerFileNames = append(dockerFileNames, rt.DockerFileName)
+		volumes = append(volumes, corev1.Volume{
+			Name: rt.DockerFileName,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					DefaultMode: &defaultMode,
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: rt.DockerFileName,
+					},
+				},
+			},
+		})
+	}
 
 	parameters := []buildv1alpha1.ParameterSpec{
 		{
@@ -84,19 +229,36 @@ func GetBuildTemplateSpec(fn *runtimev1alpha1.Function) buildv1alpha1.BuildTempl
 		},
 		{
 			Name:        "DOCKERFILE",
-			Description: "name of the configmap that contains the Dockerfile",
+			Description: fmt.Sprintf("name of the configmap that contains the Dockerfile, one of '%s'", strings.Join(dockerFileNames, ",")),
 		},
 	}
 
+	parameters = append(parameters, buildv1alpha1.ParameterSpec{
+		Name:        "CACHE",
+		Description: "Whether the layers of the build are cached, true or false",
+		Default:     &defaultBuildCache,
+	}, buildv1alpha1.ParameterSpec{
+		Name:        "CACHE_REPO",
+		Description: "The repository the cached layers are pushed to, derived from the image by default",
+		Default:     &defaultBuildCacheRepository,
+	})
+
 	destination := "--destination=${IMAGE}"
+	args := []string{
+		"--dockerfile=/workspace/Dockerfile",
+		destination,
+		"--context=/src",
+		"--cache=${CACHE}",
+		"--cache-repo=${CACHE_REPO}",
+	}
+
 	steps := []corev1.Container{
 		{
 			Name:  "build-and-push",
 			Image: "gcr.io/kaniko-project/executor",
-			Args: []string{
-				"--dockerfile=/workspace/Dockerfile",
-				destination,
-			},
+			Args:  args,
+			// the files of the source are mounted one by one as ConfigMap volumes contain symlinks, which
+			// kaniko would copy into the image instead of their content
 			VolumeMounts: []corev1.VolumeMount{
 				{
 					Name:      "${DOCKERFILE}",
@@ -104,37 +266,32 @@ func GetBuildTemplateSpec(fn *runtimev1alpha1.Function) buildv1alpha1.BuildTempl
 				},
 				{
 					Name:      "source",
-					MountPath: "/src",
+					MountPath: "/src/handler.js",
+					SubPath:   "handler.js",
 				},
-			},
-		},
-	}
-
-	volumes := []corev1.Volume{
-		{
-			Name: "dockerfile-nodejs-6",
-			VolumeSource: corev1.VolumeSource{
-				ConfigMap: &corev1.ConfigMapVolumeSource{
-					DefaultMode: &defaultMode,
-					LocalObjectReference: corev1.LocalObjectReference{
-						Name: "dockerfile-nodejs-6",
-					},
+				{
+					Name:      "source",
+					MountPath: "/src/package.json",
+					SubPath:   "package.json",
 				},
-			},
-		},
-		{
-			Name: "dockerfile-nodejs-8",
-			VolumeSource: corev1.VolumeSource{
-				ConfigMap: &corev1.ConfigMapVolumeSource{
-					DefaultMode: &defaultMode,
-					LocalObjectReference: corev1.LocalObjectReference{
-						Name: "dockerfile-nodejs-8",
-					},
+				{
+					Name:      "source",
+					MountPath: "/src/package-lock.json",
+					SubPath:   "package-lock.json",
+				},
+				{
+					Name:      npmrcVolumeName,
+					MountPath: npmrcMountPath,
+					ReadOnly:  true,
 				},
 			},
 		},
 	}
 
+	if resources := rnInfo.BuildSettings(runtime).Resources; resources != nil {
+		steps[0].Resources = *resources
+	}
+
 	bt := buildv1alpha1.BuildTemplateSpec{
 		Parameters: parameters,
 		Steps:      steps,
@@ -0,0 +1,267 @@
+package utils_test
+
+import (
+	"testing"
+	"time"
+
+	buildv1alpha1 "github.com/knative/build/pkg/apis/build/v1alpha1"
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	"github.com/kyma-incubator/runtime/pkg/utils"
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetBuildTemplateSpec(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	rnInfo := &utils.RuntimeInfo{
+		AvailableRuntimes: []utils.RuntimesSupported{
+			{ID: "nodejs6", DockerFileName: "dockerfile-nodejs-6"},
+			{ID: "nodejs8", DockerFileName: "dockerfile-nodejs-8"},
+			{ID: "nodejs8-alpine", DockerFileName: "dockerfile-nodejs-8"},
+			{ID: "nodejs10", DockerFileName: "dockerfile-nodejs-10"},
+		},
+	}
+
+	bt := utils.GetBuildTemplateSpec(rnInfo, "")
+
+	// one volume per Dockerfile, in the order of the runtimes
+	g.Expect(bt.Volumes).To(gomega.HaveLen(3))
+	for i, name := range []string{"dockerfile-nodejs-6", "dockerfile-nodejs-8", "dockerfile-nodejs-10"} {
+		g.Expect(bt.Volumes[i].Name).To(gomega.Equal(name))
+		g.Expect(bt.Volumes[i].ConfigMap).NotTo(gomega.BeNil())
+		g.Expect(bt.Volumes[i].ConfigMap.Name).To(gomega.Equal(name))
+	}
+
+	g.Expect(bt.Parameters).To(gomega.HaveLen(4))
+	g.Expect(bt.Parameters[0].Name).To(gomega.Equal("IMAGE"))
+	g.Expect(bt.Parameters[1].Name).To(gomega.Equal("DOCKERFILE"))
+	g.Expect(bt.Parameters[1].Description).To(gomega.ContainSubstring("dockerfile-nodejs-6,dockerfile-nodejs-8,dockerfile-nodejs-10"))
+
+	// the Dockerfile volume is chosen by the DOCKERFILE parameter
+	g.Expect(bt.Steps).To(gomega.HaveLen(1))
+	g.Expect(bt.Steps[0].VolumeMounts[0].Name).To(gomega.Equal("${DOCKERFILE}"))
+
+	// adding a runtime changes the template
+	rnInfo.AvailableRuntimes = append(rnInfo.AvailableRuntimes, utils.RuntimesSupported{ID: "nodejs12", DockerFileName: "dockerfile-nodejs-12"})
+	g.Expect(utils.GetBuildTemplateSpec(rnInfo, "").Volumes).To(gomega.HaveLen(4))
+	g.Expect(utils.GetBuildTemplateSpec(rnInfo, "").Volumes[3].Name).To(gomega.Equal("dockerfile-nodejs-12"))
+}
+
+func TestGetBuildTemplateSpecCache(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	// kaniko derives the cache repository from the image name
+	bt := utils.GetBuildTemplateSpec(&utils.RuntimeInfo{}, "")
+	g.Expect(bt.Steps[0].Args).To(gomega.ContainElement("--cache=${CACHE}"))
+	g.Expect(bt.Steps[0].Args).To(gomega.ContainElement("--context=/src"))
+	g.Expect(bt.Steps[0].Args).To(gomega.ContainElement("--cache-repo=${CACHE_REPO}"))
+	parameter := func(p buildv1alpha1.ParameterSpec) string {
+		if p.Default == nil {
+			return p.Name
+		}
+		return p.Name + "=" + *p.Default
+	}
+	g.Expect(bt.Parameters).To(gomega.ContainElement(gomega.WithTransform(parameter, gomega.Equal("CACHE=true"))))
+	g.Expect(bt.Parameters).To(gomega.ContainElement(gomega.WithTransform(parameter, gomega.Equal("CACHE_REPO="))))
+
+	// the source files are real files in the build context
+	g.Expect(bt.Steps[0].VolumeMounts).To(gomega.ContainElement(corev1.VolumeMount{Name: "source", MountPath: "/src/package.json", SubPath: "package.json"}))
+	g.Expect(bt.Steps[0].VolumeMounts).To(gomega.ContainElement(corev1.VolumeMount{Name: "source", MountPath: "/src/handler.js", SubPath: "handler.js"}))
+	g.Expect(bt.Steps[0].VolumeMounts).To(gomega.ContainElement(corev1.VolumeMount{Name: "source", MountPath: "/src/package-lock.json", SubPath: "package-lock.json"}))
+
+	// the template doesn't depend on the cache repository, which may be overridden per namespace
+	g.Expect(utils.GetBuildTemplateSpec(&utils.RuntimeInfo{BuildCacheRepository: "registry.example.com/cache"}, "")).To(gomega.Equal(bt))
+}
+
+func TestGetBuildResourceCache(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	rnInfo := &utils.RuntimeInfo{
+		AvailableRuntimes: []utils.RuntimesSupported{
+			{ID: "nodejs8", DockerFileName: "dockerfile-nodejs-8"},
+		},
+	}
+	fn := &runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "bar"},
+		Spec:       runtimev1alpha1.FunctionSpec{Runtime: "nodejs8"},
+	}
+
+	arg := func(build *buildv1alpha1.Build, name string) string {
+		for _, arg := range build.Spec.Template.Arguments {
+			if arg.Name == name {
+				return arg.Value
+			}
+		}
+		return ""
+	}
+
+	// the cache is used by default, kaniko derives its repository from the image
+	build := utils.GetBuildResource(rnInfo, fn, "image", "foo-build")
+	g.Expect(arg(build, "CACHE")).To(gomega.Equal("true"))
+	g.Expect(arg(build, "CACHE_REPO")).To(gomega.BeEmpty())
+
+	// the cache repository of the configuration layered with the one of the namespace is passed to the build
+	rnInfo.BuildCacheRepository = "registry.team-a.example.com/cache"
+	g.Expect(arg(utils.GetBuildResource(rnInfo, fn, "image", "foo-build"), "CACHE_REPO")).To(gomega.Equal("registry.team-a.example.com/cache"))
+
+	// functions opt out of the cache
+	fn.Spec.Build = &runtimev1alpha1.FunctionBuildSpec{DisableCache: true}
+	g.Expect(arg(utils.GetBuildResource(rnInfo, fn, "image", "foo-build"), "CACHE")).To(gomega.Equal("false"))
+}
+
+func TestGetBuildResourceNpmrc(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	rnInfo := &utils.RuntimeInfo{
+		AvailableRuntimes: []utils.RuntimesSupported{
+			{ID: "nodejs8", DockerFileName: "dockerfile-nodejs-8"},
+		},
+	}
+	fn := &runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "bar"},
+		Spec:       runtimev1alpha1.FunctionSpec{Runtime: "nodejs8"},
+	}
+
+	npmrcVolume := func(build *buildv1alpha1.Build) corev1.Volume {
+		for _, volume := range build.Spec.Volumes {
+			if volume.Name == "npmrc" {
+				return volume
+			}
+		}
+		return corev1.Volume{}
+	}
+
+	// the build template always mounts the volume into the build step, it stays empty without .npmrc
+	bt := utils.GetBuildTemplateSpec(rnInfo, "")
+	g.Expect(bt.Steps[0].VolumeMounts).To(gomega.ContainElement(corev1.VolumeMount{Name: "npmrc", MountPath: "/npmrc", ReadOnly: true}))
+	for _, volume := range bt.Volumes {
+		g.Expect(volume.Secret).To(gomega.BeNil())
+	}
+	volume := npmrcVolume(utils.GetBuildResource(rnInfo, fn, "image", "foo-build"))
+	g.Expect(volume.EmptyDir).NotTo(gomega.BeNil())
+	g.Expect(volume.Secret).To(gomega.BeNil())
+
+	// the default of the configuration is optional as not every namespace has it
+	rnInfo.NpmrcSecretName = "default-npmrc"
+	volume = npmrcVolume(utils.GetBuildResource(rnInfo, fn, "image", "foo-build"))
+	g.Expect(volume.EmptyDir).To(gomega.BeNil())
+	g.Expect(volume.Secret).NotTo(gomega.BeNil())
+	g.Expect(volume.Secret.SecretName).To(gomega.Equal("default-npmrc"))
+	g.Expect(volume.Secret.Items).To(gomega.Equal([]corev1.KeyToPath{{Key: ".npmrc", Path: ".npmrc"}}))
+	g.Expect(*volume.Secret.Optional).To(gomega.BeTrue())
+
+	// the Secret of the function is required
+	fn.Spec.Build = &runtimev1alpha1.FunctionBuildSpec{NpmrcSecretRef: &corev1.LocalObjectReference{Name: "foo-npmrc"}}
+	build := utils.GetBuildResource(rnInfo, fn, "image", "foo-build")
+	volume = npmrcVolume(build)
+	g.Expect(volume.Secret.SecretName).To(gomega.Equal("foo-npmrc"))
+	g.Expect(*volume.Secret.Optional).To(gomega.BeFalse())
+
+	// the .npmrc never ends up in the source of the image
+	for _, volume := range build.Spec.Volumes {
+		if volume.Name == "source" {
+			g.Expect(volume.ConfigMap).NotTo(gomega.BeNil())
+			g.Expect(volume.ConfigMap.Name).To(gomega.Equal("foo"))
+		}
+	}
+	for _, mount := range bt.Steps[0].VolumeMounts {
+		if mount.Name == "npmrc" {
+			g.Expect(mount.MountPath).NotTo(gomega.HavePrefix("/src"))
+			g.Expect(mount.MountPath).NotTo(gomega.HavePrefix("/workspace"))
+		}
+	}
+}
+
+func TestParseBuildTimeout(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	tests := map[string]time.Duration{
+		"20":     20 * time.Minute,
+		" 45 ":   45 * time.Minute,
+		"90s":    90 * time.Second,
+		"1h30m":  90 * time.Minute,
+		"20m":    20 * time.Minute,
+		"0":      0,
+		"-5":     0,
+		"-5m":    0,
+		"twenty": 0,
+		"":       0,
+	}
+	for value, expected := range tests {
+		timeout, err := utils.ParseBuildTimeout(value)
+		if expected == 0 {
+			g.Expect(err).To(gomega.HaveOccurred(), value)
+			continue
+		}
+		g.Expect(err).NotTo(gomega.HaveOccurred(), value)
+		g.Expect(timeout).To(gomega.Equal(expected), value)
+	}
+}
+
+func TestBuildTimeout(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	fn := &runtimev1alpha1.Function{}
+	rnInfo := &utils.RuntimeInfo{}
+
+	// BUILD_TIMEOUT is not set in tests
+	g.Expect(rnInfo.BuildTimeout(fn)).To(gomega.Equal(30 * time.Minute))
+
+	fn.Spec.Build = &runtimev1alpha1.FunctionBuildSpec{Timeout: &metav1.Duration{Duration: 45 * time.Minute}}
+	g.Expect(rnInfo.BuildTimeout(fn)).To(gomega.Equal(45 * time.Minute))
+
+	// capped by the maximum build timeout
+	rnInfo.MaxBuildTimeout = 40 * time.Minute
+	g.Expect(rnInfo.BuildTimeout(fn)).To(gomega.Equal(40 * time.Minute))
+
+	build := utils.GetBuildResource(rnInfo, fn, "image", "foo-build")
+	g.Expect(build.Spec.Timeout.Duration).To(gomega.Equal(40 * time.Minute))
+}
+
+func TestBuildPodSettings(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	limits := corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2Gi")}
+	rnInfo := &utils.RuntimeInfo{
+		Build: utils.BuildPodSettings{
+			Resources:    &corev1.ResourceRequirements{Limits: limits},
+			NodeSelector: map[string]string{"pool": "builds"},
+		},
+		AvailableRuntimes: []utils.RuntimesSupported{
+			{ID: "nodejs6", DockerFileName: "dockerfile-nodejs-6"},
+			{
+				ID:             "nodejs8",
+				DockerFileName: "dockerfile-nodejs-8",
+				Build: &utils.BuildPodSettings{
+					Resources: &corev1.ResourceRequirements{
+						Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("4Gi")},
+					},
+					Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{}},
+				},
+			},
+		},
+	}
+
+	// resources are set on the build step of the templates
+	g.Expect(utils.GetBuildTemplateSpec(rnInfo, "").Steps[0].Resources.Limits).To(gomega.Equal(limits))
+	g.Expect(utils.GetBuildTemplateSpec(rnInfo, "nodejs8").Steps[0].Resources.Limits.Memory().String()).To(gomega.Equal("4Gi"))
+
+	// placement is set on the builds
+	fn := &runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "bar"},
+		Spec:       runtimev1alpha1.FunctionSpec{Runtime: "nodejs6"},
+	}
+	build := utils.GetBuildResource(rnInfo, fn, "image", "foo-build")
+	g.Expect(build.Spec.Template.Name).To(gomega.Equal("function-kaniko"))
+	g.Expect(build.Spec.NodeSelector).To(gomega.Equal(map[string]string{"pool": "builds"}))
+	g.Expect(build.Spec.Affinity).To(gomega.BeNil())
+
+	fn.Spec.Runtime = "nodejs8"
+	build = utils.GetBuildResource(rnInfo, fn, "image", "foo-build")
+	g.Expect(build.Spec.Template.Name).To(gomega.Equal("function-kaniko-nodejs8"))
+	g.Expect(build.Spec.NodeSelector).To(gomega.Equal(map[string]string{"pool": "builds"}))
+	g.Expect(build.Spec.Affinity).To(gomega.Equal(&corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{}}))
+}
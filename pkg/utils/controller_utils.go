@@ -2,8 +2,14 @@ package utils
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
 	"github.com/ghodss/yaml"
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 )
 
@@ -14,19 +20,127 @@ type Runtime interface {
 }
 
 type RuntimeInfo struct {
-	RegistryInfo      string
-	AvailableRuntimes []RuntimesSupported
-	ServiceAccount    string
+	RegistryInfo          string
+	AvailableRuntimes     []RuntimesSupported
+	BuildServiceAccount   string
+	RuntimeServiceAccount string
+	RegistryPullSecret    string
+	RouteGateway          string
+	RouteDestination      string
+	BuildCacheRepository  string
+	MaxBuildTimeout       time.Duration
+	Build                 BuildPodSettings
+
+	// maximum number of builds running at the same time, in total and per namespace, unlimited if 0
+	MaxConcurrentBuilds             int
+	MaxConcurrentBuildsPerNamespace int
+
+	// maximum number of retries of builds failed with a transient error
+	MaxBuildRetries int
+
+	// name of the Secret holding the .npmrc of builds in the namespace of a function, if it exists
+	NpmrcSecretName string
+
+	// namespace of the ConfigMap which set RegistryPullSecret, the pull secret is read from this namespace
+	RegistryPullSecretNamespace string
+
+	// whether the images of deleted functions are kept in the registry
+	RetainImages bool
+
+	// ConfigMaps the configuration is merged from as <namespace>/<name>, later ones override earlier ones
+	Sources []string
+}
+
+// BuildPodSettings defines the resources and the placement of the pods of builds
+type BuildPodSettings struct {
+	Resources    *corev1.ResourceRequirements `json:"resources,omitempty"`
+	NodeSelector map[string]string            `json:"nodeSelector,omitempty"`
+	Affinity     *corev1.Affinity             `json:"affinity,omitempty"`
+
+	// Knative Build doesn't support tolerations, they are only parsed to reject them
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+}
+
+const (
+	// retries of builds failed with a transient error unless configured
+	defaultMaxBuildRetries = 3
+
+	// istio gateway the VirtualServices of function routes are bound to
+	defaultRouteGateway = "knative-ingress-gateway.knative-serving.svc.cluster.local"
+
+	// istio ingress gateway forwarding function routes to the Knative route of a function
+	defaultRouteDestination = "istio-ingressgateway.istio-system.svc.cluster.local"
+
+	// health endpoint served by the kubeless runtimes
+	functionHealthPath = "/healthz"
+)
+
+// NamespaceConfigKeys are the keys a ConfigMap of a namespace may override. Everything else, e.g. the runtimes or
+// the limits of builds, is shared by all namespaces and only set by the configuration of the controller.
+var NamespaceConfigKeys = map[string]bool{
+	"dockerRegistry":            true,
+	"serviceAccountName":        true,
+	"buildServiceAccountName":   true,
+	"runtimeServiceAccountName": true,
+	"registryPullSecret":        true,
+	"buildCacheRepository":      true,
+	"npmrcSecretName":           true,
+	"retainImages":              true,
 }
 
 type RuntimesSupported struct {
-	ID             string `json:"ID"`
-	DockerFileName string `json:"DockerFileName"`
+	ID             string        `json:"ID"`
+	DockerFileName string        `json:"DockerFileName"`
+	LivenessProbe  *corev1.Probe `json:"livenessProbe,omitempty"`
+	ReadinessProbe *corev1.Probe `json:"readinessProbe,omitempty"`
+
+	// Build overrides the build pod settings of the configuration for the runtime
+	Build *BuildPodSettings `json:"build,omitempty"`
+}
+
+// ControllerConfigMapKey returns the name and namespace of the function controller's configuration, fn-config in the
+// default namespace unless CONTROLLER_CONFIGMAP and CONTROLLER_CONFIGMAP_NS are set. The controller and the webhooks
+// read the same configuration.
+func ControllerConfigMapKey() types.NamespacedName {
+	return types.NamespacedName{
+		Name:      getEnvDefault("CONTROLLER_CONFIGMAP", "fn-config"),
+		Namespace: getEnvDefault("CONTROLLER_CONFIGMAP_NS", "default"),
+	}
 }
 
-func New(config *corev1.ConfigMap) (*RuntimeInfo, error) {
+// BuildLogsSecretKey returns the name and namespace of the Secret holding the serving certificate of the build logs,
+// build-logs-server-secret in the namespace of the manager unless BUILD_LOGS_SECRET_NAME is set
+func BuildLogsSecretKey() types.NamespacedName {
+	return types.NamespacedName{
+		Name:      getEnvDefault("BUILD_LOGS_SECRET_NAME", "build-logs-server-secret"),
+		Namespace: getEnvDefault("POD_NAMESPACE", "default"),
+	}
+}
+
+// BuildLogsDNSNames returns the DNS names of the Service of the build logs, build-logs-service in the namespace of the
+// manager unless BUILD_LOGS_SERVICE_NAME is set
+func BuildLogsDNSNames() []string {
+	service := getEnvDefault("BUILD_LOGS_SERVICE_NAME", "build-logs-service")
+	namespace := getEnvDefault("POD_NAMESPACE", "default")
+	return []string{
+		fmt.Sprintf("%s.%s.svc", service, namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", service, namespace),
+	}
+}
+
+// New returns the configuration of the controller overridden by the ConfigMaps of a namespace, nil ones are skipped.
+// Overrides replace the values of the configuration key by key, keys set to an empty value unset the configured
+// value. An override of serviceAccountName also replaces a configured buildServiceAccountName unless it sets one too.
+// Overrides may only set the NamespaceConfigKeys.
+func New(config *corev1.ConfigMap, overrides ...*corev1.ConfigMap) (*RuntimeInfo, error) {
 	rnInfo := &RuntimeInfo{}
-	if dockerReg, ok := config.Data["dockerRegistry"]; ok {
+	data, err := rnInfo.merge(config, overrides)
+	if err != nil {
+		log.Error(err, "Unable to override the configuration")
+		return nil, err
+	}
+
+	if dockerReg, ok := data["dockerRegistry"]; ok {
 		rnInfo.RegistryInfo = dockerReg
 	} else {
 		err := errors.New("Error while fetching docker registry info from configmap")
@@ -34,7 +148,7 @@ func New(config *corev1.ConfigMap) (*RuntimeInfo, error) {
 		return nil, err
 	}
 	var availableRuntimes []RuntimesSupported
-	if runtimeImages, ok := config.Data["runtimes"]; ok {
+	if runtimeImages, ok := data["runtimes"]; ok {
 		err := yaml.Unmarshal([]byte(runtimeImages), &availableRuntimes)
 		if err != nil {
 			log.Error(err, "Unable to get the supported runtimes")
@@ -43,17 +157,263 @@ func New(config *corev1.ConfigMap) (*RuntimeInfo, error) {
 		rnInfo.AvailableRuntimes = availableRuntimes
 	}
 
-	if sa, ok := config.Data["serviceAccountName"]; ok {
-		rnInfo.ServiceAccount = sa
+	// serviceAccountName is used for builds unless a dedicated build service account is configured
+	if buildSa, ok := data["buildServiceAccountName"]; ok {
+		rnInfo.BuildServiceAccount = buildSa
+	} else if sa, ok := data["serviceAccountName"]; ok {
+		rnInfo.BuildServiceAccount = sa
 	} else {
 		err := errors.New("Error while fetching serviceAccountName")
 		log.Error(err, "Error while fetching serviceAccountName")
 		return nil, err
 	}
 
+	// functions run with the service account generated per namespace, it never holds the push credentials of builds
+	rnInfo.RuntimeServiceAccount = DefaultRuntimeServiceAccount
+	if runtimeSa, ok := data["runtimeServiceAccountName"]; ok && runtimeSa != "" {
+		rnInfo.RuntimeServiceAccount = runtimeSa
+	}
+
+	// name of the secret in the namespace of the configuration holding the credentials to pull function images
+	rnInfo.RegistryPullSecret = data["registryPullSecret"]
+
+	rnInfo.RouteGateway = defaultRouteGateway
+	if gateway, ok := data["routeGateway"]; ok && gateway != "" {
+		rnInfo.RouteGateway = gateway
+	}
+
+	rnInfo.RouteDestination = defaultRouteDestination
+	if destination, ok := data["routeDestination"]; ok && destination != "" {
+		rnInfo.RouteDestination = destination
+	}
+
+	// repository the cached layers of builds are pushed to, kaniko derives it from the image name if empty
+	rnInfo.BuildCacheRepository = data["buildCacheRepository"]
+
+	// maximum build timeout functions can request, unlimited if empty
+	if maxBuildTimeout, ok := data["maxBuildTimeout"]; ok && maxBuildTimeout != "" {
+		timeout, err := ParseBuildTimeout(maxBuildTimeout)
+		if err != nil {
+			log.Error(err, "Error while parsing maxBuildTimeout")
+			return nil, err
+		}
+		rnInfo.MaxBuildTimeout = timeout
+	}
+
+	// functions without their own .npmrc use this Secret of their namespace
+	rnInfo.NpmrcSecretName = data["npmrcSecretName"]
+
+	// images of deleted functions are deleted from the registry unless they are retained
+	if retainImages, ok := data["retainImages"]; ok && retainImages != "" {
+		retain, err := strconv.ParseBool(retainImages)
+		if err != nil {
+			log.Error(err, "Error while parsing retainImages")
+			return nil, err
+		}
+		rnInfo.RetainImages = retain
+	}
+
+	// builds exceeding the limits of concurrent builds are queued, failed builds are retried up to the limit of retries
+	rnInfo.MaxBuildRetries = defaultMaxBuildRetries
+	for key, limit := range map[string]*int{
+		"maxConcurrentBuilds":             &rnInfo.MaxConcurrentBuilds,
+		"maxConcurrentBuildsPerNamespace": &rnInfo.MaxConcurrentBuildsPerNamespace,
+		"maxBuildRetries":                 &rnInfo.MaxBuildRetries,
+	} {
+		value, ok := data[key]
+		if !ok || value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err == nil && n < 0 {
+			err = fmt.Errorf("%s must not be negative", key)
+		}
+		if err != nil {
+			log.Error(err, "Error while parsing "+key)
+			return nil, err
+		}
+		*limit = n
+	}
+
+	// resources and placement of the pods of builds, each runtime can override them
+	if buildSettings, ok := data["build"]; ok {
+		if err := yaml.Unmarshal([]byte(buildSettings), &rnInfo.Build); err != nil {
+			log.Error(err, "Unable to get the build pod settings")
+			return nil, err
+		}
+	}
+	// Knative Build doesn't support tolerations. The configuration is rejected, the controller keeps using the last
+	// valid one and reports the rejection.
+	settings := []BuildPodSettings{rnInfo.Build}
+	for _, rt := range rnInfo.AvailableRuntimes {
+		if rt.Build != nil {
+			settings = append(settings, *rt.Build)
+		}
+	}
+	for _, setting := range settings {
+		if len(setting.Tolerations) > 0 {
+			err := errors.New("Build pod tolerations are not supported by Knative Build, use nodeSelector or affinity instead")
+			log.Error(err, "Error while fetching the build pod settings")
+			return nil, err
+		}
+	}
+
 	return rnInfo, nil
 }
 
+// merge merges the data of the configuration and its overrides and records where it came from
+func (ri *RuntimeInfo) merge(config *corev1.ConfigMap, overrides []*corev1.ConfigMap) (map[string]string, error) {
+	data := map[string]string{}
+	for key, value := range config.Data {
+		data[key] = value
+	}
+	ri.Sources = []string{configSource(config)}
+	ri.RegistryPullSecretNamespace = config.Namespace
+
+	for _, override := range overrides {
+		if override == nil {
+			continue
+		}
+
+		keys := []string{}
+		for key := range override.Data {
+			if !NamespaceConfigKeys[key] {
+				return nil, fmt.Errorf("%s can't override %s of the controller's configuration", configSource(override), key)
+			}
+			keys = append(keys, key)
+		}
+		if len(keys) == 0 {
+			continue
+		}
+
+		if _, ok := override.Data["serviceAccountName"]; ok {
+			delete(data, "buildServiceAccountName")
+		}
+		if _, ok := override.Data["registryPullSecret"]; ok {
+			ri.RegistryPullSecretNamespace = override.Namespace
+		}
+		for _, key := range keys {
+			data[key] = override.Data[key]
+		}
+		ri.Sources = append(ri.Sources, configSource(override))
+	}
+
+	return data, nil
+}
+
+func configSource(config *corev1.ConfigMap) string {
+	return fmt.Sprintf("%s/%s", config.Namespace, config.Name)
+}
+
+// FunctionConfig returns the effective configuration of a function, the fields of the function override the configuration
+func (ri *RuntimeInfo) FunctionConfig(fn *runtimev1alpha1.Function) *runtimev1alpha1.FunctionConfigStatus {
+	config := &runtimev1alpha1.FunctionConfigStatus{
+		Registry:              ri.RegistryInfo,
+		BuildServiceAccount:   ri.BuildServiceAccount,
+		RuntimeServiceAccount: RuntimeServiceAccountName(fn, ri),
+		BuildCacheRepository:  ri.BuildCacheRepository,
+		BuildTimeout:          ri.BuildTimeout(fn).String(),
+		RetainImages:          ri.RetainImages,
+		Sources:               append([]string{}, ri.Sources...),
+	}
+	if ri.RegistryPullSecret != "" {
+		config.RegistryPullSecret = fmt.Sprintf("%s/%s", ri.RegistryPullSecretNamespace, ri.RegistryPullSecret)
+	}
+	config.NpmrcSecret, _ = ri.NpmrcSecret(fn)
+	return config
+}
+
+// BuildSettings returns the build pod settings of a runtime. Settings of the runtime replace the ones of the configuration.
+func (ri *RuntimeInfo) BuildSettings(runtime string) BuildPodSettings {
+	settings := ri.Build
+	for _, runtimeInf := range ri.AvailableRuntimes {
+		if runtimeInf.ID != runtime || runtimeInf.Build == nil {
+			continue
+		}
+		if runtimeInf.Build.Resources != nil {
+			settings.Resources = runtimeInf.Build.Resources
+		}
+		if runtimeInf.Build.NodeSelector != nil {
+			settings.NodeSelector = runtimeInf.Build.NodeSelector
+		}
+		if runtimeInf.Build.Affinity != nil {
+			settings.Affinity = runtimeInf.Build.Affinity
+		}
+		break
+	}
+
+	if settings.Resources != nil {
+		settings.Resources = settings.Resources.DeepCopy()
+	}
+	if settings.Affinity != nil {
+		settings.Affinity = settings.Affinity.DeepCopy()
+	}
+	return settings
+}
+
+// BuildTemplateName returns the name of the ClusterBuildTemplate used by the builds of a runtime. The resources of
+// the build step are part of the template, so runtimes overriding them get a template of their own.
+func (ri *RuntimeInfo) BuildTemplateName(runtime string) string {
+	for _, runtimeInf := range ri.AvailableRuntimes {
+		if runtimeInf.ID == runtime && runtimeInf.Build != nil && runtimeInf.Build.Resources != nil {
+			return fmt.Sprintf("%s-%s", DefaultBuildTemplateName, runtime)
+		}
+	}
+	return DefaultBuildTemplateName
+}
+
+// BuildTemplateRuntimes returns the runtimes with a ClusterBuildTemplate of their own, mapped by template name
+func (ri *RuntimeInfo) BuildTemplateRuntimes() map[string]string {
+	runtimes := map[string]string{DefaultBuildTemplateName: ""}
+	for _, runtimeInf := range ri.AvailableRuntimes {
+		if name := ri.BuildTemplateName(runtimeInf.ID); name != DefaultBuildTemplateName {
+			runtimes[name] = runtimeInf.ID
+		}
+	}
+	return runtimes
+}
+
+// Probes returns the liveness and readiness probes of a runtime. Runtimes which don't define probes get the default ones.
+func (ri *RuntimeInfo) Probes(runtime string) (*corev1.Probe, *corev1.Probe) {
+	livenessProbe, readinessProbe := defaultLivenessProbe(), defaultReadinessProbe()
+	for _, runtimeInf := range ri.AvailableRuntimes {
+		if runtimeInf.ID == runtime {
+			if runtimeInf.LivenessProbe != nil {
+				livenessProbe = runtimeInf.LivenessProbe.DeepCopy()
+			}
+			if runtimeInf.ReadinessProbe != nil {
+				readinessProbe = runtimeInf.ReadinessProbe.DeepCopy()
+			}
+			break
+		}
+	}
+	return livenessProbe, readinessProbe
+}
+
+// Knative sends the probes to the port of the function's container, so the default probes don't define a port
+func defaultLivenessProbe() *corev1.Probe {
+	return &corev1.Probe{
+		Handler: corev1.Handler{
+			HTTPGet: &corev1.HTTPGetAction{Path: functionHealthPath},
+		},
+		InitialDelaySeconds: 10,
+		PeriodSeconds:       10,
+		TimeoutSeconds:      1,
+		FailureThreshold:    3,
+	}
+}
+
+func defaultReadinessProbe() *corev1.Probe {
+	return &corev1.Probe{
+		Handler: corev1.Handler{
+			HTTPGet: &corev1.HTTPGetAction{Path: functionHealthPath},
+		},
+		PeriodSeconds:    5,
+		TimeoutSeconds:   1,
+		FailureThreshold: 3,
+	}
+}
+
 func (ri *RuntimeInfo) DockerFileConfigMapName(runtime string) string {
 	result := ""
 	for _, runtimeInf := range ri.AvailableRuntimes {
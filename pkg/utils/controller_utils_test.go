@@ -1,15 +1,55 @@
 package utils_test
 
 import (
+	"os"
 	"testing"
+	"time"
 
 	"github.com/onsi/gomega"
 
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
 	"github.com/kyma-incubator/runtime/pkg/utils"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
+// Test that the controller and the webhooks agree on the configuration
+func TestControllerConfigMapKey(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	g.Expect(utils.ControllerConfigMapKey()).To(gomega.Equal(types.NamespacedName{Name: "fn-config", Namespace: "default"}))
+
+	os.Setenv("CONTROLLER_CONFIGMAP", "runtime-config")
+	os.Setenv("CONTROLLER_CONFIGMAP_NS", "kyma-system")
+	defer os.Unsetenv("CONTROLLER_CONFIGMAP")
+	defer os.Unsetenv("CONTROLLER_CONFIGMAP_NS")
+	g.Expect(utils.ControllerConfigMapKey()).To(gomega.Equal(types.NamespacedName{Name: "runtime-config", Namespace: "kyma-system"}))
+}
+
+func TestBuildLogsCertificate(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	g.Expect(utils.BuildLogsSecretKey()).To(gomega.Equal(types.NamespacedName{Name: "build-logs-server-secret", Namespace: "default"}))
+	g.Expect(utils.BuildLogsDNSNames()).To(gomega.Equal([]string{
+		"build-logs-service.default.svc",
+		"build-logs-service.default.svc.cluster.local",
+	}))
+
+	os.Setenv("BUILD_LOGS_SECRET_NAME", "runtime-build-logs-server-secret")
+	os.Setenv("BUILD_LOGS_SERVICE_NAME", "runtime-build-logs-service")
+	os.Setenv("POD_NAMESPACE", "runtime-system")
+	defer os.Unsetenv("BUILD_LOGS_SECRET_NAME")
+	defer os.Unsetenv("BUILD_LOGS_SERVICE_NAME")
+	defer os.Unsetenv("POD_NAMESPACE")
+	g.Expect(utils.BuildLogsSecretKey()).To(gomega.Equal(types.NamespacedName{Name: "runtime-build-logs-server-secret", Namespace: "runtime-system"}))
+	g.Expect(utils.BuildLogsDNSNames()).To(gomega.Equal([]string{
+		"runtime-build-logs-service.runtime-system.svc",
+		"runtime-build-logs-service.runtime-system.svc.cluster.local",
+	}))
+}
+
 func TestNewRuntimeInfo(t *testing.T) {
 
 	g := gomega.NewGomegaWithT(t)
@@ -21,8 +61,65 @@ func TestNewRuntimeInfo(t *testing.T) {
 	}
 	ri, err := utils.New(cm)
 	g.Expect(err).NotTo(gomega.HaveOccurred())
-	g.Expect(ri.ServiceAccount).To(gomega.Equal("test"))
+	g.Expect(ri.BuildServiceAccount).To(gomega.Equal("test"))
+	g.Expect(ri.RuntimeServiceAccount).To(gomega.Equal("function-runtime"))
+	g.Expect(ri.RegistryPullSecret).To(gomega.Equal(""))
 	g.Expect(ri.RegistryInfo).To(gomega.Equal("foo"))
+	g.Expect(ri.RouteGateway).To(gomega.Equal("knative-ingress-gateway.knative-serving.svc.cluster.local"))
+	g.Expect(ri.RouteDestination).To(gomega.Equal("istio-ingressgateway.istio-system.svc.cluster.local"))
+
+	cm.Data["routeGateway"] = "kyma-gateway.kyma-system.svc.cluster.local"
+	cm.Data["routeDestination"] = "foo.istio-system.svc.cluster.local"
+	ri, err = utils.New(cm)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ri.RouteGateway).To(gomega.Equal("kyma-gateway.kyma-system.svc.cluster.local"))
+	g.Expect(ri.RouteDestination).To(gomega.Equal("foo.istio-system.svc.cluster.local"))
+
+	g.Expect(ri.MaxBuildTimeout).To(gomega.BeZero())
+	cm.Data["maxBuildTimeout"] = "2h"
+	ri, err = utils.New(cm)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ri.MaxBuildTimeout).To(gomega.Equal(2 * time.Hour))
+
+	cm.Data["maxBuildTimeout"] = "two hours"
+	_, err = utils.New(cm)
+	g.Expect(err).To(gomega.HaveOccurred())
+	delete(cm.Data, "maxBuildTimeout")
+
+	g.Expect(ri.MaxConcurrentBuilds).To(gomega.BeZero())
+	g.Expect(ri.MaxConcurrentBuildsPerNamespace).To(gomega.BeZero())
+	cm.Data["maxConcurrentBuilds"] = "10"
+	cm.Data["maxConcurrentBuildsPerNamespace"] = "2"
+	ri, err = utils.New(cm)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ri.MaxConcurrentBuilds).To(gomega.Equal(10))
+	g.Expect(ri.MaxConcurrentBuildsPerNamespace).To(gomega.Equal(2))
+
+	for _, value := range []string{"ten", "-1"} {
+		cm.Data["maxConcurrentBuildsPerNamespace"] = value
+		_, err = utils.New(cm)
+		g.Expect(err).To(gomega.HaveOccurred())
+	}
+	delete(cm.Data, "maxConcurrentBuilds")
+	delete(cm.Data, "maxConcurrentBuildsPerNamespace")
+
+	g.Expect(ri.MaxBuildRetries).To(gomega.Equal(3))
+	cm.Data["maxBuildRetries"] = "0"
+	ri, err = utils.New(cm)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ri.MaxBuildRetries).To(gomega.BeZero())
+	delete(cm.Data, "maxBuildRetries")
+
+	g.Expect(ri.RetainImages).To(gomega.BeFalse())
+	cm.Data["retainImages"] = "true"
+	ri, err = utils.New(cm)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ri.RetainImages).To(gomega.BeTrue())
+
+	cm.Data["retainImages"] = "forever"
+	_, err = utils.New(cm)
+	g.Expect(err).To(gomega.HaveOccurred())
+	delete(cm.Data, "retainImages")
 
 	cmBroken := &corev1.ConfigMap{
 		Data: map[string]string{
@@ -40,6 +137,27 @@ func TestNewRuntimeInfo(t *testing.T) {
 	_, err = utils.New(cmBroken)
 	g.Expect(err.Error()).To(gomega.Equal("Error while fetching serviceAccountName"))
 
+	cmSplit := &corev1.ConfigMap{
+		Data: map[string]string{
+			"dockerRegistry":            "foo",
+			"buildServiceAccountName":   "build",
+			"runtimeServiceAccountName": "runtime",
+		},
+	}
+	ri, err = utils.New(cmSplit)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ri.BuildServiceAccount).To(gomega.Equal("build"))
+	g.Expect(ri.RuntimeServiceAccount).To(gomega.Equal("runtime"))
+
+	cmSplit.Data["serviceAccountName"] = "test"
+	cmSplit.Data["registryPullSecret"] = "pull"
+	delete(cmSplit.Data, "runtimeServiceAccountName")
+	ri, err = utils.New(cmSplit)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ri.BuildServiceAccount).To(gomega.Equal("build"))
+	g.Expect(ri.RuntimeServiceAccount).To(gomega.Equal("function-runtime"))
+	g.Expect(ri.RegistryPullSecret).To(gomega.Equal("pull"))
+
 	cmBroken = &corev1.ConfigMap{
 		Data: map[string]string{
 			"serviceAccountName": "test",
@@ -52,6 +170,97 @@ func TestNewRuntimeInfo(t *testing.T) {
 
 }
 
+func TestNewRuntimeInfoOverrides(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "fn-config", Namespace: "kyma-system"},
+		Data: map[string]string{
+			"dockerRegistry":          "foo",
+			"serviceAccountName":      "test",
+			"buildServiceAccountName": "build",
+			"registryPullSecret":      "pull",
+			"maxBuildRetries":         "5",
+		},
+	}
+	nsCm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "fn-config", Namespace: "team-a"},
+		Data: map[string]string{
+			"dockerRegistry":     "registry.team-a.example.com",
+			"serviceAccountName": "team-a-build",
+		},
+	}
+
+	ri, err := utils.New(cm, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ri.Sources).To(gomega.Equal([]string{"kyma-system/fn-config"}))
+	g.Expect(ri.RegistryPullSecretNamespace).To(gomega.Equal("kyma-system"))
+
+	// overrides replace the values key by key, serviceAccountName replaces the configured buildServiceAccountName
+	ri, err = utils.New(cm, nsCm)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ri.RegistryInfo).To(gomega.Equal("registry.team-a.example.com"))
+	g.Expect(ri.BuildServiceAccount).To(gomega.Equal("team-a-build"))
+	g.Expect(ri.RegistryPullSecret).To(gomega.Equal("pull"))
+	g.Expect(ri.RegistryPullSecretNamespace).To(gomega.Equal("kyma-system"))
+	g.Expect(ri.MaxBuildRetries).To(gomega.Equal(5))
+	g.Expect(ri.Sources).To(gomega.Equal([]string{"kyma-system/fn-config", "team-a/fn-config"}))
+	g.Expect(cm.Data["buildServiceAccountName"]).To(gomega.Equal("build"))
+
+	// pull secrets set by a namespace are read from the namespace, empty values unset the configured ones
+	nsCm.Data["registryPullSecret"] = "team-a-pull"
+	nsCm.Data["buildCacheRepository"] = ""
+	cm.Data["buildCacheRepository"] = "foo/cache"
+	ri, err = utils.New(cm, nsCm)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ri.RegistryPullSecret).To(gomega.Equal("team-a-pull"))
+	g.Expect(ri.RegistryPullSecretNamespace).To(gomega.Equal("team-a"))
+	g.Expect(ri.BuildCacheRepository).To(gomega.BeEmpty())
+
+	// limits and runtimes are shared by all namespaces
+	nsCm.Data["maxBuildRetries"] = "10"
+	_, err = utils.New(cm, nsCm)
+	g.Expect(err).To(gomega.MatchError("team-a/fn-config can't override maxBuildRetries of the controller's configuration"))
+}
+
+func TestFunctionConfig(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	ri := &utils.RuntimeInfo{
+		RegistryInfo:                "registry.team-a.example.com",
+		BuildServiceAccount:         "build",
+		RuntimeServiceAccount:       "function-runtime",
+		RegistryPullSecret:          "pull",
+		RegistryPullSecretNamespace: "team-a",
+		NpmrcSecretName:             "function-npmrc",
+		MaxBuildTimeout:             time.Hour,
+		Sources:                     []string{"kyma-system/fn-config", "team-a/fn-config"},
+	}
+	fn := &runtimev1alpha1.Function{
+		Spec: runtimev1alpha1.FunctionSpec{
+			ServiceAccountName: "orders",
+			Build: &runtimev1alpha1.FunctionBuildSpec{
+				Timeout:        &metav1.Duration{Duration: 2 * time.Hour},
+				NpmrcSecretRef: &corev1.LocalObjectReference{Name: "orders-npmrc"},
+			},
+		},
+	}
+
+	g.Expect(ri.FunctionConfig(fn)).To(gomega.Equal(&runtimev1alpha1.FunctionConfigStatus{
+		Registry:              "registry.team-a.example.com",
+		BuildServiceAccount:   "build",
+		RuntimeServiceAccount: "orders",
+		RegistryPullSecret:    "team-a/pull",
+		NpmrcSecret:           "orders-npmrc",
+		BuildTimeout:          "1h0m0s",
+		Sources:               []string{"kyma-system/fn-config", "team-a/fn-config"},
+	}))
+
+	fn.Spec = runtimev1alpha1.FunctionSpec{}
+	config := ri.FunctionConfig(fn)
+	g.Expect(config.RuntimeServiceAccount).To(gomega.Equal("function-runtime"))
+	g.Expect(config.NpmrcSecret).To(gomega.Equal("function-npmrc"))
+	g.Expect(config.BuildTimeout).To(gomega.Equal("30m0s"))
+}
+
 func TestDockerFileConfigMapName(t *testing.T) {
 	g := gomega.NewGomegaWithT(t)
 	runtime := "nodejs8"
@@ -74,3 +283,109 @@ func TestDockerFileConfigMapName(t *testing.T) {
 	dockerFileCMName = ri.DockerFileConfigMapName("foo")
 	g.Expect(dockerFileCMName).To(gomega.Equal(""))
 }
+
+func TestRuntimeProbes(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	cm := &corev1.ConfigMap{
+		Data: map[string]string{
+			"serviceAccountName": "test",
+			"dockerRegistry":     "foo",
+			"runtimes": `
+- ID: nodejs8
+  dockerFileName: dockerfile-nodejs-8
+  livenessProbe:
+    httpGet:
+      path: /alive
+    initialDelaySeconds: 20
+- ID: nodejs6
+  dockerFileName: dockerfile-nodejs-6
+`,
+		},
+	}
+	ri, err := utils.New(cm)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	livenessProbe, readinessProbe := ri.Probes("nodejs8")
+	g.Expect(livenessProbe.HTTPGet.Path).To(gomega.Equal("/alive"))
+	g.Expect(livenessProbe.InitialDelaySeconds).To(gomega.BeEquivalentTo(20))
+	g.Expect(readinessProbe.HTTPGet.Path).To(gomega.Equal("/healthz"))
+
+	livenessProbe, readinessProbe = ri.Probes("nodejs6")
+	g.Expect(livenessProbe.HTTPGet.Path).To(gomega.Equal("/healthz"))
+	g.Expect(readinessProbe.HTTPGet.Path).To(gomega.Equal("/healthz"))
+
+	// returned probes are copies
+	livenessProbe.HTTPGet.Path = "/changed"
+	livenessProbe, _ = ri.Probes("nodejs8")
+	g.Expect(livenessProbe.HTTPGet.Path).To(gomega.Equal("/alive"))
+}
+
+func TestRuntimeBuildSettings(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	cm := &corev1.ConfigMap{
+		Data: map[string]string{
+			"serviceAccountName": "test",
+			"dockerRegistry":     "foo",
+			"build": `
+resources:
+  requests:
+    memory: 1Gi
+  limits:
+    memory: 2Gi
+nodeSelector:
+  pool: builds
+`,
+			"runtimes": `
+- ID: nodejs8
+  dockerFileName: dockerfile-nodejs-8
+  build:
+    resources:
+      limits:
+        memory: 4Gi
+    affinity:
+      nodeAffinity:
+        requiredDuringSchedulingIgnoredDuringExecution:
+          nodeSelectorTerms:
+          - matchExpressions:
+            - key: memory
+              operator: In
+              values:
+              - high
+- ID: nodejs6
+  dockerFileName: dockerfile-nodejs-6
+`,
+		},
+	}
+	ri, err := utils.New(cm)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	// settings of the configuration
+	settings := ri.BuildSettings("nodejs6")
+	g.Expect(settings.Resources.Limits.Memory().String()).To(gomega.Equal("2Gi"))
+	g.Expect(settings.Resources.Requests.Memory().String()).To(gomega.Equal("1Gi"))
+	g.Expect(settings.NodeSelector).To(gomega.Equal(map[string]string{"pool": "builds"}))
+	g.Expect(settings.Affinity).To(gomega.BeNil())
+	g.Expect(ri.BuildTemplateName("nodejs6")).To(gomega.Equal("function-kaniko"))
+
+	// settings of the runtime replace the ones of the configuration
+	settings = ri.BuildSettings("nodejs8")
+	g.Expect(settings.Resources.Limits.Memory().String()).To(gomega.Equal("4Gi"))
+	g.Expect(settings.Resources.Requests).To(gomega.BeEmpty())
+	g.Expect(settings.NodeSelector).To(gomega.Equal(map[string]string{"pool": "builds"}))
+	g.Expect(settings.Affinity.NodeAffinity).NotTo(gomega.BeNil())
+	g.Expect(ri.BuildTemplateName("nodejs8")).To(gomega.Equal("function-kaniko-nodejs8"))
+
+	g.Expect(ri.BuildTemplateRuntimes()).To(gomega.Equal(map[string]string{
+		"function-kaniko":         "",
+		"function-kaniko-nodejs8": "nodejs8",
+	}))
+
+	// Knative Build doesn't support tolerations, the configuration is rejected
+	cm.Data["build"] = `
+tolerations:
+- key: builds
+  operator: Exists
+`
+	_, err = utils.New(cm)
+	g.Expect(err).To(gomega.MatchError("Build pod tolerations are not supported by Knative Build, use nodeSelector or affinity instead"))
+}
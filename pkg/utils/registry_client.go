@@ -0,0 +1,296 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// host of Docker Hub in image names and the host serving its Docker Registry v2 API
+	dockerHubHost    = "index.docker.io"
+	dockerHubAPIHost = "registry-1.docker.io"
+
+	// header of the registry's responses holding the digest of a manifest
+	contentDigestHeader = "Docker-Content-Digest"
+)
+
+// types of the manifests function images may be stored as
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+// ImageRepository returns the repository of a registry the images of a function are pushed to, they are tagged with
+// the hash of the function's sources. Namespaces can't contain dots, so functions never share a repository, while
+// the repository stays a single path component as Docker Hub doesn't support nested repositories.
+func ImageRepository(registry string, fn *runtimev1alpha1.Function) string {
+	return fmt.Sprintf("%s/%s.%s", registry, fn.Namespace, fn.Name)
+}
+
+// ParseRepository splits a repository into the host of its registry and its name in the registry. Repositories
+// without a host are on Docker Hub.
+func ParseRepository(repository string) (host string, name string) {
+	host, name = dockerHubHost, repository
+	if parts := strings.SplitN(repository, "/", 2); len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		host, name = registryHost(parts[0]), parts[1]
+	}
+	if IsDockerHub(host) && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	return host, name
+}
+
+// IsDockerHub checks whether the host of a registry is Docker Hub. It doesn't support deleting images through the
+// Docker Registry v2 API.
+func IsDockerHub(host string) bool {
+	return host == dockerHubHost || host == dockerHubAPIHost
+}
+
+// registryHost returns the host of a registry given as URL or as host, e.g. https://index.docker.io/v1/
+func registryHost(registry string) string {
+	if u, err := url.Parse(registry); err == nil && u.Host != "" {
+		registry = u.Host
+	}
+	host := strings.SplitN(registry, "/", 2)[0]
+	if host == "docker.io" {
+		return dockerHubHost
+	}
+	return host
+}
+
+// registryURL returns the URL of the Docker Registry v2 API of a registry. Local registries are served over HTTP.
+func registryURL(host string) string {
+	if IsDockerHub(host) {
+		return "https://" + dockerHubAPIHost
+	}
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	if hostname == "localhost" || net.ParseIP(hostname).IsLoopback() {
+		return "http://" + host
+	}
+	return "https://" + host
+}
+
+// RegistryCredentials authenticate the requests to a registry with basic auth
+type RegistryCredentials struct {
+	Username string
+	Password string
+}
+
+// GetRegistryCredentials gets the credentials of a registry from a Secret of the build service account. Basic-auth
+// credentials of Knative Build and docker configs are supported. It returns nil if the Secret has none for the registry.
+func GetRegistryCredentials(secret *corev1.Secret, host string) (*RegistryCredentials, error) {
+	switch secret.Type {
+	case corev1.SecretTypeBasicAuth:
+		for key, registry := range secret.Annotations {
+			if strings.HasPrefix(key, buildDockerAnnotationPrefix) && registryHost(registry) == host {
+				return &RegistryCredentials{
+					Username: string(secret.Data[corev1.BasicAuthUsernameKey]),
+					Password: string(secret.Data[corev1.BasicAuthPasswordKey]),
+				}, nil
+			}
+		}
+	case corev1.SecretTypeDockerConfigJson:
+		config := dockerConfig{}
+		if err := json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &config); err != nil {
+			return nil, fmt.Errorf("registry credentials %s/%s aren't a docker config: %v", secret.Namespace, secret.Name, err)
+		}
+		for registry, auth := range config.Auths {
+			if registryHost(registry) != host {
+				continue
+			}
+			if auth.Username == "" && auth.Auth != "" {
+				decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+				if err != nil {
+					return nil, fmt.Errorf("registry credentials %s/%s have an invalid auth of %s: %v", secret.Namespace, secret.Name, registry, err)
+				}
+				credentials := strings.SplitN(string(decoded), ":", 2)
+				if len(credentials) == 2 {
+					auth.Username, auth.Password = credentials[0], credentials[1]
+				}
+			}
+			return &RegistryCredentials{Username: auth.Username, Password: auth.Password}, nil
+		}
+	}
+	return nil, nil
+}
+
+// RegistryClient deletes images from registries through the Docker Registry v2 API
+type RegistryClient struct {
+	// Client sends the requests to the registries, http.DefaultClient if nil
+	Client *http.Client
+}
+
+// DeleteTags deletes the manifests the tags of a repository reference. Manifests which other tags of the repository
+// reference as well are kept, as deleting a manifest deletes all its tags. Tags which are gone already are skipped, so
+// a failed deletion can be retried. It returns the tags deleted before an error.
+func (rc *RegistryClient) DeleteTags(repository string, credentials *RegistryCredentials, tags []string) ([]string, error) {
+	host, name := ParseRepository(repository)
+	base := fmt.Sprintf("%s/v2/%s", registryURL(host), name)
+
+	existing, err := rc.listTags(base, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	deleting := map[string]bool{}
+	for _, tag := range tags {
+		deleting[tag] = true
+	}
+	// digests of the tags to delete and the digests other tags keep
+	digests := map[string]string{}
+	kept := map[string]bool{}
+	for _, tag := range existing {
+		digest, err := rc.manifestDigest(base, tag, credentials)
+		if err != nil {
+			return nil, err
+		}
+		if digest == "" {
+			continue
+		}
+		if deleting[tag] {
+			digests[tag] = digest
+		} else {
+			kept[digest] = true
+		}
+	}
+
+	deleted := []string{}
+	for _, tag := range tags {
+		digest, ok := digests[tag]
+		if !ok || kept[digest] {
+			continue
+		}
+		if err := rc.deleteManifest(base, digest, credentials); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, tag)
+	}
+	return deleted, nil
+}
+
+// listTags lists all tags of a repository following the pages of the registry, none if the repository doesn't exist
+func (rc *RegistryClient) listTags(base string, credentials *RegistryCredentials) ([]string, error) {
+	tags := []string{}
+	next := base + "/tags/list"
+	for next != "" {
+		resp, err := rc.do(http.MethodGet, next, credentials)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			return tags, nil
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			return nil, registryError(resp)
+		}
+
+		list := struct {
+			Tags []string `json:"tags"`
+		}{}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode the tags of %s: %v", base, err)
+		}
+		tags = append(tags, list.Tags...)
+
+		next, err = nextPage(resp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+// nextPage returns the URL of the next page of a paginated response, empty for the last page
+func nextPage(resp *http.Response) (string, error) {
+	link := resp.Header.Get("Link")
+	if link == "" || !strings.Contains(link, `rel="next"`) {
+		return "", nil
+	}
+	start, end := strings.Index(link, "<"), strings.Index(link, ">")
+	if start < 0 || end < start {
+		return "", fmt.Errorf("invalid Link header %q of %s", link, resp.Request.URL)
+	}
+	next, err := resp.Request.URL.Parse(link[start+1 : end])
+	if err != nil {
+		return "", fmt.Errorf("invalid Link header %q of %s: %v", link, resp.Request.URL, err)
+	}
+	return next.String(), nil
+}
+
+// manifestDigest returns the digest of the manifest a tag references, empty if the tag doesn't exist
+func (rc *RegistryClient) manifestDigest(base, tag string, credentials *RegistryCredentials) (string, error) {
+	resp, err := rc.do(http.MethodHead, base+"/manifests/"+tag, credentials, manifestMediaTypes...)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", nil
+	case resp.StatusCode != http.StatusOK:
+		return "", registryError(resp)
+	case resp.Header.Get(contentDigestHeader) == "":
+		return "", fmt.Errorf("%s %s: no %s header", resp.Request.Method, resp.Request.URL, contentDigestHeader)
+	}
+	return resp.Header.Get(contentDigestHeader), nil
+}
+
+// deleteManifest deletes a manifest and all tags referencing it
+func (rc *RegistryClient) deleteManifest(base, digest string, credentials *RegistryCredentials) error {
+	resp, err := rc.do(http.MethodDelete, base+"/manifests/"+digest, credentials)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusOK, http.StatusNotFound:
+		return nil
+	}
+	return registryError(resp)
+}
+
+func (rc *RegistryClient) do(method, target string, credentials *RegistryCredentials, accept ...string) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if credentials != nil {
+		req.SetBasicAuth(credentials.Username, credentials.Password)
+	}
+	for _, mediaType := range accept {
+		req.Header.Add("Accept", mediaType)
+	}
+
+	client := rc.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// registryError returns the error of an unexpected response of a registry
+func registryError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s %s: %s %s", resp.Request.Method, resp.Request.URL, resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,246 @@
+package utils_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	"github.com/kyma-incubator/runtime/pkg/utils"
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// testRegistry is an in-process registry speaking the Docker Registry v2 API. It lists the tags in pages of one tag.
+type testRegistry struct {
+	mu sync.Mutex
+
+	// digests of the manifests by repository and tag
+	tags map[string]map[string]string
+
+	username, password string
+	deleteDisabled     bool
+}
+
+func (tr *testRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if username, password, _ := r.BasicAuth(); username != tr.username || password != tr.password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case strings.HasSuffix(path, "/tags/list") && r.Method == http.MethodGet:
+		repository := strings.TrimSuffix(path, "/tags/list")
+		if tr.tags[repository] == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		tags := []string{}
+		for tag := range tr.tags[repository] {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		last := r.URL.Query().Get("last")
+		i := sort.SearchStrings(tags, last)
+		if last != "" && i < len(tags) && tags[i] == last {
+			i++
+		}
+		page := tags[i:]
+		if len(page) > 1 {
+			page = page[:1]
+			w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?n=1&last=%s>; rel="next"`, repository, page[0]))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": repository, "tags": page})
+	case strings.Contains(path, "/manifests/"):
+		parts := strings.SplitN(path, "/manifests/", 2)
+		repository, reference := parts[0], parts[1]
+		if r.Method == http.MethodHead {
+			if !strings.Contains(r.Header.Get("Accept"), "application/vnd.docker.distribution.manifest.v2+json") {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			digest, ok := tr.tags[repository][reference]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", digest)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			if tr.deleteDisabled {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				fmt.Fprint(w, `{"errors":[{"code":"UNSUPPORTED"}]}`)
+				return
+			}
+			found := false
+			for tag, digest := range tr.tags[repository] {
+				if digest == reference {
+					delete(tr.tags[repository], tag)
+					found = true
+				}
+			}
+			if !found {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestParseRepository(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	tests := []struct {
+		repository string
+		host       string
+		name       string
+		dockerHub  bool
+	}{
+		{repository: "registry.example.com/default-hello", host: "registry.example.com", name: "default-hello"},
+		{repository: "registry.example.com:5000/team/default-hello", host: "registry.example.com:5000", name: "team/default-hello"},
+		{repository: "localhost/default-hello", host: "localhost", name: "default-hello"},
+		{repository: "127.0.0.1:5000/default-hello", host: "127.0.0.1:5000", name: "default-hello"},
+		{repository: "myorg/default-hello", host: "index.docker.io", name: "myorg/default-hello", dockerHub: true},
+		{repository: "docker.io/myorg/default-hello", host: "index.docker.io", name: "myorg/default-hello", dockerHub: true},
+		{repository: "default-hello", host: "index.docker.io", name: "library/default-hello", dockerHub: true},
+	}
+
+	for _, test := range tests {
+		host, name := utils.ParseRepository(test.repository)
+		g.Expect(host).To(gomega.Equal(test.host), test.repository)
+		g.Expect(name).To(gomega.Equal(test.name), test.repository)
+		g.Expect(utils.IsDockerHub(host)).To(gomega.Equal(test.dockerHub), test.repository)
+	}
+}
+
+func TestGetRegistryCredentials(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	// basic-auth credentials of Knative Build are matched by the host of their annotation
+	basicAuth := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "push",
+			Namespace:   "default",
+			Annotations: map[string]string{"build.knative.dev/docker-0": "https://registry.example.com/v2/"},
+		},
+		Type: corev1.SecretTypeBasicAuth,
+		Data: map[string][]byte{
+			corev1.BasicAuthUsernameKey: []byte("user"),
+			corev1.BasicAuthPasswordKey: []byte("pass"),
+		},
+	}
+	credentials, err := utils.GetRegistryCredentials(basicAuth, "registry.example.com")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(credentials).To(gomega.Equal(&utils.RegistryCredentials{Username: "user", Password: "pass"}))
+
+	credentials, err = utils.GetRegistryCredentials(basicAuth, "other.example.com")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(credentials).To(gomega.BeNil())
+
+	// docker configs are matched by their auths, auth is decoded unless the username is given
+	dockerConfig := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "push", Namespace: "default"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{
+				"registry.example.com": {"auth": "dXNlcjpwYXNz"},
+				"https://index.docker.io/v1/": {"username": "hub", "password": "secret"}
+			}}`),
+		},
+	}
+	credentials, err = utils.GetRegistryCredentials(dockerConfig, "registry.example.com")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(credentials).To(gomega.Equal(&utils.RegistryCredentials{Username: "user", Password: "pass"}))
+
+	credentials, err = utils.GetRegistryCredentials(dockerConfig, "index.docker.io")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(credentials).To(gomega.Equal(&utils.RegistryCredentials{Username: "hub", Password: "secret"}))
+
+	dockerConfig.Data[corev1.DockerConfigJsonKey] = []byte("invalid")
+	_, err = utils.GetRegistryCredentials(dockerConfig, "registry.example.com")
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestImageRepository(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	fn := &runtimev1alpha1.Function{ObjectMeta: metav1.ObjectMeta{Name: "b-c", Namespace: "a"}}
+	other := &runtimev1alpha1.Function{ObjectMeta: metav1.ObjectMeta{Name: "c", Namespace: "a-b"}}
+	g.Expect(utils.ImageRepository("registry.example.com", fn)).To(gomega.Equal("registry.example.com/a.b-c"))
+	g.Expect(utils.ImageRepository("registry.example.com", other)).To(gomega.Equal("registry.example.com/a-b.c"))
+}
+
+func TestRegistryClientDeleteTags(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	registry := &testRegistry{
+		tags: map[string]map[string]string{
+			"default.hello": {
+				"1":      "sha256:1",
+				"2":      "sha256:2",
+				"3":      "sha256:2",
+				"4":      "sha256:4",
+				"latest": "sha256:2",
+			},
+			"default.other": {
+				"1": "sha256:3",
+			},
+		},
+		username: "user",
+		password: "pass",
+	}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	repository := strings.TrimPrefix(server.URL, "http://") + "/default.hello"
+	credentials := &utils.RegistryCredentials{Username: "user", Password: "pass"}
+	client := &utils.RegistryClient{}
+
+	// requests are authenticated
+	_, err := client.DeleteTags(repository, nil, []string{"1"})
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("401 Unauthorized")))
+
+	// registries which don't allow deletion fail
+	registry.deleteDisabled = true
+	deleted, err := client.DeleteTags(repository, credentials, []string{"1"})
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("UNSUPPORTED")))
+	g.Expect(deleted).To(gomega.BeEmpty())
+	registry.deleteDisabled = false
+
+	// the given tags are deleted across pages, manifests other tags reference as well are kept, missing tags are skipped
+	deleted, err = client.DeleteTags(repository, credentials, []string{"1", "2", "3", "4", "5"})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(deleted).To(gomega.ConsistOf("1", "4"))
+	g.Expect(registry.tags["default.hello"]).To(gomega.Equal(map[string]string{
+		"2":      "sha256:2",
+		"3":      "sha256:2",
+		"latest": "sha256:2",
+	}))
+	g.Expect(registry.tags["default.other"]).To(gomega.HaveLen(1))
+
+	// manifests only the given tags reference are deleted
+	deleted, err = client.DeleteTags(repository, credentials, []string{"2", "3", "latest"})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(deleted).To(gomega.ConsistOf("2", "3", "latest"))
+	g.Expect(registry.tags["default.hello"]).To(gomega.BeEmpty())
+
+	// repositories which don't exist have no tags to delete
+	deleted, err = client.DeleteTags(strings.TrimPrefix(server.URL, "http://")+"/default.unknown", credentials, []string{"1"})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(deleted).To(gomega.BeEmpty())
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DefaultRuntimeServiceAccount is the service account generated per namespace which functions run with
+	DefaultRuntimeServiceAccount = "function-runtime"
+
+	// RuntimePullSecret is the secret generated per namespace holding the credentials to pull function images
+	RuntimePullSecret = "function-runtime-registry"
+
+	// annotation prefix of Knative Build for the registry of basic-auth credentials
+	buildDockerAnnotationPrefix = "build.knative.dev/docker-"
+)
+
+// labels of the resources generated per namespace
+var runtimeLabels = map[string]string{
+	"app.kubernetes.io/managed-by": "function-controller",
+}
+
+type dockerConfig struct {
+	Auths map[string]dockerAuth `json:"auths"`
+}
+
+type dockerAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+// GetRuntimePullSecret gets the pull secret of functions in a namespace from the configured registry credentials.
+// Credentials in the basic-auth format of Knative Build are converted into a docker config.
+func GetRuntimePullSecret(credentials *corev1.Secret, namespace string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      RuntimePullSecret,
+			Namespace: namespace,
+			Labels:    runtimeLabels,
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+	}
+
+	switch credentials.Type {
+	case corev1.SecretTypeDockerConfigJson:
+		secret.Data = map[string][]byte{
+			corev1.DockerConfigJsonKey: credentials.Data[corev1.DockerConfigJsonKey],
+		}
+	case corev1.SecretTypeBasicAuth:
+		username := string(credentials.Data[corev1.BasicAuthUsernameKey])
+		password := string(credentials.Data[corev1.BasicAuthPasswordKey])
+		config := dockerConfig{Auths: map[string]dockerAuth{}}
+		for key, registry := range credentials.Annotations {
+			if strings.HasPrefix(key, buildDockerAnnotationPrefix) {
+				config.Auths[registry] = dockerAuth{
+					Username: username,
+					Password: password,
+					Auth:     base64.StdEncoding.EncodeToString([]byte(username + ":" + password)),
+				}
+			}
+		}
+		if len(config.Auths) == 0 {
+			return nil, fmt.Errorf("registry credentials %s/%s have no %s annotation", credentials.Namespace, credentials.Name, buildDockerAnnotationPrefix+"*")
+		}
+
+		data, err := json.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+		secret.Data = map[string][]byte{
+			corev1.DockerConfigJsonKey: data,
+		}
+	default:
+		return nil, fmt.Errorf("registry credentials %s/%s should be of type '%s,%s'", credentials.Namespace, credentials.Name, corev1.SecretTypeDockerConfigJson, corev1.SecretTypeBasicAuth)
+	}
+
+	return secret, nil
+}
+
+// GetRuntimeServiceAccount gets the service account of functions in a namespace. It only references the runtime pull secret.
+func GetRuntimeServiceAccount(namespace string, withPullSecret bool) *corev1.ServiceAccount {
+	sa := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      DefaultRuntimeServiceAccount,
+			Namespace: namespace,
+			Labels:    runtimeLabels,
+		},
+	}
+	if withPullSecret {
+		sa.ImagePullSecrets = []corev1.LocalObjectReference{{Name: RuntimePullSecret}}
+	}
+	return sa
+}
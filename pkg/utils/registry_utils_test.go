@@ -0,0 +1,76 @@
+package utils_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/kyma-incubator/runtime/pkg/utils"
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetRuntimePullSecret(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	// docker config credentials are copied
+	credentials := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "pull", Namespace: "runtime-system"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`),
+		},
+	}
+	secret, err := utils.GetRuntimePullSecret(credentials, "foo")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(secret.Name).To(gomega.Equal("function-runtime-registry"))
+	g.Expect(secret.Namespace).To(gomega.Equal("foo"))
+	g.Expect(secret.Type).To(gomega.Equal(corev1.SecretTypeDockerConfigJson))
+	g.Expect(secret.Data[corev1.DockerConfigJsonKey]).To(gomega.Equal([]byte(`{"auths":{}}`)))
+
+	// basic-auth credentials of Knative Build are converted
+	credentials = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "pull",
+			Namespace:   "runtime-system",
+			Annotations: map[string]string{"build.knative.dev/docker-0": "https://index.docker.io/v1/"},
+		},
+		Type: corev1.SecretTypeBasicAuth,
+		Data: map[string][]byte{
+			corev1.BasicAuthUsernameKey: []byte("user"),
+			corev1.BasicAuthPasswordKey: []byte("pass"),
+		},
+	}
+	secret, err = utils.GetRuntimePullSecret(credentials, "foo")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	config := map[string]map[string]map[string]string{}
+	g.Expect(json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &config)).To(gomega.Succeed())
+	g.Expect(config["auths"]["https://index.docker.io/v1/"]).To(gomega.Equal(map[string]string{
+		"username": "user",
+		"password": "pass",
+		"auth":     "dXNlcjpwYXNz",
+	}))
+
+	// basic-auth credentials without registry
+	credentials.Annotations = nil
+	_, err = utils.GetRuntimePullSecret(credentials, "foo")
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	// unsupported type
+	credentials.Type = corev1.SecretTypeOpaque
+	_, err = utils.GetRuntimePullSecret(credentials, "foo")
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestGetRuntimeServiceAccount(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	sa := utils.GetRuntimeServiceAccount("foo", true)
+	g.Expect(sa.Name).To(gomega.Equal("function-runtime"))
+	g.Expect(sa.Namespace).To(gomega.Equal("foo"))
+	g.Expect(sa.Secrets).To(gomega.BeEmpty())
+	g.Expect(sa.ImagePullSecrets).To(gomega.Equal([]corev1.LocalObjectReference{{Name: "function-runtime-registry"}}))
+
+	sa = utils.GetRuntimeServiceAccount("foo", false)
+	g.Expect(sa.ImagePullSecrets).To(gomega.BeEmpty())
+}
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	istiocommonv1alpha1 "github.com/knative/pkg/apis/istio/common/v1alpha1"
+	istiov1alpha3 "github.com/knative/pkg/apis/istio/v1alpha3"
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+)
+
+// NormalizeRoutePath returns the path of a route with a leading slash, an empty path becomes /
+func NormalizeRoutePath(path string) string {
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// RouteKey identifies a route by its host and normalized path
+func RouteKey(route runtimev1alpha1.FunctionRoute) string {
+	return strings.ToLower(strings.TrimSpace(route.Host)) + NormalizeRoutePath(route.Path)
+}
+
+// GetVirtualServiceSpec gets the istio VirtualServiceSpec exposing a function on its routes
+func GetVirtualServiceSpec(fn *runtimev1alpha1.Function, rnInfo *RuntimeInfo) istiov1alpha3.VirtualServiceSpec {
+
+	// the Knative route of the function accepts requests for its cluster local domain
+	authority := fmt.Sprintf("%s.%s.svc.cluster.local", fn.Name, fn.Namespace)
+
+	routes := make([]runtimev1alpha1.FunctionRoute, len(fn.Spec.Routes))
+	copy(routes, fn.Spec.Routes)
+
+	// istio uses the first matching route, so longer paths must come first
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(NormalizeRoutePath(routes[i].Path)) > len(NormalizeRoutePath(routes[j].Path))
+	})
+
+	hosts := []string{}
+	http := []istiov1alpha3.HTTPRoute{}
+	for _, route := range routes {
+		host := strings.ToLower(strings.TrimSpace(route.Host))
+		if !containsString(hosts, host) {
+			hosts = append(hosts, host)
+		}
+
+		http = append(http, istiov1alpha3.HTTPRoute{
+			Match: []istiov1alpha3.HTTPMatchRequest{
+				{
+					Authority: &istiocommonv1alpha1.StringMatch{Exact: host},
+					URI:       &istiocommonv1alpha1.StringMatch{Prefix: NormalizeRoutePath(route.Path)},
+				},
+			},
+			Rewrite: &istiov1alpha3.HTTPRewrite{
+				Authority: authority,
+			},
+			Route: []istiov1alpha3.HTTPRouteDestination{
+				{
+					Destination: istiov1alpha3.Destination{
+						Host: rnInfo.RouteDestination,
+					},
+					Weight: 100,
+				},
+			},
+		})
+	}
+
+	return istiov1alpha3.VirtualServiceSpec{
+		Hosts:    hosts,
+		Gateways: []string{rnInfo.RouteGateway},
+		HTTP:     http,
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package utils_test
+
+import (
+	"testing"
+
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	"github.com/kyma-incubator/runtime/pkg/utils"
+	"github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNormalizeRoutePath(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	g.Expect(utils.NormalizeRoutePath("")).To(gomega.Equal("/"))
+	g.Expect(utils.NormalizeRoutePath("orders")).To(gomega.Equal("/orders"))
+	g.Expect(utils.NormalizeRoutePath(" /orders ")).To(gomega.Equal("/orders"))
+}
+
+func TestRouteKey(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	g.Expect(utils.RouteKey(runtimev1alpha1.FunctionRoute{Host: "API.example.com", Path: "orders"})).
+		To(gomega.Equal(utils.RouteKey(runtimev1alpha1.FunctionRoute{Host: "api.example.com", Path: "/orders"})))
+	g.Expect(utils.RouteKey(runtimev1alpha1.FunctionRoute{Host: "api.example.com"})).
+		To(gomega.Equal("api.example.com/"))
+}
+
+func TestGetVirtualServiceSpec(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	fn := &runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foo",
+			Namespace: "bar",
+		},
+		Spec: runtimev1alpha1.FunctionSpec{
+			Routes: []runtimev1alpha1.FunctionRoute{
+				{Host: "api.example.com"},
+				{Host: "api.example.com", Path: "/orders"},
+				{Host: "www.example.com", Path: "/orders/list"},
+			},
+		},
+	}
+	rnInfo := &utils.RuntimeInfo{
+		RouteGateway:     "gateway",
+		RouteDestination: "destination",
+	}
+
+	spec := utils.GetVirtualServiceSpec(fn, rnInfo)
+
+	g.Expect(spec.Hosts).To(gomega.Equal([]string{"www.example.com", "api.example.com"}))
+	g.Expect(spec.Gateways).To(gomega.Equal([]string{"gateway"}))
+	g.Expect(spec.HTTP).To(gomega.HaveLen(3))
+
+	// longest path is matched first
+	g.Expect(spec.HTTP[0].Match[0].Authority.Exact).To(gomega.Equal("www.example.com"))
+	g.Expect(spec.HTTP[0].Match[0].URI.Prefix).To(gomega.Equal("/orders/list"))
+	g.Expect(spec.HTTP[1].Match[0].URI.Prefix).To(gomega.Equal("/orders"))
+	g.Expect(spec.HTTP[2].Match[0].URI.Prefix).To(gomega.Equal("/"))
+
+	for _, route := range spec.HTTP {
+		g.Expect(route.Rewrite.Authority).To(gomega.Equal("foo.bar.svc.cluster.local"))
+		g.Expect(route.Route).To(gomega.HaveLen(1))
+		g.Expect(route.Route[0].Destination.Host).To(gomega.Equal("destination"))
+		g.Expect(route.Route[0].Weight).To(gomega.Equal(100))
+	}
+}
@@ -1,12 +1,18 @@
 package utils
 
 import (
+	"fmt"
+	"strconv"
+
 	servingv1alpha1 "github.com/knative/serving/pkg/apis/serving/v1alpha1"
 	"github.com/knative/serving/pkg/apis/serving/v1beta1"
 	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
 )
 
+// port the runtime of a function listens on
+const functionPort = 8080
+
 // GetServiceSpec gets ServiceSpec for a function
 func GetServiceSpec(imageName string, fn runtimev1alpha1.Function, rnInfo *RuntimeInfo) servingv1alpha1.ServiceSpec {
 
@@ -34,7 +40,7 @@ func GetServiceSpec(imageName string, fn runtimev1alpha1.Function, rnInfo *Runti
 		},
 		{
 			Name:  "FUNC_PORT",
-			Value: "8080",
+			Value: strconv.Itoa(functionPort),
 		},
 		{
 			Name:  "NODE_PATH",
@@ -42,16 +48,32 @@ func GetServiceSpec(imageName string, fn runtimev1alpha1.Function, rnInfo *Runti
 		},
 	}
 
+	// probes of the function override the ones of its runtime
+	livenessProbe, readinessProbe := rnInfo.Probes(fn.Spec.Runtime)
+	if fn.Spec.LivenessProbe != nil {
+		livenessProbe = fn.Spec.LivenessProbe.DeepCopy()
+	}
+	if fn.Spec.ReadinessProbe != nil {
+		readinessProbe = fn.Spec.ReadinessProbe.DeepCopy()
+	}
+
 	configuration := servingv1alpha1.ConfigurationSpec{
 		Template: &servingv1alpha1.RevisionTemplateSpec{
 			Spec: servingv1alpha1.RevisionSpec{
 				RevisionSpec: v1beta1.RevisionSpec{
 					PodSpec: v1beta1.PodSpec{
 						Containers: []corev1.Container{{
-							Image: imageName,
-							Env:   envVarsForRevision,
+							Image:        imageName,
+							Env:          envVarsForRevision,
+							VolumeMounts: fn.Spec.VolumeMounts,
+							Ports: []corev1.ContainerPort{{
+								ContainerPort: functionPort,
+							}},
+							LivenessProbe:  livenessProbe,
+							ReadinessProbe: readinessProbe,
 						}},
-						ServiceAccountName: rnInfo.ServiceAccount,
+						ServiceAccountName: RuntimeServiceAccountName(&fn, rnInfo),
+						Volumes:            fn.Spec.Volumes,
 					},
 				},
 			},
@@ -63,3 +85,19 @@ func GetServiceSpec(imageName string, fn runtimev1alpha1.Function, rnInfo *Runti
 	}
 
 }
+
+// FunctionServiceAccountName returns the name of the service account managed for a function with image pull secrets
+func FunctionServiceAccountName(fn *runtimev1alpha1.Function) string {
+	return fmt.Sprintf("%s-function", fn.Name)
+}
+
+// RuntimeServiceAccountName returns the name of the service account a function runs with
+func RuntimeServiceAccountName(fn *runtimev1alpha1.Function, rnInfo *RuntimeInfo) string {
+	if fn.Spec.ServiceAccountName != "" {
+		return fn.Spec.ServiceAccountName
+	}
+	if len(fn.Spec.ImagePullSecrets) > 0 {
+		return FunctionServiceAccountName(fn)
+	}
+	return rnInfo.RuntimeServiceAccount
+}
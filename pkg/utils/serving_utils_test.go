@@ -4,8 +4,10 @@ import (
 	"github.com/ghodss/yaml"
 	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
 	"github.com/kyma-incubator/runtime/pkg/utils"
+	"github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
 	"testing"
 )
 
@@ -20,6 +22,21 @@ func TestGetServiceSpec(t *testing.T) {
 			FunctionContentType: "plaintext",
 			Size:                "L",
 			Runtime:             "nodejs8",
+			Volumes: []corev1.Volume{
+				{
+					Name: "ca-bundle",
+					VolumeSource: corev1.VolumeSource{
+						ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "ca-bundle"}},
+					},
+				},
+			},
+			VolumeMounts: []corev1.VolumeMount{
+				{
+					Name:      "ca-bundle",
+					MountPath: "/etc/ssl/custom",
+					ReadOnly:  true,
+				},
+			},
 		},
 	}
 
@@ -38,6 +55,14 @@ func TestGetServiceSpec(t *testing.T) {
 	if serviceSpec.ConfigurationSpec.Template.Spec.RevisionSpec.PodSpec.Containers[0].Image != "foo-image" {
 		t.Fatalf("Expected image for RevisionTemplate.Spec.Container.Image: %v Got: %v", "foo-image", serviceSpec.ConfigurationSpec.Template.Spec.RevisionSpec.PodSpec.Containers[0].Image)
 	}
+	// Testing volumes
+	if !reflect.DeepEqual(serviceSpec.ConfigurationSpec.Template.Spec.RevisionSpec.PodSpec.Volumes, fn.Spec.Volumes) {
+		t.Fatalf("Expected volumes for RevisionTemplate.Spec.Volumes: %v Got: %v", fn.Spec.Volumes, serviceSpec.ConfigurationSpec.Template.Spec.RevisionSpec.PodSpec.Volumes)
+	}
+	if !reflect.DeepEqual(serviceSpec.ConfigurationSpec.Template.Spec.RevisionSpec.PodSpec.Containers[0].VolumeMounts, fn.Spec.VolumeMounts) {
+		t.Fatalf("Expected volume mounts for RevisionTemplate.Spec.Container.VolumeMounts: %v Got: %v", fn.Spec.VolumeMounts, serviceSpec.ConfigurationSpec.Template.Spec.RevisionSpec.PodSpec.Containers[0].VolumeMounts)
+	}
+
 	expectedEnv := []corev1.EnvVar{
 		{
 			Name:  "FUNC_HANDLER",
@@ -101,3 +126,79 @@ func getString(obj interface{}) (string, error) {
 	}
 	return string(output), nil
 }
+
+func TestGetServiceSpecProbes(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	customProbe := &corev1.Probe{
+		Handler: corev1.Handler{
+			HTTPGet: &corev1.HTTPGetAction{Path: "/ready"},
+		},
+		InitialDelaySeconds: 30,
+	}
+	rnInfo := &utils.RuntimeInfo{
+		AvailableRuntimes: []utils.RuntimesSupported{
+			{ID: "nodejs6", DockerFileName: "dockerfile-nodejs-6"},
+			{ID: "nodejs8", DockerFileName: "dockerfile-nodejs-8", ReadinessProbe: customProbe},
+		},
+	}
+
+	// every runtime gets a liveness and readiness probe on the health endpoint of the runtime
+	for _, rt := range rnInfo.AvailableRuntimes {
+		fn := runtimev1alpha1.Function{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+			Spec:       runtimev1alpha1.FunctionSpec{Runtime: rt.ID},
+		}
+		container := utils.GetServiceSpec("foo-image", fn, rnInfo).ConfigurationSpec.Template.Spec.RevisionSpec.PodSpec.Containers[0]
+
+		g.Expect(container.Ports).To(gomega.Equal([]corev1.ContainerPort{{ContainerPort: 8080}}), rt.ID)
+		g.Expect(container.LivenessProbe).NotTo(gomega.BeNil(), rt.ID)
+		g.Expect(container.LivenessProbe.HTTPGet.Path).To(gomega.Equal("/healthz"), rt.ID)
+		g.Expect(container.LivenessProbe.HTTPGet.Port.IntValue()).To(gomega.Equal(0), rt.ID)
+		g.Expect(container.LivenessProbe.InitialDelaySeconds).To(gomega.BeEquivalentTo(10), rt.ID)
+		g.Expect(container.ReadinessProbe).NotTo(gomega.BeNil(), rt.ID)
+
+		if rt.ReadinessProbe != nil {
+			g.Expect(container.ReadinessProbe).To(gomega.Equal(rt.ReadinessProbe), rt.ID)
+		} else {
+			g.Expect(container.ReadinessProbe.HTTPGet.Path).To(gomega.Equal("/healthz"), rt.ID)
+			g.Expect(container.ReadinessProbe.PeriodSeconds).To(gomega.BeEquivalentTo(5), rt.ID)
+		}
+	}
+
+	// probes of a function override the ones of the runtime
+	fn := runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+		Spec: runtimev1alpha1.FunctionSpec{
+			Runtime:       "nodejs6",
+			LivenessProbe: customProbe,
+		},
+	}
+	container := utils.GetServiceSpec("foo-image", fn, rnInfo).ConfigurationSpec.Template.Spec.RevisionSpec.PodSpec.Containers[0]
+	g.Expect(container.LivenessProbe).To(gomega.Equal(customProbe))
+	g.Expect(container.ReadinessProbe.HTTPGet.Path).To(gomega.Equal("/healthz"))
+}
+
+func TestRuntimeServiceAccountName(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	rnInfo := &utils.RuntimeInfo{
+		BuildServiceAccount:   "build",
+		RuntimeServiceAccount: "runtime",
+	}
+	fn := runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+	}
+
+	g.Expect(utils.RuntimeServiceAccountName(&fn, rnInfo)).To(gomega.Equal("runtime"))
+	g.Expect(utils.GetServiceSpec("foo-image", fn, rnInfo).ConfigurationSpec.Template.Spec.RevisionSpec.PodSpec.ServiceAccountName).
+		To(gomega.Equal("runtime"))
+
+	fn.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "registry"}}
+	g.Expect(utils.RuntimeServiceAccountName(&fn, rnInfo)).To(gomega.Equal("foo-function"))
+
+	fn.Spec.ImagePullSecrets = nil
+	fn.Spec.ServiceAccountName = "orders"
+	g.Expect(utils.RuntimeServiceAccountName(&fn, rnInfo)).To(gomega.Equal("orders"))
+	g.Expect(utils.GetServiceSpec("foo-image", fn, rnInfo).ConfigurationSpec.Template.Spec.RevisionSpec.PodSpec.ServiceAccountName).
+		To(gomega.Equal("orders"))
+}
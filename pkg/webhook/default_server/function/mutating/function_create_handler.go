@@ -22,6 +22,9 @@ import (
 	"strings"
 
 	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	"github.com/kyma-incubator/runtime/pkg/metrics"
+	runtimeUtil "github.com/kyma-incubator/runtime/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
@@ -31,14 +34,15 @@ import (
 
 var (
 	functionSizes        = []string{"S", "M", "L", "XL"}
-	runtimes             = []string{"nodejs6", "nodejs8"}
 	functionContentTypes = []string{"plaintext", "base64"}
 	log                  = logf.Log.WithName("webhook")
 )
 
+// name of the webhook, it labels the admission decisions in the metrics
+const webhookName = "mutating-create-function"
+
 func init() {
 	log.Info("init")
-	webhookName := "mutating-create-function"
 	if HandlerMap[webhookName] == nil {
 		HandlerMap[webhookName] = []admission.Handler{}
 	}
@@ -69,8 +73,28 @@ func (h *FunctionCreateHandler) mutatingFunctionFn(obj *runtimev1alpha1.Function
 	}
 }
 
-// Validate function values and return an error if the function is not valid
-func (h *FunctionCreateHandler) validateFunctionFn(obj *runtimev1alpha1.Function) error {
+// Read the runtimes of the function controller's configuration
+func (h *FunctionCreateHandler) availableRuntimes(ctx context.Context) ([]string, error) {
+	fnConfig := &corev1.ConfigMap{}
+	err := h.Client.Get(ctx, runtimeUtil.ControllerConfigMapKey(), fnConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	rnInfo, err := runtimeUtil.New(fnConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	runtimes := []string{}
+	for _, rt := range rnInfo.AvailableRuntimes {
+		runtimes = append(runtimes, rt.ID)
+	}
+	return runtimes, nil
+}
+
+// Validate function values against the available runtimes and return an error if the function is not valid
+func (h *FunctionCreateHandler) validateFunctionFn(obj *runtimev1alpha1.Function, runtimes []string) error {
 	// function size
 	isValidFunctionSize := false
 	for _, functionSize := range functionSizes {
@@ -113,8 +137,9 @@ func (h *FunctionCreateHandler) validateFunctionFn(obj *runtimev1alpha1.Function
 var _ admission.Handler = &FunctionCreateHandler{}
 
 // Handle handles admission requests.
-func (h *FunctionCreateHandler) Handle(ctx context.Context, req types.Request) types.Response {
+func (h *FunctionCreateHandler) Handle(ctx context.Context, req types.Request) (resp types.Response) {
 	log.Info("received admission request", "request", req)
+	defer func() { metrics.AdmissionDecision(webhookName, req, resp) }()
 
 	obj := &runtimev1alpha1.Function{}
 
@@ -127,8 +152,13 @@ func (h *FunctionCreateHandler) Handle(ctx context.Context, req types.Request) t
 	// mutate values
 	h.mutatingFunctionFn(copy)
 
+	runtimes, err := h.availableRuntimes(ctx)
+	if err != nil {
+		return admission.ErrorResponse(http.StatusInternalServerError, err)
+	}
+
 	// validate function and return an error describing the validation error if validation fails
-	err = h.validateFunctionFn(copy)
+	err = h.validateFunctionFn(copy, runtimes)
 	if err != nil {
 		return admission.ErrorResponse(http.StatusInternalServerError, err)
 	}
@@ -16,12 +16,31 @@ import (
 	"context"
 
 	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 var functionCreateHandler = FunctionCreateHandler{}
 
+// configuration of the function controller, it lists the available runtimes
+func newFnConfig() *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "fn-config", Namespace: "default"},
+		Data: map[string]string{
+			"dockerRegistry":     "test",
+			"serviceAccountName": "build-bot",
+			"runtimes": `
+- ID: nodejs8
+  dockerFileName: dockerfile-nodejs-8
+- ID: nodejs6
+  dockerFileName: dockerfile-nodejs-6
+`,
+		},
+	}
+}
+
 // Test that an empty function gets all default values set
 func TestMutation(t *testing.T) {
 	g := gomega.NewGomegaWithT(t)
@@ -46,9 +65,35 @@ func TestMutation(t *testing.T) {
 	}))
 }
 
+// Test that the runtimes are read from the configuration of the function controller
+func TestAvailableRuntimes(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	// no runtimes without configuration
+	handler := FunctionCreateHandler{Client: fake.NewFakeClient()}
+	_, err := handler.availableRuntimes(context.TODO())
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	handler = FunctionCreateHandler{Client: fake.NewFakeClient(newFnConfig())}
+	runtimes, err := handler.availableRuntimes(context.TODO())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(runtimes).To(gomega.Equal([]string{"nodejs8", "nodejs6"}))
+
+	// a runtime added to the configuration is available
+	fnConfig := newFnConfig()
+	fnConfig.Data["runtimes"] += `- ID: python3
+  dockerFileName: dockerfile-python-3
+`
+	handler = FunctionCreateHandler{Client: fake.NewFakeClient(fnConfig)}
+	runtimes, err = handler.availableRuntimes(context.TODO())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(runtimes).To(gomega.Equal([]string{"nodejs8", "nodejs6", "python3"}))
+}
+
 // Test that all values get validated
 func TestValidation(t *testing.T) {
 	g := gomega.NewWithT(t)
+	runtimes := []string{"nodejs8", "nodejs6"}
 
 	// wrong runtime
 	function := &runtimev1alpha1.Function{
@@ -59,7 +104,11 @@ func TestValidation(t *testing.T) {
 			Runtime:             "nodejs4",
 		},
 	}
-	g.Expect(functionCreateHandler.validateFunctionFn(function)).To(gomega.MatchError("runtime should be one of 'nodejs6,nodejs8'"))
+	g.Expect(functionCreateHandler.validateFunctionFn(function, runtimes)).To(gomega.MatchError("runtime should be one of 'nodejs8,nodejs6'"))
+
+	// runtimes of the configuration are valid
+	function.Spec.Runtime = "python3"
+	g.Expect(functionCreateHandler.validateFunctionFn(function, append(runtimes, "python3"))).NotTo(gomega.HaveOccurred())
 
 	// wrong size
 	function = &runtimev1alpha1.Function{
@@ -70,7 +119,7 @@ func TestValidation(t *testing.T) {
 			Runtime:             "nodejs8",
 		},
 	}
-	g.Expect(functionCreateHandler.validateFunctionFn(function)).To(gomega.MatchError("size should be one of 'S,M,L,XL'"))
+	g.Expect(functionCreateHandler.validateFunctionFn(function, runtimes)).To(gomega.MatchError("size should be one of 'S,M,L,XL'"))
 
 	// wrong functionContentType
 	function = &runtimev1alpha1.Function{
@@ -81,7 +130,7 @@ func TestValidation(t *testing.T) {
 			Runtime:             "nodejs8",
 		},
 	}
-	g.Expect(functionCreateHandler.validateFunctionFn(function)).To(gomega.MatchError("functionContentType should be one of 'plaintext,base64'"))
+	g.Expect(functionCreateHandler.validateFunctionFn(function, runtimes)).To(gomega.MatchError("functionContentType should be one of 'plaintext,base64'"))
 
 }
 
@@ -96,6 +145,7 @@ func TestHandleInvalid(t *testing.T) {
 	}
 
 	functionCreateHandler := FunctionCreateHandler{
+		Client:  fake.NewFakeClient(newFnConfig()),
 		Decoder: admissionDecoder,
 	}
 
@@ -171,6 +221,7 @@ func TestHandleDefaults(t *testing.T) {
 	}
 
 	functionCreateHandler := FunctionCreateHandler{
+		Client:  fake.NewFakeClient(newFnConfig()),
 		Decoder: admissionDecoder,
 	}
 
@@ -0,0 +1,360 @@
+/*
+Copyright 2019 The Kyma Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validating
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"reflect"
+	"strings"
+
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	"github.com/kyma-incubator/runtime/pkg/metrics"
+	runtimeUtil "github.com/kyma-incubator/runtime/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+var (
+	// runtime paths of the function's container which must not be shadowed by volume mounts
+	reservedMountPaths = []string{"/kubeless", "/kubeless.js", "/node_modules"}
+	log                = logf.Log.WithName("webhook")
+
+	// mount paths Knative Serving reserves for the containers of revisions
+	knativeReservedMountPaths = []string{"/", "/dev", "/dev/log", "/tmp", "/var", "/var/log"}
+)
+
+// name of the webhook, it labels the admission decisions in the metrics
+const webhookName = "validating-create-update-function"
+
+func init() {
+	if HandlerMap[webhookName] == nil {
+		HandlerMap[webhookName] = []admission.Handler{}
+	}
+	HandlerMap[webhookName] = append(HandlerMap[webhookName], &FunctionCreateUpdateHandler{})
+}
+
+// FunctionCreateUpdateHandler handles Function
+type FunctionCreateUpdateHandler struct {
+	Client client.Client
+
+	// Decoder decodes objects
+	Decoder types.Decoder
+}
+
+// Validate function values which depend on the state of the cluster
+func (h *FunctionCreateUpdateHandler) validatingFunctionFn(ctx context.Context, obj *runtimev1alpha1.Function) (bool, string, error) {
+	validators := []func(*runtimev1alpha1.Function) (bool, string){
+		validateRoutes,
+		validateVolumes,
+		validateProbes,
+		validateBuild,
+		validateDepsLock,
+	}
+	for _, validate := range validators {
+		if allowed, reason := validate(obj); !allowed {
+			return allowed, reason, nil
+		}
+	}
+
+	clusterValidators := []func(context.Context, *runtimev1alpha1.Function) (bool, string, error){
+		h.validateRoutesConflicts,
+		h.validateServiceAccount,
+		h.validateBuildTimeout,
+	}
+	for _, validate := range clusterValidators {
+		if allowed, reason, err := validate(ctx, obj); err != nil || !allowed {
+			return allowed, reason, err
+		}
+	}
+
+	return true, "allowed to be admitted", nil
+}
+
+// Validate the routes of a function on their own
+func validateRoutes(obj *runtimev1alpha1.Function) (bool, string) {
+	routes := map[string]bool{}
+	for _, route := range obj.Spec.Routes {
+		if strings.TrimSpace(route.Host) == "" {
+			return false, "route host must not be empty"
+		}
+
+		key := runtimeUtil.RouteKey(route)
+		if routes[key] {
+			return false, fmt.Sprintf("route '%s' is defined more than once", key)
+		}
+		routes[key] = true
+	}
+
+	return true, "allowed to be admitted"
+}
+
+// Validate the volumes and volume mounts of a function. Knative Serving only allows read-only mounts of configMap and
+// secret volumes, and runtime paths of the function's container must not be shadowed.
+func validateVolumes(obj *runtimev1alpha1.Function) (bool, string) {
+	volumes := map[string]bool{}
+	for _, volume := range obj.Spec.Volumes {
+		if volume.Name == "" {
+			return false, "volume name must not be empty"
+		}
+		if volumes[volume.Name] {
+			return false, fmt.Sprintf("volume '%s' is defined more than once", volume.Name)
+		}
+		volumes[volume.Name] = true
+
+		source := volume.VolumeSource
+		if source.ConfigMap == nil && source.Secret == nil {
+			return false, fmt.Sprintf("volume '%s' should be one of 'configMap,secret'", volume.Name)
+		}
+
+		// the allowed sources are pointers, a volume must not set any other source besides them
+		source.ConfigMap, source.Secret = nil, nil
+		if !reflect.DeepEqual(source, corev1.VolumeSource{}) {
+			return false, fmt.Sprintf("volume '%s' should be one of 'configMap,secret'", volume.Name)
+		}
+	}
+
+	for _, mount := range obj.Spec.VolumeMounts {
+		if !volumes[mount.Name] {
+			return false, fmt.Sprintf("volume mount '%s' references an unknown volume", mount.Name)
+		}
+		if !path.IsAbs(mount.MountPath) {
+			return false, fmt.Sprintf("volume mount '%s' must have an absolute mount path", mount.Name)
+		}
+
+		if !mount.ReadOnly {
+			return false, fmt.Sprintf("volume mount '%s' must be readOnly", mount.Name)
+		}
+
+		mountPath := path.Clean(mount.MountPath)
+		for _, reserved := range knativeReservedMountPaths {
+			if mountPath == reserved {
+				return false, fmt.Sprintf("volume mount '%s' must not be mounted on '%v'", mount.Name, strings.Join(knativeReservedMountPaths, ","))
+			}
+		}
+		for _, reserved := range reservedMountPaths {
+			if mountPath == reserved || strings.HasPrefix(mountPath, reserved+"/") || strings.HasPrefix(reserved, mountPath+"/") {
+				return false, fmt.Sprintf("volume mount '%s' must not be mounted over '%v'", mount.Name, strings.Join(reservedMountPaths, ","))
+			}
+		}
+	}
+
+	return true, "allowed to be admitted"
+}
+
+// Validate the probes of a function. Knative sends probes to the port of the function's container, so they must not define a port.
+func validateProbes(obj *runtimev1alpha1.Function) (bool, string) {
+	probes := map[string]*corev1.Probe{
+		"livenessProbe":  obj.Spec.LivenessProbe,
+		"readinessProbe": obj.Spec.ReadinessProbe,
+	}
+	for name, probe := range probes {
+		if probe == nil {
+			continue
+		}
+		if probe.HTTPGet != nil && probe.HTTPGet.Port != (intstr.IntOrString{}) {
+			return false, fmt.Sprintf("%s must not define a port", name)
+		}
+		if probe.TCPSocket != nil && probe.TCPSocket.Port != (intstr.IntOrString{}) {
+			return false, fmt.Sprintf("%s must not define a port", name)
+		}
+	}
+
+	return true, "allowed to be admitted"
+}
+
+// Validate the build settings of a function on their own
+func validateBuild(obj *runtimev1alpha1.Function) (bool, string) {
+	if obj.Spec.Build != nil && obj.Spec.Build.Timeout != nil && obj.Spec.Build.Timeout.Duration <= 0 {
+		return false, "build timeout must be positive"
+	}
+	if obj.Spec.Build != nil && obj.Spec.Build.NpmrcSecretRef != nil && obj.Spec.Build.NpmrcSecretRef.Name == "" {
+		return false, "npmrc secret reference must have a name"
+	}
+
+	return true, "allowed to be admitted"
+}
+
+// Validate that the lockfile of a function belongs to its dependencies
+func validateDepsLock(obj *runtimev1alpha1.Function) (bool, string) {
+	if strings.TrimSpace(obj.Spec.DepsLock) == "" {
+		return true, "allowed to be admitted"
+	}
+	if strings.TrimSpace(obj.Spec.Deps) == "" {
+		return false, "deps lockfile requires deps"
+	}
+
+	type packageIdentity struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	deps, lock := packageIdentity{}, packageIdentity{}
+	if err := json.Unmarshal([]byte(obj.Spec.Deps), &deps); err != nil {
+		return false, fmt.Sprintf("deps must be a valid package.json: %v", err)
+	}
+	if err := json.Unmarshal([]byte(obj.Spec.DepsLock), &lock); err != nil {
+		return false, fmt.Sprintf("deps lockfile must be a valid package-lock.json: %v", err)
+	}
+	if deps.Name != lock.Name || deps.Version != lock.Version {
+		return false, fmt.Sprintf("deps lockfile of '%s@%s' doesn't match deps '%s@%s'", lock.Name, lock.Version, deps.Name, deps.Version)
+	}
+
+	return true, "allowed to be admitted"
+}
+
+// Reject build timeouts above the maximum build timeout of the function controller's configuration. A configuration
+// which doesn't parse doesn't block functions, the controller keeps the last good one and caps build timeouts with it.
+func (h *FunctionCreateUpdateHandler) validateBuildTimeout(ctx context.Context, obj *runtimev1alpha1.Function) (bool, string, error) {
+	if obj.Spec.Build == nil || obj.Spec.Build.Timeout == nil {
+		return true, "allowed to be admitted", nil
+	}
+
+	fnConfig := &corev1.ConfigMap{}
+	err := h.Client.Get(ctx, runtimeUtil.ControllerConfigMapKey(), fnConfig)
+	if errors.IsNotFound(err) {
+		// there is no maximum without configuration
+		return true, "allowed to be admitted", nil
+	} else if err != nil {
+		return false, "", err
+	}
+
+	rnInfo, err := runtimeUtil.New(fnConfig)
+	if err != nil {
+		log.Error(err, "Skipping the maximum build timeout of the invalid configuration", "namespace", fnConfig.Namespace, "name", fnConfig.Name)
+		return true, "allowed to be admitted", nil
+	}
+
+	if rnInfo.MaxBuildTimeout > 0 && obj.Spec.Build.Timeout.Duration > rnInfo.MaxBuildTimeout {
+		return false, fmt.Sprintf("build timeout '%s' exceeds the maximum build timeout '%s'", obj.Spec.Build.Timeout.Duration, rnInfo.MaxBuildTimeout), nil
+	}
+
+	return true, "allowed to be admitted", nil
+}
+
+// Reject routes which are already claimed by another function
+func (h *FunctionCreateUpdateHandler) validateRoutesConflicts(ctx context.Context, obj *runtimev1alpha1.Function) (bool, string, error) {
+	if len(obj.Spec.Routes) == 0 {
+		return true, "allowed to be admitted", nil
+	}
+
+	functions := &runtimev1alpha1.FunctionList{}
+	if err := h.Client.List(ctx, &client.ListOptions{}, functions); err != nil {
+		return false, "", err
+	}
+
+	claimed := map[string]runtimev1alpha1.Function{}
+	for _, fn := range functions.Items {
+		if fn.Namespace == obj.Namespace && fn.Name == obj.Name {
+			continue
+		}
+		for _, route := range fn.Spec.Routes {
+			claimed[runtimeUtil.RouteKey(route)] = fn
+		}
+	}
+
+	for _, route := range obj.Spec.Routes {
+		key := runtimeUtil.RouteKey(route)
+		if fn, ok := claimed[key]; ok {
+			return false, fmt.Sprintf("route '%s' is already claimed by function %s/%s", key, fn.Namespace, fn.Name), nil
+		}
+	}
+
+	return true, "allowed to be admitted", nil
+}
+
+// Reject service accounts and image pull secrets which don't exist in the namespace of the function
+func (h *FunctionCreateUpdateHandler) validateServiceAccount(ctx context.Context, obj *runtimev1alpha1.Function) (bool, string, error) {
+	if obj.Spec.ServiceAccountName != "" && len(obj.Spec.ImagePullSecrets) > 0 {
+		return false, "imagePullSecrets can't be combined with serviceAccountName, add them to the service account instead", nil
+	}
+
+	if obj.Spec.ServiceAccountName != "" {
+		sa := &corev1.ServiceAccount{}
+		err := h.Client.Get(ctx, apitypes.NamespacedName{Name: obj.Spec.ServiceAccountName, Namespace: obj.Namespace}, sa)
+		if errors.IsNotFound(err) {
+			return false, fmt.Sprintf("service account '%s' does not exist in namespace '%s'", obj.Spec.ServiceAccountName, obj.Namespace), nil
+		} else if err != nil {
+			return false, "", err
+		}
+	}
+
+	for _, pullSecret := range obj.Spec.ImagePullSecrets {
+		secret := &corev1.Secret{}
+		err := h.Client.Get(ctx, apitypes.NamespacedName{Name: pullSecret.Name, Namespace: obj.Namespace}, secret)
+		if errors.IsNotFound(err) {
+			return false, fmt.Sprintf("image pull secret '%s' does not exist in namespace '%s'", pullSecret.Name, obj.Namespace), nil
+		} else if err != nil {
+			return false, "", err
+		}
+		if secret.Type != corev1.SecretTypeDockerConfigJson && secret.Type != corev1.SecretTypeDockercfg {
+			return false, fmt.Sprintf("image pull secret '%s' should be of type '%s,%s'", pullSecret.Name, corev1.SecretTypeDockerConfigJson, corev1.SecretTypeDockercfg), nil
+		}
+	}
+
+	return true, "allowed to be admitted", nil
+}
+
+var _ admission.Handler = &FunctionCreateUpdateHandler{}
+
+// Handle handles admission requests.
+func (h *FunctionCreateUpdateHandler) Handle(ctx context.Context, req types.Request) (resp types.Response) {
+	log.Info("received admission request", "request", req)
+	defer func() { metrics.AdmissionDecision(webhookName, req, resp) }()
+
+	obj := &runtimev1alpha1.Function{}
+
+	err := h.Decoder.Decode(req, obj)
+	if err != nil {
+		return admission.ErrorResponse(http.StatusBadRequest, err)
+	}
+	if obj.Namespace == "" {
+		obj.Namespace = req.AdmissionRequest.Namespace
+	}
+
+	allowed, reason, err := h.validatingFunctionFn(ctx, obj)
+	if err != nil {
+		return admission.ErrorResponse(http.StatusInternalServerError, err)
+	}
+
+	return admission.ValidationResponse(allowed, reason)
+}
+
+var _ inject.Client = &FunctionCreateUpdateHandler{}
+
+// InjectClient injects the client into the FunctionCreateUpdateHandler
+func (h *FunctionCreateUpdateHandler) InjectClient(c client.Client) error {
+	h.Client = c
+	return nil
+}
+
+var _ inject.Decoder = &FunctionCreateUpdateHandler{}
+
+// InjectDecoder injects the decoder into the FunctionCreateUpdateHandler
+func (h *FunctionCreateUpdateHandler) InjectDecoder(d types.Decoder) error {
+	h.Decoder = d
+	return nil
+}
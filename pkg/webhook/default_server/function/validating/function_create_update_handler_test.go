@@ -0,0 +1,384 @@
+package validating
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	runtimev1alpha1 "github.com/kyma-incubator/runtime/pkg/apis/runtime/v1alpha1"
+	"github.com/onsi/gomega"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+func init() {
+	runtimev1alpha1.AddToScheme(scheme.Scheme)
+}
+
+func newFunction(namespace, name string, routes ...runtimev1alpha1.FunctionRoute) *runtimev1alpha1.Function {
+	return &runtimev1alpha1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: runtimev1alpha1.FunctionSpec{
+			FunctionContentType: "plaintext",
+			Function:            "foo",
+			Size:                "S",
+			Runtime:             "nodejs8",
+			Routes:              routes,
+		},
+	}
+}
+
+// Test that routes are validated on their own
+func TestValidateRoutes(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	allowed, _ := validateRoutes(newFunction("default", "foo", runtimev1alpha1.FunctionRoute{Host: "api.example.com", Path: "/orders"}))
+	g.Expect(allowed).To(gomega.BeTrue())
+
+	allowed, reason := validateRoutes(newFunction("default", "foo", runtimev1alpha1.FunctionRoute{Path: "/orders"}))
+	g.Expect(allowed).To(gomega.BeFalse())
+	g.Expect(reason).To(gomega.Equal("route host must not be empty"))
+
+	allowed, reason = validateRoutes(newFunction("default", "foo",
+		runtimev1alpha1.FunctionRoute{Host: "api.example.com", Path: "/orders"},
+		runtimev1alpha1.FunctionRoute{Host: "API.example.com", Path: "orders"},
+	))
+	g.Expect(allowed).To(gomega.BeFalse())
+	g.Expect(reason).To(gomega.Equal("route 'api.example.com/orders' is defined more than once"))
+}
+
+// Test that only allowed volume sources and mount paths are accepted
+func TestValidateVolumes(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	function := newFunction("default", "foo")
+	function.Spec.Volumes = []corev1.Volume{
+		{
+			Name: "ca-bundle",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "ca-bundle"}},
+			},
+		},
+		{
+			Name:         "model",
+			VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "model"}},
+		},
+	}
+	function.Spec.VolumeMounts = []corev1.VolumeMount{
+		{Name: "ca-bundle", MountPath: "/etc/ssl/custom", ReadOnly: true},
+		{Name: "model", MountPath: "/tmp/model", ReadOnly: true},
+	}
+	allowed, _ := validateVolumes(function)
+	g.Expect(allowed).To(gomega.BeTrue())
+
+	// volume sources which Knative Serving doesn't allow
+	for name, source := range map[string]corev1.VolumeSource{
+		"hostPath":  {HostPath: &corev1.HostPathVolumeSource{Path: "/var/run"}},
+		"emptyDir":  {EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		"projected": {Projected: &corev1.ProjectedVolumeSource{}},
+	} {
+		notAllowed := function.DeepCopy()
+		notAllowed.Spec.Volumes[1].VolumeSource = source
+		allowed, reason := validateVolumes(notAllowed)
+		g.Expect(allowed).To(gomega.BeFalse(), name)
+		g.Expect(reason).To(gomega.Equal("volume 'model' should be one of 'configMap,secret'"), name)
+	}
+
+	// allowed volume source combined with one which is not allowed
+	mixed := function.DeepCopy()
+	mixed.Spec.Volumes[1].VolumeSource.EmptyDir = &corev1.EmptyDirVolumeSource{}
+	allowed, _ = validateVolumes(mixed)
+	g.Expect(allowed).To(gomega.BeFalse())
+
+	// mount of an unknown volume
+	unknown := function.DeepCopy()
+	unknown.Spec.VolumeMounts[0].Name = "foo"
+	allowed, reason := validateVolumes(unknown)
+	g.Expect(allowed).To(gomega.BeFalse())
+	g.Expect(reason).To(gomega.Equal("volume mount 'foo' references an unknown volume"))
+
+	// writable mount
+	writable := function.DeepCopy()
+	writable.Spec.VolumeMounts[1].ReadOnly = false
+	allowed, reason = validateVolumes(writable)
+	g.Expect(allowed).To(gomega.BeFalse())
+	g.Expect(reason).To(gomega.Equal("volume mount 'model' must be readOnly"))
+
+	// mounts over runtime paths
+	for _, mountPath := range []string{"/kubeless", "/kubeless/", "/kubeless/lib", "/node_modules"} {
+		reserved := function.DeepCopy()
+		reserved.Spec.VolumeMounts[1].MountPath = mountPath
+		allowed, reason = validateVolumes(reserved)
+		g.Expect(allowed).To(gomega.BeFalse(), mountPath)
+		g.Expect(reason).To(gomega.Equal("volume mount 'model' must not be mounted over '/kubeless,/kubeless.js,/node_modules'"))
+	}
+
+	// mounts on paths reserved by Knative Serving
+	for _, mountPath := range []string{"/", "/dev", "/dev/log", "/tmp/", "/var", "/var/log"} {
+		reserved := function.DeepCopy()
+		reserved.Spec.VolumeMounts[1].MountPath = mountPath
+		allowed, reason = validateVolumes(reserved)
+		g.Expect(allowed).To(gomega.BeFalse(), mountPath)
+		g.Expect(reason).To(gomega.Equal("volume mount 'model' must not be mounted on '/,/dev,/dev/log,/tmp,/var,/var/log'"))
+	}
+
+	// relative mount path
+	relative := function.DeepCopy()
+	relative.Spec.VolumeMounts[1].MountPath = "tmp/model"
+	allowed, reason = validateVolumes(relative)
+	g.Expect(allowed).To(gomega.BeFalse())
+	g.Expect(reason).To(gomega.Equal("volume mount 'model' must have an absolute mount path"))
+}
+
+// Test that probes of a function must not define a port
+func TestValidateProbes(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	function := newFunction("default", "foo")
+	function.Spec.LivenessProbe = &corev1.Probe{
+		Handler: corev1.Handler{
+			HTTPGet: &corev1.HTTPGetAction{Path: "/healthz"},
+		},
+	}
+	allowed, _ := validateProbes(function)
+	g.Expect(allowed).To(gomega.BeTrue())
+
+	function.Spec.ReadinessProbe = &corev1.Probe{
+		Handler: corev1.Handler{
+			HTTPGet: &corev1.HTTPGetAction{Path: "/healthz", Port: intstr.FromInt(8080)},
+		},
+	}
+	allowed, reason := validateProbes(function)
+	g.Expect(allowed).To(gomega.BeFalse())
+	g.Expect(reason).To(gomega.Equal("readinessProbe must not define a port"))
+}
+
+// Test that two functions can not claim the same host and path
+func TestValidateRoutesConflicts(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	existing := newFunction("other", "bar", runtimev1alpha1.FunctionRoute{Host: "api.example.com", Path: "/orders"})
+	handler := FunctionCreateUpdateHandler{Client: fake.NewFakeClient(existing)}
+
+	// same host and path in another function
+	allowed, reason, err := handler.validatingFunctionFn(context.TODO(),
+		newFunction("default", "foo", runtimev1alpha1.FunctionRoute{Host: "api.example.com", Path: "/orders"}))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(allowed).To(gomega.BeFalse())
+	g.Expect(reason).To(gomega.Equal("route 'api.example.com/orders' is already claimed by function other/bar"))
+
+	// same host with another path
+	allowed, _, err = handler.validatingFunctionFn(context.TODO(),
+		newFunction("default", "foo", runtimev1alpha1.FunctionRoute{Host: "api.example.com", Path: "/invoices"}))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(allowed).To(gomega.BeTrue())
+
+	// updating the function which owns the route
+	allowed, _, err = handler.validatingFunctionFn(context.TODO(), existing)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(allowed).To(gomega.BeTrue())
+}
+
+// Test that service accounts and image pull secrets must exist in the namespace of the function
+func TestValidateServiceAccount(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	handler := FunctionCreateUpdateHandler{Client: fake.NewFakeClient(
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "default"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "default"}, Type: corev1.SecretTypeDockerConfigJson},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "token", Namespace: "default"}, Type: corev1.SecretTypeOpaque},
+	)}
+
+	function := newFunction("default", "foo")
+	function.Spec.ServiceAccountName = "orders"
+	allowed, _, err := handler.validateServiceAccount(context.TODO(), function)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(allowed).To(gomega.BeTrue())
+
+	// service account in another namespace
+	function = newFunction("other", "foo")
+	function.Spec.ServiceAccountName = "orders"
+	allowed, reason, err := handler.validateServiceAccount(context.TODO(), function)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(allowed).To(gomega.BeFalse())
+	g.Expect(reason).To(gomega.Equal("service account 'orders' does not exist in namespace 'other'"))
+
+	function = newFunction("default", "foo")
+	function.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "registry"}}
+	allowed, _, err = handler.validateServiceAccount(context.TODO(), function)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(allowed).To(gomega.BeTrue())
+
+	// image pull secrets and service account
+	function.Spec.ServiceAccountName = "orders"
+	allowed, _, err = handler.validateServiceAccount(context.TODO(), function)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(allowed).To(gomega.BeFalse())
+
+	function = newFunction("default", "foo")
+	function.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "missing"}}
+	allowed, reason, err = handler.validateServiceAccount(context.TODO(), function)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(allowed).To(gomega.BeFalse())
+	g.Expect(reason).To(gomega.Equal("image pull secret 'missing' does not exist in namespace 'default'"))
+
+	function.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "token"}}
+	allowed, _, err = handler.validateServiceAccount(context.TODO(), function)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(allowed).To(gomega.BeFalse())
+}
+
+// Test that build timeouts are positive and don't exceed the maximum of the configuration
+func TestValidateBuildTimeout(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	function := newFunction("default", "foo")
+	function.Spec.Build = &runtimev1alpha1.FunctionBuildSpec{Timeout: &metav1.Duration{Duration: -time.Minute}}
+	allowed, reason := validateBuild(function)
+	g.Expect(allowed).To(gomega.BeFalse())
+	g.Expect(reason).To(gomega.Equal("build timeout must be positive"))
+
+	// no maximum without configuration
+	function.Spec.Build.Timeout = &metav1.Duration{Duration: 2 * time.Hour}
+	handler := FunctionCreateUpdateHandler{Client: fake.NewFakeClient()}
+	allowed, _, err := handler.validateBuildTimeout(context.TODO(), function)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(allowed).To(gomega.BeTrue())
+
+	handler = FunctionCreateUpdateHandler{Client: fake.NewFakeClient(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "fn-config", Namespace: "default"},
+		Data: map[string]string{
+			"dockerRegistry":     "test",
+			"serviceAccountName": "build-bot",
+			"maxBuildTimeout":    "60",
+		},
+	})}
+	allowed, reason, err = handler.validateBuildTimeout(context.TODO(), function)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(allowed).To(gomega.BeFalse())
+	g.Expect(reason).To(gomega.Equal("build timeout '2h0m0s' exceeds the maximum build timeout '1h0m0s'"))
+
+	function.Spec.Build.Timeout = &metav1.Duration{Duration: 45 * time.Minute}
+	allowed, _, err = handler.validateBuildTimeout(context.TODO(), function)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(allowed).To(gomega.BeTrue())
+
+	// an invalid configuration doesn't block functions, the controller caps the timeout with its last good one
+	function.Spec.Build.Timeout = &metav1.Duration{Duration: 2 * time.Hour}
+	handler = FunctionCreateUpdateHandler{Client: fake.NewFakeClient(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "fn-config", Namespace: "default"},
+		Data: map[string]string{
+			"dockerRegistry":     "test",
+			"serviceAccountName": "build-bot",
+			"maxBuildTimeout":    "60",
+			"maxBuildRetries":    "-1",
+		},
+	})}
+	allowed, _, err = handler.validateBuildTimeout(context.TODO(), function)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(allowed).To(gomega.BeTrue())
+
+	// functions without build timeout use the default build timeout
+	function.Spec.Build = nil
+	allowed, _, err = handler.validateBuildTimeout(context.TODO(), function)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(allowed).To(gomega.BeTrue())
+}
+
+func TestValidateBuildNpmrc(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	function := newFunction("default", "foo")
+	function.Spec.Build = &runtimev1alpha1.FunctionBuildSpec{NpmrcSecretRef: &corev1.LocalObjectReference{}}
+	allowed, reason := validateBuild(function)
+	g.Expect(allowed).To(gomega.BeFalse())
+	g.Expect(reason).To(gomega.Equal("npmrc secret reference must have a name"))
+
+	function.Spec.Build.NpmrcSecretRef.Name = "npmrc"
+	allowed, _ = validateBuild(function)
+	g.Expect(allowed).To(gomega.BeTrue())
+}
+
+func TestValidateDepsLock(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	deps := `{"name": "orders", "version": "1.2.0", "dependencies": {"lodash": "^4.17.0"}}`
+	tests := []struct {
+		deps     string
+		depsLock string
+		allowed  bool
+		reason   string
+	}{
+		{deps: deps, allowed: true},
+		{deps: deps, depsLock: `{"name": "orders", "version": "1.2.0", "lockfileVersion": 1, "dependencies": {"lodash": {"version": "4.17.11"}}}`, allowed: true},
+		{deps: deps, depsLock: `{"name": "orders", "version": "1.1.0", "lockfileVersion": 1}`, reason: "deps lockfile of 'orders@1.1.0' doesn't match deps 'orders@1.2.0'"},
+		{deps: deps, depsLock: `{"name": "payments", "version": "1.2.0", "lockfileVersion": 1}`, reason: "deps lockfile of 'payments@1.2.0' doesn't match deps 'orders@1.2.0'"},
+		{depsLock: `{"name": "orders", "version": "1.2.0", "lockfileVersion": 1}`, reason: "deps lockfile requires deps"},
+		{deps: deps, depsLock: `orders@1.2.0`},
+		{deps: `dependencies: lodash`, depsLock: `{"name": "orders", "version": "1.2.0"}`},
+	}
+
+	for _, test := range tests {
+		function := newFunction("default", "foo")
+		function.Spec.Deps = test.deps
+		function.Spec.DepsLock = test.depsLock
+
+		allowed, reason := validateDepsLock(function)
+		g.Expect(allowed).To(gomega.Equal(test.allowed), test.depsLock)
+		if test.reason != "" {
+			g.Expect(reason).To(gomega.Equal(test.reason))
+		}
+	}
+}
+
+// Check that a function claiming a route of another function gets rejected by the webhook
+func TestHandleConflictingRoute(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	admissionDecoder, err := admission.NewDecoder(scheme.Scheme)
+	if err != nil {
+		t.Error("Could not create admission decoder")
+	}
+
+	existing := newFunction("default", "bar", runtimev1alpha1.FunctionRoute{Host: "api.example.com", Path: "/orders"})
+	functionCreateUpdateHandler := FunctionCreateUpdateHandler{
+		Client:  fake.NewFakeClient(existing),
+		Decoder: admissionDecoder,
+	}
+
+	req := &admissionv1beta1.AdmissionRequest{
+		Operation: admissionv1beta1.Create,
+		Namespace: "default",
+		Kind: metav1.GroupVersionKind{
+			Group:   "kyma-project.io",
+			Version: "v1alpha1",
+			Kind:    "Function",
+		},
+		Object: runtime.RawExtension{
+			Raw: []byte(`
+{
+	"metadata": {
+		"name": "foo"
+	},
+	"spec": {
+		"function": "foo",
+		"functionContentType": "plaintext",
+		"size": "S",
+		"runtime": "nodejs8",
+		"routes": [{"host": "api.example.com", "path": "/orders"}]
+	}
+}
+`),
+		},
+	}
+
+	response := functionCreateUpdateHandler.Handle(context.TODO(), types.Request{AdmissionRequest: req})
+	g.Expect(response.Response.Allowed).To(gomega.BeFalse())
+}